@@ -0,0 +1,119 @@
+// Package activity observes what the student is doing outside the captured
+// pixels: the focused window, the running user processes, input idle time and
+// coarse desktop events such as clipboard changes and screen locks.
+package activity
+
+import (
+	"sync"
+	"time"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// Window describes the focused top-level window.
+type Window = protocol.Window
+
+// Process is a running user process.
+type Process = protocol.Process
+
+// Snapshot is one observation of the student's desktop. It is sent to the
+// server as is.
+type Snapshot = protocol.ActivityReport
+
+// windowSource is implemented per platform behind build tags.
+type windowSource interface {
+	Foreground() Window
+	Close()
+}
+
+// idleSource reports time since the last keyboard or mouse input.
+// It is implemented per platform behind build tags.
+type idleSource interface {
+	IdleTime() (time.Duration, bool)
+	Close()
+}
+
+// Monitor collects activity snapshots. It keeps platform handles
+// (such as an X11 display connection) open between polls.
+type Monitor struct {
+	mu      sync.Mutex
+	windows windowSource
+	idle    idleSource
+	events  []eventSource
+}
+
+// NewMonitor creates a monitor for the current platform.
+func NewMonitor() *Monitor {
+	return &Monitor{
+		windows: newWindowSource(),
+		idle:    newIdleSource(),
+		events:  newEventSources(),
+	}
+}
+
+// IdleTime returns the time since the last keyboard or mouse input.
+// ok is false when idle detection is not available on this platform.
+func (m *Monitor) IdleTime() (idle time.Duration, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.idle == nil {
+		return 0, false
+	}
+	return m.idle.IdleTime()
+}
+
+// Events returns the desktop events observed since the previous call.
+func (m *Monitor) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []Event
+	for _, source := range m.events {
+		events = append(events, source.Poll()...)
+	}
+	return events
+}
+
+// Snapshot returns the current foreground window and process list.
+func (m *Monitor) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap := Snapshot{
+		Processes: listProcesses(),
+	}
+	if m.windows != nil {
+		snap.Foreground = m.windows.Foreground()
+	}
+
+	if snap.Foreground.PID != 0 && snap.Foreground.Process == "" {
+		for _, p := range snap.Processes {
+			if p.PID == snap.Foreground.PID {
+				snap.Foreground.Process = p.Name
+				break
+			}
+		}
+	}
+
+	return snap
+}
+
+// Close releases platform resources.
+func (m *Monitor) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.windows != nil {
+		m.windows.Close()
+		m.windows = nil
+	}
+	if m.idle != nil {
+		m.idle.Close()
+		m.idle = nil
+	}
+	for _, source := range m.events {
+		source.Close()
+	}
+	m.events = nil
+}
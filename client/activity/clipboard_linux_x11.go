@@ -0,0 +1,248 @@
+//go:build linux && !wayland && cgo
+// +build linux,!wayland,cgo
+
+package activity
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../internal/xtrap
+#cgo LDFLAGS: -lX11 -lXfixes
+
+#include <poll.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+#include <X11/Xlib.h>
+#include <X11/Xatom.h>
+#include <X11/extensions/Xfixes.h>
+#include "xtrap.h"
+
+// How long the clipboard owner gets to answer a conversion
+#define CW_CONVERT_TIMEOUT_MS 200
+
+typedef struct {
+    Display *d;
+    Window w;
+    int fixes_event;
+    Atom clipboard, targets, length, prop;
+} ClipWatch;
+
+static ClipWatch* cw_open(void) {
+    int event_base, error_base;
+
+    Display *d = XOpenDisplay(NULL);
+    if (!d) return NULL;
+    if (!XFixesQueryExtension(d, &event_base, &error_base)) {
+        XCloseDisplay(d);
+        return NULL;
+    }
+
+    ClipWatch *cw = (ClipWatch*)calloc(1, sizeof(ClipWatch));
+    cw->d = d;
+    cw->fixes_event = event_base;
+    cw->w = XCreateSimpleWindow(d, DefaultRootWindow(d), 0, 0, 1, 1, 0, 0, 0);
+    cw->clipboard = XInternAtom(d, "CLIPBOARD", False);
+    cw->targets = XInternAtom(d, "TARGETS", False);
+    cw->length = XInternAtom(d, "LENGTH", False);
+    cw->prop = XInternAtom(d, "EXAM_MONITOR_CLIP", False);
+
+    XFixesSelectSelectionInput(d, cw->w, cw->clipboard, XFixesSetSelectionOwnerNotifyMask);
+    XFlush(d);
+    return cw;
+}
+
+static void cw_close(ClipWatch *cw) {
+    XDestroyWindow(cw->d, cw->w);
+    XCloseDisplay(cw->d);
+    free(cw);
+}
+
+// Returns 1 if the clipboard owner changed since the last call.
+static int cw_changed(ClipWatch *cw) {
+    int changed = 0;
+    XEvent ev;
+    while (XPending(cw->d)) {
+        XNextEvent(cw->d, &ev);
+        if (ev.type == cw->fixes_event + XFixesSelectionNotify) changed = 1;
+    }
+    return changed;
+}
+
+static long cw_ms_since(struct timespec *start) {
+    struct timespec now;
+    clock_gettime(CLOCK_MONOTONIC, &now);
+    return (now.tv_sec - start->tv_sec) * 1000 + (now.tv_nsec - start->tv_nsec) / 1000000;
+}
+
+// Waits up to timeout_ms for the SelectionNotify answering a conversion,
+// sleeping in poll until the X connection has something to read.
+static int cw_wait_selection(ClipWatch *cw, XEvent *ev, int timeout_ms) {
+    struct timespec start;
+    clock_gettime(CLOCK_MONOTONIC, &start);
+    for (;;) {
+        // Also reads whatever arrived, leaving other events queued
+        if (XCheckTypedWindowEvent(cw->d, cw->w, SelectionNotify, ev)) return 1;
+
+        long left = timeout_ms - cw_ms_since(&start);
+        if (left <= 0) return 0;
+        struct pollfd fd = {ConnectionNumber(cw->d), POLLIN, 0};
+        if (poll(&fd, 1, (int)left) == 0) return 0;
+    }
+}
+
+// Asks the owner to convert the clipboard to target and waits for the
+// reply. Returns 1 if the property was set.
+static int cw_convert(ClipWatch *cw, Atom target) {
+    XEvent ev;
+    xtrap_begin(cw->d);
+    XDeleteProperty(cw->d, cw->w, cw->prop);
+    XConvertSelection(cw->d, cw->clipboard, target, cw->prop, cw->w, CurrentTime);
+    if (xtrap_end(cw->d)) return 0;
+
+    if (!cw_wait_selection(cw, &ev, CW_CONVERT_TIMEOUT_MS)) return 0;
+    return ev.xselection.property != None;
+}
+
+// Reads and deletes the converted property, which must be of type. Returns
+// the item count, with the items in *data to be freed with XFree.
+static unsigned long cw_read(ClipWatch *cw, Atom type, unsigned char **data) {
+    Atom actual;
+    int format;
+    unsigned long n = 0, after;
+
+    *data = NULL;
+    xtrap_begin(cw->d);
+    int status = XGetWindowProperty(cw->d, cw->w, cw->prop, 0, 256, True, type,
+                                    &actual, &format, &n, &after, data);
+    if (xtrap_end(cw->d) || status != Success || !*data || actual != type || format != 32) {
+        if (*data) XFree(*data);
+        *data = NULL;
+        return 0;
+    }
+    return n;
+}
+
+static int cw_is_text(Display *d, Atom a) {
+    return a == XA_STRING || a == XInternAtom(d, "UTF8_STRING", False) ||
+           a == XInternAtom(d, "text/plain", False) ||
+           a == XInternAtom(d, "text/plain;charset=utf-8", False);
+}
+
+// Picks the most descriptive target advertised by the owner: an image or
+// file list if present, otherwise text. Returns None if nothing usable.
+// has_length is set if the owner can tell the size through LENGTH.
+static Atom cw_pick_target(ClipWatch *cw, int *has_length) {
+    Atom *atoms = NULL, text = None, other = None;
+
+    *has_length = 0;
+    if (!cw_convert(cw, cw->targets)) return None;
+    unsigned long n = cw_read(cw, XA_ATOM, (unsigned char**)&atoms);
+    if (!atoms) return None;
+
+    // The owner may list atoms that don't exist
+    Atom picked = None;
+    xtrap_begin(cw->d);
+    for (unsigned long i = 0; i < n; i++) {
+        if (atoms[i] == cw->length) {
+            *has_length = 1;
+            continue;
+        }
+        if (picked != None) continue;
+        char *name = XGetAtomName(cw->d, atoms[i]);
+        if (!name) continue;
+        if (strncmp(name, "image/", 6) == 0 || strcmp(name, "text/uri-list") == 0) {
+            picked = atoms[i];
+        } else if (text == None && cw_is_text(cw->d, atoms[i])) {
+            text = atoms[i];
+        } else if (other == None && strchr(name, '/') != NULL) {
+            other = atoms[i];
+        }
+        XFree(name);
+    }
+    xtrap_end(cw->d);
+    XFree(atoms);
+
+    if (picked == None) picked = text != None ? text : other;
+    return picked;
+}
+
+// Reports the type and size of the clipboard without reading its content,
+// which would make the owner transfer all of it. The size comes from the
+// ICCCM LENGTH target; it is -1 when the owner doesn't offer it.
+static void cw_describe(ClipWatch *cw, char *buf, int len, long *size) {
+    int has_length;
+    Atom target = cw_pick_target(cw, &has_length);
+    buf[0] = 0;
+    *size = -1;
+    if (target == None) return;
+
+    if (cw_is_text(cw->d, target)) {
+        snprintf(buf, len, "text");
+    } else {
+        xtrap_begin(cw->d);
+        char *name = XGetAtomName(cw->d, target);
+        xtrap_end(cw->d);
+        if (name) {
+            snprintf(buf, len, "%s", name);
+            XFree(name);
+        }
+    }
+
+    if (!has_length || !cw_convert(cw, cw->length)) return;
+    long *length = NULL;
+    if (cw_read(cw, XA_INTEGER, (unsigned char**)&length) > 0 && *length >= 0) {
+        *size = *length;
+    }
+    if (length) XFree(length);
+}
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	_ "github.com/exam-gaurd/client/internal/xtrap"
+)
+
+// x11Clipboard watches CLIPBOARD ownership changes with XFixes.
+type x11Clipboard struct {
+	watch *C.ClipWatch
+	buf   *C.char
+}
+
+func newClipboardSource() eventSource {
+	watch := C.cw_open()
+	if watch == nil {
+		return nil
+	}
+	return &x11Clipboard{
+		watch: watch,
+		buf:   (*C.char)(C.malloc(windowTextLen)),
+	}
+}
+
+func (c *x11Clipboard) Poll() []Event {
+	if C.cw_changed(c.watch) == 0 {
+		return nil
+	}
+
+	var size C.long
+	C.cw_describe(c.watch, c.buf, windowTextLen, &size)
+
+	return []Event{{
+		Kind:   EventClipboard,
+		Format: C.GoString(c.buf),
+		Size:   int64(size),
+	}}
+}
+
+func (c *x11Clipboard) Close() {
+	if c.watch != nil {
+		C.cw_close(c.watch)
+		c.watch = nil
+	}
+	if c.buf != nil {
+		C.free(unsafe.Pointer(c.buf))
+		c.buf = nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !(linux && !wayland && cgo)
+// +build !windows
+// +build !linux wayland !cgo
+
+package activity
+
+// newClipboardSource returns nil where clipboard monitoring is not implemented yet.
+func newClipboardSource() eventSource {
+	return nil
+}
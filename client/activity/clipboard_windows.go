@@ -0,0 +1,80 @@
+//go:build windows
+// +build windows
+
+package activity
+
+const (
+	cfText        = 1
+	cfDIB         = 8
+	cfUnicodeText = 13
+	cfHDrop       = 15
+)
+
+var (
+	procGetClipboardSequenceNumber = user32.NewProc("GetClipboardSequenceNumber")
+	procIsClipboardFormatAvailable = user32.NewProc("IsClipboardFormatAvailable")
+	procOpenClipboard              = user32.NewProc("OpenClipboard")
+	procCloseClipboard             = user32.NewProc("CloseClipboard")
+	procGetClipboardData           = user32.NewProc("GetClipboardData")
+	procGlobalSize                 = kernel32.NewProc("GlobalSize")
+)
+
+// clipboardFormats are checked in order; the first available one names the content.
+var clipboardFormats = []struct {
+	id   uintptr
+	name string
+}{
+	{cfDIB, "image/bmp"},
+	{cfHDrop, "text/uri-list"},
+	{cfUnicodeText, "text"},
+	{cfText, "text"},
+}
+
+// windowsClipboard polls the clipboard sequence number, which changes on
+// every clipboard write without needing a message window.
+type windowsClipboard struct {
+	seq uintptr
+}
+
+func newClipboardSource() eventSource {
+	if procGetClipboardSequenceNumber.Find() != nil {
+		return nil
+	}
+	seq, _, _ := procGetClipboardSequenceNumber.Call()
+	return &windowsClipboard{seq: seq}
+}
+
+func (c *windowsClipboard) Poll() []Event {
+	seq, _, _ := procGetClipboardSequenceNumber.Call()
+	if seq == c.seq {
+		return nil
+	}
+	c.seq = seq
+
+	ev := Event{Kind: EventClipboard, Size: -1}
+	for _, format := range clipboardFormats {
+		if ok, _, _ := procIsClipboardFormatAvailable.Call(format.id); ok != 0 {
+			ev.Format = format.name
+			ev.Size = clipboardSize(format.id)
+			break
+		}
+	}
+	return []Event{ev}
+}
+
+// clipboardSize returns the size of the clipboard data handle without reading it.
+func clipboardSize(format uintptr) int64 {
+	if ok, _, _ := procOpenClipboard.Call(0); ok == 0 {
+		return -1
+	}
+	defer procCloseClipboard.Call()
+
+	handle, _, _ := procGetClipboardData.Call(format)
+	if handle == 0 {
+		return -1
+	}
+	size, _, _ := procGlobalSize.Call(handle)
+	return int64(size)
+}
+
+func (c *windowsClipboard) Close() {}
@@ -0,0 +1,40 @@
+package activity
+
+import "github.com/exam-gaurd/protocol"
+
+// Event kinds. Events are coarse and privacy-preserving: clipboard events
+// carry only the content type and size, never the content.
+const (
+	EventClipboard       = protocol.EventClipboard
+	EventScreenLocked    = protocol.EventScreenLocked
+	EventScreenUnlocked  = protocol.EventScreenUnlocked
+	EventSessionSwitched = protocol.EventSessionSwitched
+)
+
+// Event is a desktop event observed since the last poll.
+type Event struct {
+	Kind string `json:"kind"`
+	// Format is the clipboard content type, e.g. "text" or "image/png".
+	Format string `json:"format,omitempty"`
+	// Size is the clipboard content size in bytes, -1 if unknown.
+	Size int64 `json:"size,omitempty"`
+	// Detail is "away" or "back" for session switches.
+	Detail string `json:"detail,omitempty"`
+}
+
+// eventSource is implemented per platform behind build tags.
+type eventSource interface {
+	Poll() []Event
+	Close()
+}
+
+func newEventSources() []eventSource {
+	var sources []eventSource
+	if s := newClipboardSource(); s != nil {
+		sources = append(sources, s)
+	}
+	if s := newSessionSource(); s != nil {
+		sources = append(sources, s)
+	}
+	return sources
+}
@@ -0,0 +1,33 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package activity
+
+/*
+#cgo LDFLAGS: -framework CoreGraphics
+
+#include <CoreGraphics/CoreGraphics.h>
+
+static double idle_seconds(void) {
+    return CGEventSourceSecondsSinceLastEventType(kCGEventSourceStateHIDSystemState, kCGAnyInputEventType);
+}
+*/
+import "C"
+import "time"
+
+// darwinIdle reads input idle time from the HID event source.
+type darwinIdle struct{}
+
+func newIdleSource() idleSource {
+	return darwinIdle{}
+}
+
+func (darwinIdle) IdleTime() (time.Duration, bool) {
+	seconds := float64(C.idle_seconds())
+	if seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func (darwinIdle) Close() {}
@@ -0,0 +1,59 @@
+//go:build linux && !wayland && cgo
+// +build linux,!wayland,cgo
+
+package activity
+
+/*
+#cgo LDFLAGS: -lX11 -lXss
+
+#include <stdlib.h>
+#include <X11/Xlib.h>
+#include <X11/extensions/scrnsaver.h>
+
+// Returns milliseconds since the last keyboard/mouse input, or -1 if
+// the MIT-SCREEN-SAVER extension is unavailable.
+static long idle_query(Display *d) {
+    int event_base, error_base;
+    if (!XScreenSaverQueryExtension(d, &event_base, &error_base)) return -1;
+
+    XScreenSaverInfo *info = XScreenSaverAllocInfo();
+    if (!info) return -1;
+
+    long idle = -1;
+    if (XScreenSaverQueryInfo(d, DefaultRootWindow(d), info)) {
+        idle = (long)info->idle;
+    }
+    XFree(info);
+    return idle;
+}
+*/
+import "C"
+import "time"
+
+// x11Idle reads input idle time from the XScreenSaver extension.
+type x11Idle struct {
+	display *C.Display
+}
+
+func newIdleSource() idleSource {
+	display := C.XOpenDisplay(nil)
+	if display == nil {
+		return nil
+	}
+	return &x11Idle{display: display}
+}
+
+func (s *x11Idle) IdleTime() (time.Duration, bool) {
+	ms := C.idle_query(s.display)
+	if ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+func (s *x11Idle) Close() {
+	if s.display != nil {
+		C.XCloseDisplay(s.display)
+		s.display = nil
+	}
+}
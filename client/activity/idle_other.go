@@ -0,0 +1,11 @@
+//go:build !windows && !(linux && !wayland && cgo) && !(darwin && cgo)
+// +build !windows
+// +build !linux wayland !cgo
+// +build !darwin !cgo
+
+package activity
+
+// newIdleSource returns nil where idle detection is not implemented yet.
+func newIdleSource() idleSource {
+	return nil
+}
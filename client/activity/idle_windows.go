@@ -0,0 +1,41 @@
+//go:build windows
+// +build windows
+
+package activity
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	user32               = syscall.NewLazyDLL("user32.dll")
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procGetLastInputInfo = user32.NewProc("GetLastInputInfo")
+	procGetTickCount     = kernel32.NewProc("GetTickCount")
+)
+
+type lastInputInfo struct {
+	cbSize uint32
+	dwTime uint32
+}
+
+// windowsIdle reads input idle time with GetLastInputInfo.
+type windowsIdle struct{}
+
+func newIdleSource() idleSource {
+	return windowsIdle{}
+}
+
+func (windowsIdle) IdleTime() (time.Duration, bool) {
+	info := lastInputInfo{cbSize: uint32(unsafe.Sizeof(lastInputInfo{}))}
+	if ret, _, _ := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info))); ret == 0 {
+		return 0, false
+	}
+	now, _, _ := procGetTickCount.Call()
+	// Tick counts wrap after ~49 days; unsigned subtraction handles it.
+	return time.Duration(uint32(now)-info.dwTime) * time.Millisecond, true
+}
+
+func (windowsIdle) Close() {}
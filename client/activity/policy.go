@@ -0,0 +1,80 @@
+package activity
+
+import (
+	"strings"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// Violation rule names.
+const (
+	RuleProcess = protocol.RuleProcess
+	RuleTitle   = protocol.RuleTitle
+	RuleURL     = protocol.RuleURL
+)
+
+// Policy holds the exam rules pushed by the server after join.
+// All matching is case-insensitive substring matching.
+type Policy protocol.Policy
+
+// Violation is a single policy breach found in a snapshot.
+type Violation struct {
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+// Key identifies a violation so it is only reported once while it persists.
+func (v Violation) Key() string {
+	return v.Rule + "\x00" + v.Detail
+}
+
+// browserClasses identify foreground windows whose titles carry the page being viewed.
+var browserClasses = []string{"firefox", "chrome", "chromium", "msedge", "edge", "brave", "opera", "vivaldi", "safari"}
+
+// Evaluate checks a snapshot against the policy.
+func (p *Policy) Evaluate(snap Snapshot) []Violation {
+	var violations []Violation
+
+	seen := make(map[string]bool)
+	for _, proc := range snap.Processes {
+		if pattern := matchAny(proc.Name, p.BlockedProcesses); pattern != "" && !seen[proc.Name] {
+			seen[proc.Name] = true
+			violations = append(violations, Violation{Rule: RuleProcess, Detail: proc.Name})
+		}
+	}
+
+	title := snap.Foreground.Title
+	if pattern := matchAny(title, p.BlockedTitles); pattern != "" {
+		violations = append(violations, Violation{Rule: RuleTitle, Detail: title})
+	}
+
+	if len(p.AllowedURLKeywords) > 0 && isBrowser(snap.Foreground) && title != "" {
+		if matchAny(title, p.AllowedURLKeywords) == "" {
+			violations = append(violations, Violation{Rule: RuleURL, Detail: title})
+		}
+	}
+
+	return violations
+}
+
+// matchAny returns the first pattern contained in s, or "".
+func matchAny(s string, patterns []string) string {
+	s = strings.ToLower(s)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern != "" && strings.Contains(s, pattern) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+func isBrowser(w Window) bool {
+	name := strings.ToLower(w.Class + " " + w.Process)
+	for _, browser := range browserClasses {
+		if strings.Contains(name, browser) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package activity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	policy := &Policy{
+		BlockedProcesses:   []string{"Discord", " teams ", ""},
+		BlockedTitles:      []string{"ChatGPT"},
+		AllowedURLKeywords: []string{"moodle", "Exam Portal"},
+	}
+	firefox := func(title string) Window {
+		return Window{Title: title, Class: "Navigator.Firefox", Process: "firefox"}
+	}
+
+	tests := []struct {
+		name   string
+		policy *Policy
+		snap   Snapshot
+		want   []Violation
+	}{
+		{
+			"allowed site",
+			policy,
+			Snapshot{Foreground: firefox("Quiz 3 - MOODLE - Mozilla Firefox")},
+			nil,
+		},
+		{
+			"site not allowed",
+			policy,
+			Snapshot{Foreground: firefox("Stack Overflow - Mozilla Firefox")},
+			[]Violation{{Rule: RuleURL, Detail: "Stack Overflow - Mozilla Firefox"}},
+		},
+		{
+			"browser found by process",
+			policy,
+			Snapshot{Foreground: Window{Title: "Wikipedia", Process: "msedge.exe"}},
+			[]Violation{{Rule: RuleURL, Detail: "Wikipedia"}},
+		},
+		{
+			"keywords only apply to browsers",
+			policy,
+			Snapshot{Foreground: Window{Title: "Untitled - Notepad", Class: "Notepad"}},
+			nil,
+		},
+		{
+			"browser without a title",
+			policy,
+			Snapshot{Foreground: firefox("")},
+			nil,
+		},
+		{
+			"no keywords allow every site",
+			&Policy{BlockedTitles: policy.BlockedTitles},
+			Snapshot{Foreground: firefox("Stack Overflow")},
+			nil,
+		},
+		{
+			"blocked title in any case",
+			policy,
+			Snapshot{Foreground: Window{Title: "chatgpt - desktop", Class: "ChatGPT"}},
+			[]Violation{{Rule: RuleTitle, Detail: "chatgpt - desktop"}},
+		},
+		{
+			"blocked title in a browser",
+			policy,
+			Snapshot{Foreground: firefox("ChatGPT - Mozilla Firefox")},
+			[]Violation{
+				{Rule: RuleTitle, Detail: "ChatGPT - Mozilla Firefox"},
+				{Rule: RuleURL, Detail: "ChatGPT - Mozilla Firefox"},
+			},
+		},
+		{
+			"blocked processes by substring, once each",
+			policy,
+			Snapshot{Processes: []Process{
+				{PID: 1, Name: "discord"},
+				{PID: 2, Name: "Discord Helper"},
+				{PID: 3, Name: "discord"},
+				{PID: 4, Name: "ms-teams.exe"},
+				{PID: 5, Name: "bash"},
+			}},
+			[]Violation{
+				{Rule: RuleProcess, Detail: "discord"},
+				{Rule: RuleProcess, Detail: "Discord Helper"},
+				{Rule: RuleProcess, Detail: "ms-teams.exe"},
+			},
+		},
+		{
+			"empty patterns match nothing",
+			&Policy{BlockedProcesses: []string{"", "  "}, BlockedTitles: []string{""}},
+			Snapshot{Foreground: Window{Title: "Terminal"}, Processes: []Process{{Name: "bash"}}},
+			nil,
+		},
+		{"empty policy", &Policy{}, Snapshot{Foreground: firefox("Anything")}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Evaluate(tt.snap); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBrowser(t *testing.T) {
+	tests := []struct {
+		window Window
+		want   bool
+	}{
+		{Window{Class: "Google-chrome"}, true},
+		{Window{Class: "Chromium-browser"}, true},
+		{Window{Process: "brave.exe"}, true},
+		{Window{Class: "Safari"}, true},
+		{Window{Class: "Code", Process: "code"}, false},
+		{Window{}, false},
+	}
+	for _, tt := range tests {
+		if got := isBrowser(tt.window); got != tt.want {
+			t.Errorf("isBrowser(%+v) = %v, want %v", tt.window, got, tt.want)
+		}
+	}
+}
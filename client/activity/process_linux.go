@@ -0,0 +1,75 @@
+//go:build linux
+// +build linux
+
+package activity
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listProcesses reads /proc and returns processes owned by the current user.
+// Kernel threads (empty cmdline) are skipped.
+func listProcesses() []Process {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	var procs []Process
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join("/proc", entry.Name())
+		if procUID(dir) != uid {
+			continue
+		}
+
+		cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil {
+			continue
+		}
+
+		procs = append(procs, Process{
+			PID:  pid,
+			Name: strings.TrimSpace(string(comm)),
+		})
+	}
+
+	return procs
+}
+
+// procUID returns the real UID from /proc/<pid>/status.
+func procUID(dir string) string {
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Uid:") {
+			fields := strings.Fields(line[4:])
+			if len(fields) > 0 {
+				return fields[0]
+			}
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package activity
+
+// listProcesses is not implemented on this platform yet.
+func listProcesses() []Process {
+	return nil
+}
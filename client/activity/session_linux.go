@@ -0,0 +1,173 @@
+//go:build linux
+// +build linux
+
+package activity
+
+import (
+	"os"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	login1Dest          = "org.freedesktop.login1"
+	login1ManagerPath   = "/org/freedesktop/login1"
+	login1ManagerIface  = "org.freedesktop.login1.Manager"
+	login1SessionIface  = "org.freedesktop.login1.Session"
+	dbusPropertiesIface = "org.freedesktop.DBus.Properties"
+)
+
+// screenSaverIfaces emit ActiveChanged(bool) when the screen locks or unlocks.
+var screenSaverIfaces = []string{"org.freedesktop.ScreenSaver", "org.gnome.ScreenSaver"}
+
+// dbusSession listens for lock and session switch signals from logind on
+// the system bus and from the desktop screensaver on the session bus.
+// Signals arrive asynchronously and are drained on Poll.
+type dbusSession struct {
+	system  *dbus.Conn
+	session *dbus.Conn
+	signals chan *dbus.Signal
+
+	sessionPath dbus.ObjectPath
+	locked      bool
+	active      bool
+}
+
+func newSessionSource() eventSource {
+	s := &dbusSession{
+		signals: make(chan *dbus.Signal, 32),
+		active:  true,
+	}
+
+	if conn, err := dbus.ConnectSystemBus(); err == nil {
+		if path, ok := login1Session(conn); ok {
+			s.sessionPath = path
+			conn.AddMatchSignal(
+				dbus.WithMatchObjectPath(path),
+				dbus.WithMatchInterface(login1SessionIface),
+			)
+			conn.AddMatchSignal(
+				dbus.WithMatchObjectPath(path),
+				dbus.WithMatchInterface(dbusPropertiesIface),
+				dbus.WithMatchMember("PropertiesChanged"),
+			)
+			conn.Signal(s.signals)
+			s.system = conn
+		} else {
+			conn.Close()
+		}
+	}
+
+	if conn, err := dbus.ConnectSessionBus(); err == nil {
+		for _, iface := range screenSaverIfaces {
+			conn.AddMatchSignal(
+				dbus.WithMatchInterface(iface),
+				dbus.WithMatchMember("ActiveChanged"),
+			)
+		}
+		conn.Signal(s.signals)
+		s.session = conn
+	}
+
+	if s.system == nil && s.session == nil {
+		return nil
+	}
+	return s
+}
+
+// login1Session finds the logind session this process belongs to.
+func login1Session(conn *dbus.Conn) (dbus.ObjectPath, bool) {
+	manager := conn.Object(login1Dest, login1ManagerPath)
+
+	var path dbus.ObjectPath
+	if err := manager.Call(login1ManagerIface+".GetSessionByPID", 0, uint32(os.Getpid())).Store(&path); err == nil {
+		return path, true
+	}
+	if id := os.Getenv("XDG_SESSION_ID"); id != "" {
+		if err := manager.Call(login1ManagerIface+".GetSession", 0, id).Store(&path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func (s *dbusSession) Poll() []Event {
+	var events []Event
+	for {
+		select {
+		case sig := <-s.signals:
+			if ev, ok := s.handle(sig); ok {
+				events = append(events, ev)
+			}
+		default:
+			return events
+		}
+	}
+}
+
+// handle turns a signal into an event. The lock state is tracked so a lock
+// reported by both logind and the screensaver yields a single event.
+func (s *dbusSession) handle(sig *dbus.Signal) (Event, bool) {
+	switch sig.Name {
+	case login1SessionIface + ".Lock":
+		return s.setLocked(true)
+	case login1SessionIface + ".Unlock":
+		return s.setLocked(false)
+	case dbusPropertiesIface + ".PropertiesChanged":
+		if sig.Path != s.sessionPath || len(sig.Body) < 2 {
+			return Event{}, false
+		}
+		changed, ok := sig.Body[1].(map[string]dbus.Variant)
+		if !ok {
+			return Event{}, false
+		}
+		if v, ok := changed["LockedHint"]; ok {
+			if locked, ok := v.Value().(bool); ok {
+				return s.setLocked(locked)
+			}
+		}
+		if v, ok := changed["Active"]; ok {
+			if active, ok := v.Value().(bool); ok && active != s.active {
+				s.active = active
+				detail := "back"
+				if !active {
+					detail = "away"
+				}
+				return Event{Kind: EventSessionSwitched, Detail: detail}, true
+			}
+		}
+	default:
+		for _, iface := range screenSaverIfaces {
+			if sig.Name == iface+".ActiveChanged" && len(sig.Body) > 0 {
+				if locked, ok := sig.Body[0].(bool); ok {
+					return s.setLocked(locked)
+				}
+			}
+		}
+	}
+	return Event{}, false
+}
+
+func (s *dbusSession) setLocked(locked bool) (Event, bool) {
+	if locked == s.locked {
+		return Event{}, false
+	}
+	s.locked = locked
+	if locked {
+		return Event{Kind: EventScreenLocked}, true
+	}
+	return Event{Kind: EventScreenUnlocked}, true
+}
+
+func (s *dbusSession) Close() {
+	if s.system != nil {
+		s.system.RemoveSignal(s.signals)
+		s.system.Close()
+		s.system = nil
+	}
+	if s.session != nil {
+		s.session.RemoveSignal(s.signals)
+		s.session.Close()
+		s.session = nil
+	}
+}
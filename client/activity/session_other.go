@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package activity
+
+// newSessionSource returns nil where lock and session detection is not implemented yet.
+func newSessionSource() eventSource {
+	return nil
+}
@@ -0,0 +1,91 @@
+//go:build windows
+// +build windows
+
+package activity
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	desktopReadObjects = 0x0001
+	uoiName            = 2
+)
+
+var (
+	procOpenInputDesktop             = user32.NewProc("OpenInputDesktop")
+	procCloseDesktop                 = user32.NewProc("CloseDesktop")
+	procGetUserObjectInformation     = user32.NewProc("GetUserObjectInformationW")
+	procWTSGetActiveConsoleSessionId = kernel32.NewProc("WTSGetActiveConsoleSessionId")
+	procProcessIdToSessionId         = kernel32.NewProc("ProcessIdToSessionId")
+)
+
+// windowsSession polls the input desktop and the active console session.
+// While the workstation is locked the input desktop is "Winlogon" (or
+// cannot be opened at all); after a fast user switch the console session
+// no longer matches ours.
+type windowsSession struct {
+	sessionID uint32
+	locked    bool
+	active    bool
+}
+
+func newSessionSource() eventSource {
+	var id uint32
+	if ok, _, _ := procProcessIdToSessionId.Call(uintptr(syscall.Getpid()), uintptr(unsafe.Pointer(&id))); ok == 0 {
+		return nil
+	}
+	s := &windowsSession{sessionID: id}
+	s.locked = inputDesktopLocked()
+	s.active = s.consoleActive()
+	return s
+}
+
+func (s *windowsSession) Poll() []Event {
+	var events []Event
+
+	if locked := inputDesktopLocked(); locked != s.locked {
+		s.locked = locked
+		if locked {
+			events = append(events, Event{Kind: EventScreenLocked})
+		} else {
+			events = append(events, Event{Kind: EventScreenUnlocked})
+		}
+	}
+
+	if active := s.consoleActive(); active != s.active {
+		s.active = active
+		detail := "back"
+		if !active {
+			detail = "away"
+		}
+		events = append(events, Event{Kind: EventSessionSwitched, Detail: detail})
+	}
+
+	return events
+}
+
+func (s *windowsSession) consoleActive() bool {
+	console, _, _ := procWTSGetActiveConsoleSessionId.Call()
+	return uint32(console) == s.sessionID
+}
+
+func inputDesktopLocked() bool {
+	desk, _, _ := procOpenInputDesktop.Call(0, 0, desktopReadObjects)
+	if desk == 0 {
+		return true
+	}
+	defer procCloseDesktop.Call(desk)
+
+	var name [64]uint16
+	var needed uint32
+	ok, _, _ := procGetUserObjectInformation.Call(desk, uoiName,
+		uintptr(unsafe.Pointer(&name[0])), uintptr(len(name)*2), uintptr(unsafe.Pointer(&needed)))
+	if ok == 0 {
+		return false
+	}
+	return syscall.UTF16ToString(name[:]) != "Default"
+}
+
+func (s *windowsSession) Close() {}
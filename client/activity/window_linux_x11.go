@@ -0,0 +1,145 @@
+//go:build linux && !wayland && cgo
+// +build linux,!wayland,cgo
+
+package activity
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../internal/xtrap
+#cgo LDFLAGS: -lX11
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <X11/Xlib.h>
+#include <X11/Xatom.h>
+#include <X11/Xutil.h>
+#include "xtrap.h"
+
+// Windows can disappear between queries; the default Xlib error handler
+// would exit the process on the resulting BadWindow. Only the reads are
+// trapped, so the rest of the process keeps its own handler.
+
+static unsigned char* aw_get_property(Display *d, Window w, Atom prop, Atom type, unsigned long *nitems) {
+    Atom actual_type;
+    int actual_format;
+    unsigned long bytes_after;
+    unsigned char *data = NULL;
+
+    *nitems = 0;
+    xtrap_begin(d);
+    int status = XGetWindowProperty(d, w, prop, 0, 1024, False, type,
+                                    &actual_type, &actual_format, nitems, &bytes_after, &data);
+    if (xtrap_end(d) || status != Success) {
+        if (data) XFree(data);
+        *nitems = 0;
+        return NULL;
+    }
+    if (actual_type == None) {
+        if (data) XFree(data);
+        *nitems = 0;
+        return NULL;
+    }
+    return data;
+}
+
+// Returns the window named by _NET_ACTIVE_WINDOW on the root, or 0.
+static Window aw_active_window(Display *d) {
+    unsigned long n = 0;
+    unsigned char *data = aw_get_property(d, DefaultRootWindow(d),
+        XInternAtom(d, "_NET_ACTIVE_WINDOW", False), XA_WINDOW, &n);
+    Window w = 0;
+    if (data && n > 0) w = *(Window*)data;
+    if (data) XFree(data);
+    return w;
+}
+
+static void aw_window_title(Display *d, Window w, char *buf, int len) {
+    unsigned long n = 0;
+    unsigned char *data = aw_get_property(d, w,
+        XInternAtom(d, "_NET_WM_NAME", False), XInternAtom(d, "UTF8_STRING", False), &n);
+    if (!data) {
+        data = aw_get_property(d, w, XA_WM_NAME, AnyPropertyType, &n);
+    }
+    buf[0] = 0;
+    if (data) {
+        snprintf(buf, len, "%.*s", (int)n, (char*)data);
+        XFree(data);
+    }
+}
+
+static void aw_window_class(Display *d, Window w, char *buf, int len) {
+    XClassHint hint = {NULL, NULL};
+    buf[0] = 0;
+    xtrap_begin(d);
+    Status ok = XGetClassHint(d, w, &hint);
+    if (xtrap_end(d)) ok = 0;
+    if (ok && hint.res_class) snprintf(buf, len, "%s", hint.res_class);
+    if (hint.res_name) XFree(hint.res_name);
+    if (hint.res_class) XFree(hint.res_class);
+}
+
+static long aw_window_pid(Display *d, Window w) {
+    unsigned long n = 0;
+    unsigned char *data = aw_get_property(d, w,
+        XInternAtom(d, "_NET_WM_PID", False), XA_CARDINAL, &n);
+    long pid = 0;
+    if (data && n > 0) pid = *(long*)data;
+    if (data) XFree(data);
+    return pid;
+}
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	_ "github.com/exam-gaurd/client/internal/xtrap"
+)
+
+const windowTextLen = 512
+
+// x11Windows reads the focused window through EWMH properties on the root window.
+type x11Windows struct {
+	display *C.Display
+	buf     *C.char
+}
+
+func newWindowSource() windowSource {
+	display := C.XOpenDisplay(nil)
+	if display == nil {
+		return nil
+	}
+	return &x11Windows{
+		display: display,
+		buf:     (*C.char)(C.malloc(windowTextLen)),
+	}
+}
+
+func (w *x11Windows) Foreground() Window {
+	win := C.aw_active_window(w.display)
+	if win == 0 {
+		return Window{}
+	}
+
+	var result Window
+
+	C.aw_window_title(w.display, win, w.buf, windowTextLen)
+	result.Title = C.GoString(w.buf)
+
+	C.aw_window_class(w.display, win, w.buf, windowTextLen)
+	result.Class = C.GoString(w.buf)
+
+	result.PID = int(C.aw_window_pid(w.display, win))
+
+	return result
+}
+
+func (w *x11Windows) Close() {
+	if w.display != nil {
+		C.XCloseDisplay(w.display)
+		w.display = nil
+	}
+	if w.buf != nil {
+		C.free(unsafe.Pointer(w.buf))
+		w.buf = nil
+	}
+}
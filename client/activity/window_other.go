@@ -0,0 +1,9 @@
+//go:build !linux || wayland || !cgo
+// +build !linux wayland !cgo
+
+package activity
+
+// newWindowSource returns nil where foreground window lookup is not implemented yet.
+func newWindowSource() windowSource {
+	return nil
+}
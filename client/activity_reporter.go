@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/exam-gaurd/client/activity"
+	"github.com/exam-gaurd/protocol"
+)
+
+const (
+	ACTIVITY_INTERVAL  = 2 * time.Second
+	ACTIVITY_HEARTBEAT = 10 * time.Second
+	IDLE_THRESHOLD     = 60 * time.Second // No input for this long is reported as idle
+)
+
+// runActivityLoop periodically reports the foreground window and running
+// processes, evaluates the exam policy against them and reports idle periods
+// and desktop events.
+// Unchanged snapshots are only resent as a heartbeat.
+func (client *Client) runActivityLoop(done <-chan struct{}) {
+	monitor := activity.NewMonitor()
+	defer monitor.Close()
+
+	ticker := time.NewTicker(ACTIVITY_INTERVAL)
+	defer ticker.Stop()
+
+	var last activity.Snapshot
+	var lastSent time.Time
+	active := make(map[string]bool)
+	var lastIdle time.Duration
+	isIdle := false
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if !client.isConnected.Load() {
+			return
+		}
+
+		if idle, ok := monitor.IdleTime(); ok {
+			switch {
+			case !isIdle && idle >= IDLE_THRESHOLD:
+				isIdle = true
+				client.SendReport(protocol.MsgIdle, protocol.IdleReport{Idle: true, IdleMs: idle.Milliseconds()})
+			case isIdle && idle < IDLE_THRESHOLD:
+				isIdle = false
+				// Input resumed somewhere after the last poll
+				client.SendReport(protocol.MsgIdle, protocol.IdleReport{Idle: false, IdleMs: lastIdle.Milliseconds()})
+			}
+			lastIdle = idle
+		}
+
+		for _, ev := range monitor.Events() {
+			client.SendReport(protocol.MsgEvent, eventReport(ev))
+		}
+
+		snap := monitor.Snapshot()
+
+		if policy := client.policy.Load(); policy != nil {
+			client.reportViolations(policy.Evaluate(snap), active)
+		}
+
+		if reflect.DeepEqual(snap, last) && time.Since(lastSent) < ACTIVITY_HEARTBEAT {
+			continue
+		}
+
+		if err := client.SendReport(protocol.MsgActivity, snap); err != nil {
+			return
+		}
+		last = snap
+		lastSent = time.Now()
+	}
+}
+
+// reportViolations sends violations that were not already active and forgets
+// the ones that cleared, so a breach is reported again if it reappears.
+func (client *Client) reportViolations(violations []activity.Violation, active map[string]bool) {
+	current := make(map[string]bool, len(violations))
+	for _, v := range violations {
+		key := v.Key()
+		current[key] = true
+		if active[key] {
+			continue
+		}
+
+		client.SendReport(protocol.MsgViolation, protocol.ViolationReport{
+			Rule:   v.Rule,
+			Detail: v.Detail,
+			Time:   time.Now(),
+		})
+		client.forceKeyFrame.Store(true)
+	}
+
+	for key := range active {
+		delete(active, key)
+	}
+	for key := range current {
+		active[key] = true
+	}
+}
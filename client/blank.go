@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/exam-gaurd/client/capture"
+	"github.com/exam-gaurd/protocol"
+)
+
+// BLANK_THRESHOLD is how long captured frames must stay a single colour
+// before the capture is reported blank. A static screen sends no frames,
+// so it stays blank until a frame with content arrives.
+const BLANK_THRESHOLD = 10 * time.Second
+
+// blankReporter tells the server when the capture has been a single
+// colour for BLANK_THRESHOLD, and when content shows up again.
+type blankReporter struct {
+	since    time.Time // First of the blank frames in a row, zero otherwise
+	color    string
+	reported bool
+}
+
+// update checks frame, nil if none arrived this tick, and reports a change
+// of the blank condition as of now.
+func (r *blankReporter) update(client *Client, frame *capture.Frame, now time.Time) {
+	if frame != nil {
+		c, blank := capture.UniformColor(frame)
+		switch {
+		case blank && r.since.IsZero():
+			r.since = now
+			r.color = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+		case !blank && !r.since.IsZero():
+			if r.reported {
+				client.SendReport(protocol.MsgBlank, protocol.BlankReport{Blank: false, BlankMs: now.Sub(r.since).Milliseconds()})
+			}
+			r.since = time.Time{}
+			r.reported = false
+		}
+	}
+
+	if !r.reported && !r.since.IsZero() && now.Sub(r.since) >= BLANK_THRESHOLD {
+		r.reported = true
+		client.SendReport(protocol.MsgBlank, protocol.BlankReport{
+			Blank:   true,
+			BlankMs: now.Sub(r.since).Milliseconds(),
+			Color:   r.color,
+		})
+	}
+}
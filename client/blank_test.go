@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/exam-gaurd/client/capture"
+	"github.com/exam-gaurd/protocol"
+)
+
+// fillFrame returns a 32×32 frame of colour c with one differing pixel if
+// content is set.
+func fillFrame(c color.RGBA, content bool) *capture.Frame {
+	f := &capture.Frame{Pix: make([]byte, 32*32*4), W: 32, H: 32, Stride: 32 * 4}
+	for i := 0; i < len(f.Pix); i += 4 {
+		f.Pix[i], f.Pix[i+1], f.Pix[i+2], f.Pix[i+3] = c.R, c.G, c.B, 255
+	}
+	if content {
+		f.Pix[16*f.Stride+16*4] = c.R + 128
+	}
+	return f
+}
+
+func TestBlankReporter(t *testing.T) {
+	client, server := connectedClient(t)
+	black := color.RGBA{R: 0, G: 0, B: 16}
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	var r blankReporter
+	// A short blank spell is never reported
+	r.update(client, fillFrame(black, false), at(0))
+	r.update(client, nil, at(BLANK_THRESHOLD-time.Millisecond))
+	r.update(client, fillFrame(black, true), at(BLANK_THRESHOLD-time.Millisecond))
+
+	// A static blank screen sends no frames; the threshold passes anyway
+	r.update(client, fillFrame(black, false), at(20*time.Second))
+	r.update(client, nil, at(20*time.Second+BLANK_THRESHOLD-time.Millisecond))
+	r.update(client, nil, at(20*time.Second+BLANK_THRESHOLD))
+	// Reported once only
+	r.update(client, fillFrame(black, false), at(32*time.Second))
+	r.update(client, nil, at(40*time.Second))
+
+	// Content ends the blank spell
+	r.update(client, fillFrame(black, true), at(45*time.Second))
+	r.update(client, fillFrame(black, true), at(46*time.Second))
+	client.SendReport(protocol.MsgIdle, struct{}{})
+
+	var report protocol.BlankReport
+	readMessage(t, server, protocol.MsgBlank, &report)
+	want := protocol.BlankReport{Blank: true, BlankMs: BLANK_THRESHOLD.Milliseconds(), Color: "#000010"}
+	if report != want {
+		t.Errorf("blank report %+v, want %+v", report, want)
+	}
+
+	report = protocol.BlankReport{}
+	readMessage(t, server, protocol.MsgBlank, &report)
+	want = protocol.BlankReport{Blank: false, BlankMs: 25000}
+	if report != want {
+		t.Errorf("recovery report %+v, want %+v", report, want)
+	}
+
+	// Nothing else was sent before the marker
+	var marker struct{}
+	readMessage(t, server, protocol.MsgIdle, &marker)
+}
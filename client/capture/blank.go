@@ -0,0 +1,34 @@
+package capture
+
+import "image/color"
+
+// BLANK_TOLERANCE is how far, per channel, a pixel may stray from the
+// first one for a frame to still count as a single colour, to allow for
+// dithering and compression noise.
+const BLANK_TOLERANCE = 8
+
+// UniformColor returns the colour of f if every pixel has about the same
+// colour, as a black screen from a broken compositor or DRM-protected
+// content does. Every other row and column is checked.
+func UniformColor(f *Frame) (color.RGBA, bool) {
+	if f == nil || f.W <= 0 || f.H <= 0 {
+		return color.RGBA{}, false
+	}
+	r, g, b := f.Pix[0], f.Pix[1], f.Pix[2]
+	for y := 0; y < f.H; y += 2 {
+		row := f.Pix[y*f.Stride : y*f.Stride+f.W*4]
+		for i := 0; i < len(row); i += 8 {
+			if absDiff(row[i], r) > BLANK_TOLERANCE || absDiff(row[i+1], g) > BLANK_TOLERANCE || absDiff(row[i+2], b) > BLANK_TOLERANCE {
+				return color.RGBA{}, false
+			}
+		}
+	}
+	return color.RGBA{R: r, G: g, B: b, A: 255}, true
+}
+
+func absDiff(a, b byte) byte {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
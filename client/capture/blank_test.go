@@ -0,0 +1,81 @@
+package capture
+
+import (
+	"image/color"
+	"testing"
+)
+
+// solidFrame returns a w×h frame of one colour, with a padded stride.
+func solidFrame(w, h int, c color.RGBA) *Frame {
+	f := &Frame{Pix: make([]byte, (w*4+16)*h), W: w, H: h, Stride: w*4 + 16}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			setPixel(f, x, y, c)
+		}
+	}
+	return f
+}
+
+func setPixel(f *Frame, x, y int, c color.RGBA) {
+	i := y*f.Stride + x*4
+	f.Pix[i], f.Pix[i+1], f.Pix[i+2], f.Pix[i+3] = c.R, c.G, c.B, c.A
+}
+
+func TestUniformColor(t *testing.T) {
+	gray := color.RGBA{R: 40, G: 40, B: 40, A: 255}
+	tests := []struct {
+		name  string
+		frame func() *Frame
+		want  bool
+	}{
+		{"uniform", func() *Frame { return solidFrame(64, 48, gray) }, true},
+		{"padding is ignored", func() *Frame {
+			f := solidFrame(64, 48, gray)
+			for y := 0; y < f.H; y++ {
+				f.Pix[y*f.Stride+f.W*4] = 255
+			}
+			return f
+		}, true},
+		{"noise within tolerance", func() *Frame {
+			f := solidFrame(64, 48, gray)
+			for y := 0; y < f.H; y++ {
+				for x := 0; x < f.W; x++ {
+					d := uint8(BLANK_TOLERANCE * ((x + y) % 2))
+					setPixel(f, x, y, color.RGBA{R: gray.R + d, G: gray.G - d, B: gray.B + d, A: 255})
+				}
+			}
+			return f
+		}, true},
+		{"noise beyond tolerance", func() *Frame {
+			f := solidFrame(64, 48, gray)
+			setPixel(f, 10, 10, color.RGBA{R: gray.R, G: gray.G, B: gray.B + BLANK_TOLERANCE + 1, A: 255})
+			return f
+		}, false},
+		{"single differing pixel", func() *Frame {
+			f := solidFrame(64, 48, gray)
+			setPixel(f, 62, 46, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			return f
+		}, false},
+		// Only every other row and column is sampled
+		{"pixel between samples", func() *Frame {
+			f := solidFrame(64, 48, gray)
+			setPixel(f, 63, 47, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			return f
+		}, true},
+		{"one pixel", func() *Frame { return solidFrame(1, 1, gray) }, true},
+		{"empty", func() *Frame { return &Frame{} }, false},
+		{"nil", func() *Frame { return nil }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, blank := UniformColor(tt.frame())
+			if blank != tt.want {
+				t.Fatalf("blank %v, want %v", blank, tt.want)
+			}
+			if blank && c != gray {
+				t.Errorf("colour %v, want %v", c, gray)
+			}
+		})
+	}
+}
@@ -4,9 +4,26 @@ package capture
 
 import (
 	"errors"
+	"image"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/exam-gaurd/client/damage"
 )
 
+// CAPTURE_ENV selects a capturer that needs no display, for tests, demos
+// and load testing on headless machines:
+//
+//	synthetic            generated screens at SYNTHETIC_WIDTH×SYNTHETIC_HEIGHT
+//	synthetic:1920x1080  generated screens at the given size
+//	replay:DIR           the PNG screenshots in DIR
+//
+// Unset, the platform's real capturer is used.
+const CAPTURE_ENV = "EXAM_GUARD_CAPTURE"
+
 // Frame represents a captured screen frame.
 // Pix contains RGBA pixel data, organized as 4 bytes per pixel.
 type Frame struct {
@@ -16,9 +33,7 @@ type Frame struct {
 }
 
 // DirtyRect represents a changed region of the screen.
-type DirtyRect struct {
-	X, Y, W, H int
-}
+type DirtyRect = damage.Rect
 
 // FrameWithDirty contains a frame and optional dirty rectangles.
 // If DirtyRects is empty, the entire frame should be considered changed.
@@ -45,6 +60,90 @@ type Capturer interface {
 
 	// SupportssDirtyRects returns true if the capturer supports dirty rectangle detection.
 	SupportsDirtyRects() bool
+
+	// Name returns a short identifier for the capture backend (e.g. "X11", "DXGI").
+	Name() string
+
+	// SetTarget switches between the whole display, one window and a
+	// region of the display. It may be called at any time, also before
+	// Start; the first frame of the new target is a keyframe. Targets the
+	// backend can't capture return an error wrapping ErrNotSupported or
+	// ErrTargetNotFound and leave the current target in place.
+	SetTarget(t Target) error
+}
+
+// MAX_CURSOR_SIZE bounds the pointer shapes capturers return.
+const MAX_CURSOR_SIZE = 256
+
+// Cursor is the mouse pointer, in frame pixels.
+type Cursor struct {
+	X, Y    int // Hotspot position
+	Visible bool
+	// Serial identifies the shape and changes whenever it does; 0 means
+	// the shape is unknown and Shape is nil.
+	Serial     uint32
+	Shape      *image.RGBA
+	HotX, HotY int
+}
+
+// CursorCapturer is implemented by capturers that capture the pointer apart
+// from the frames. Capturers whose frames already show the pointer don't
+// implement it.
+type CursorCapturer interface {
+	// Cursor returns the pointer, or false if it is not known. Some
+	// backends only learn about the pointer in ReadFrame.
+	Cursor() (Cursor, bool)
+}
+
+// DAMAGE_WATCH_INTERVAL bounds how long damage watchers block, and so how
+// long they take to notice the capturer stopped.
+const DAMAGE_WATCH_INTERVAL = 200 * time.Millisecond
+
+// DamageNotifier is implemented by capturers that learn about screen
+// changes as they happen, so the caller can wait for them instead of
+// polling ReadFrame.
+type DamageNotifier interface {
+	// Damaged returns a channel that receives after the screen changed,
+	// or nil if the capturer can't tell right now, e.g. X11 without
+	// XDamage. Spurious wakeups are allowed; a change during ReadFrame
+	// may be signalled for the frame that already holds it.
+	Damaged() <-chan struct{}
+}
+
+// notify signals ch without blocking; one pending signal is enough.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// NewPlatformCapturer creates a Supervisor over this platform's backends,
+// or over the one selected by CAPTURE_ENV.
+func NewPlatformCapturer() *Supervisor {
+	mode, arg, _ := strings.Cut(os.Getenv(CAPTURE_ENV), ":")
+	switch mode {
+	case "synthetic":
+		w, h := parseSize(arg)
+		return NewSupervisor(func() Capturer { return NewSyntheticCapturer(w, h) })
+	case "replay":
+		return NewSupervisor(func() Capturer { return NewReplayCapturer(arg) })
+	}
+	return NewSupervisor(platformBackends()...)
+}
+
+// parseSize parses "WxH", returning zeros if s is not a size.
+func parseSize(s string) (w, h int) {
+	ws, hs, ok := strings.Cut(s, "x")
+	if !ok {
+		return 0, 0
+	}
+	w, errW := strconv.Atoi(ws)
+	h, errH := strconv.Atoi(hs)
+	if errW != nil || errH != nil {
+		return 0, 0
+	}
+	return w, h
 }
 
 // Common errors
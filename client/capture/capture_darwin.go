@@ -25,6 +25,7 @@ type SCKCapturer struct {
 	started   bool
 	mu        sync.Mutex
 	framePool *FramePool
+	target    regionCropper
 
 	rgbaBuffer []byte
 
@@ -118,11 +119,11 @@ func (c *SCKCapturer) ReadFrame() (*FrameWithDirty, error) {
 		c.keyFrameCounter = 0
 	}
 
-	return &FrameWithDirty{
+	return c.target.crop(&FrameWithDirty{
 		Frame:      frame,
 		IsKeyFrame: forceKeyFrame,
 		DirtyRects: nil, // ScreenCaptureKit doesn't provide dirty rects
-	}, nil
+	}, c.framePool), nil
 }
 
 func (c *SCKCapturer) Stop() {
@@ -137,6 +138,15 @@ func (c *SCKCapturer) Stop() {
 	}
 }
 
+// SetTarget supports the display and regions of it.
+func (c *SCKCapturer) SetTarget(t Target) error {
+	return c.target.set(t)
+}
+
 func (c *SCKCapturer) SupportsDirtyRects() bool {
 	return false
 }
+
+func (c *SCKCapturer) Name() string {
+	return "SCK"
+}
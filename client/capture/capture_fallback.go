@@ -1,6 +1,3 @@
-//go:build !cgo
-// +build !cgo
-
 package capture
 
 import (
@@ -9,17 +6,20 @@ import (
 	"image/png"
 	"sync"
 
+	"github.com/exam-gaurd/client/damage"
 	"github.com/kbinani/screenshot"
 )
 
-// FallbackCapturer provides screenshot-based capture when CGO is unavailable.
-// This is less efficient but works as a fallback.
+// FallbackCapturer provides screenshot-based capture when CGO is unavailable
+// or the platform backends fail. This is less efficient but works as a
+// fallback.
 type FallbackCapturer struct {
 	width     int
 	height    int
 	started   bool
 	mu        sync.Mutex
 	framePool *FramePool
+	target    regionCropper
 
 	prevFrame     []byte
 	keyFrameCount int
@@ -39,7 +39,13 @@ func (c *FallbackCapturer) Start() error {
 		return ErrAlreadyStarted
 	}
 
+	if screenshot.NumActiveDisplays() == 0 {
+		return ErrNoDisplay
+	}
 	bounds := screenshot.GetDisplayBounds(0)
+	if bounds.Empty() {
+		return ErrNoDisplay
+	}
 	c.width = bounds.Dx()
 	c.height = bounds.Dy()
 	c.started = true
@@ -85,7 +91,7 @@ func (c *FallbackCapturer) ReadFrame() (*FrameWithDirty, error) {
 	}
 
 	if !isKeyFrame {
-		dirtyRects := c.detectDirtyRects(frame.Pix)
+		dirtyRects := damage.Diff(frame.Pix, c.prevFrame, c.width, c.height, frame.Stride, damage.DefaultBlockSize)
 		result.DirtyRects = dirtyRects
 		if len(dirtyRects) == 0 {
 			c.framePool.Put(frame)
@@ -95,51 +101,7 @@ func (c *FallbackCapturer) ReadFrame() (*FrameWithDirty, error) {
 
 	copy(c.prevFrame, frame.Pix)
 
-	return result, nil
-}
-
-func (c *FallbackCapturer) detectDirtyRects(current []byte) []DirtyRect {
-	var rects []DirtyRect
-	blockSize := 64
-	stride := c.width * 4
-
-	blocksX := (c.width + blockSize - 1) / blockSize
-	blocksY := (c.height + blockSize - 1) / blockSize
-
-	for by := 0; by < blocksY; by++ {
-		for bx := 0; bx < blocksX; bx++ {
-			rx := bx * blockSize
-			ry := by * blockSize
-			rw := blockSize
-			rh := blockSize
-			if rx+rw > c.width {
-				rw = c.width - rx
-			}
-			if ry+rh > c.height {
-				rh = c.height - ry
-			}
-
-			changed := false
-			for cy := ry; cy < ry+rh && !changed; cy += 8 {
-				for cx := rx; cx < rx+rw && !changed; cx += 8 {
-					idx := cy*stride + cx*4
-					if idx+4 <= len(current) && idx+4 <= len(c.prevFrame) {
-						if current[idx] != c.prevFrame[idx] ||
-							current[idx+1] != c.prevFrame[idx+1] ||
-							current[idx+2] != c.prevFrame[idx+2] {
-							changed = true
-						}
-					}
-				}
-			}
-
-			if changed {
-				rects = append(rects, DirtyRect{X: rx, Y: ry, W: rw, H: rh})
-			}
-		}
-	}
-
-	return rects
+	return c.target.crop(result, c.framePool), nil
 }
 
 func (c *FallbackCapturer) Stop() {
@@ -148,10 +110,19 @@ func (c *FallbackCapturer) Stop() {
 	c.started = false
 }
 
+// SetTarget supports the display and regions of it.
+func (c *FallbackCapturer) SetTarget(t Target) error {
+	return c.target.set(t)
+}
+
 func (c *FallbackCapturer) SupportsDirtyRects() bool {
 	return false
 }
 
+func (c *FallbackCapturer) Name() string {
+	return "fallback"
+}
+
 func NewCapturer() (Capturer, error) {
 	return NewFallbackCapturer(), nil
 }
@@ -12,6 +12,7 @@ package capture
 #include <unistd.h>
 #include <fcntl.h>
 #include <errno.h>
+#include <time.h>
 #include <pipewire/pipewire.h>
 #include <spa/param/video/format-utils.h>
 #include <spa/debug/types.h>
@@ -33,12 +34,15 @@ typedef struct {
     int frame_ready;
     int format; // SPA_VIDEO_FORMAT_*
 
-    // Sync primitives
+    // Sync primitives. frame_cond is signalled and frame_seq counted up
+    // for every new frame.
     pthread_mutex_t mutex;
+    pthread_cond_t frame_cond;
+    unsigned int frame_seq;
     int running;
     int started;
 
-    // Portal node id
+    // Portal stream node id
     uint32_t node_id;
 } PWCapture;
 
@@ -93,6 +97,8 @@ static void on_stream_process(void *userdata) {
                        cap->frame_stride);
             }
             cap->frame_ready = 1;
+            cap->frame_seq++;
+            pthread_cond_broadcast(&cap->frame_cond);
         }
         pthread_mutex_unlock(&cap->mutex);
     }
@@ -115,30 +121,38 @@ static const struct pw_stream_events stream_events = {
     .state_changed = on_stream_state_changed,
 };
 
-// Initialize PipeWire capture with a node ID from portal
-PWCapture* pw_capture_init(uint32_t node_id) {
+// Initialize PipeWire capture of a portal stream. fd is the PipeWire remote
+// from OpenPipeWireRemote and is owned by the capture from here on.
+PWCapture* pw_capture_init(int fd, uint32_t node_id) {
     PWCapture *cap = (PWCapture*)calloc(1, sizeof(PWCapture));
-    if (!cap) return NULL;
+    if (!cap) {
+        close(fd);
+        return NULL;
+    }
 
     pthread_mutex_init(&cap->mutex, NULL);
+    pthread_cond_init(&cap->frame_cond, NULL);
     cap->node_id = node_id;
 
     pw_init(NULL, NULL);
 
     cap->loop = pw_thread_loop_new("pw-capture", NULL);
     if (!cap->loop) {
+        close(fd);
         free(cap);
         return NULL;
     }
 
     cap->context = pw_context_new(pw_thread_loop_get_loop(cap->loop), NULL, 0);
     if (!cap->context) {
+        close(fd);
         pw_thread_loop_destroy(cap->loop);
         free(cap);
         return NULL;
     }
 
     if (pw_thread_loop_start(cap->loop) < 0) {
+        close(fd);
         pw_context_destroy(cap->context);
         pw_thread_loop_destroy(cap->loop);
         free(cap);
@@ -147,7 +161,8 @@ PWCapture* pw_capture_init(uint32_t node_id) {
 
     pw_thread_loop_lock(cap->loop);
 
-    cap->core = pw_context_connect(cap->context, NULL, 0);
+    // The portal remote only exposes the streams the student agreed to share
+    cap->core = pw_context_connect_fd(cap->context, fd, NULL, 0);
     if (!cap->core) {
         pw_thread_loop_unlock(cap->loop);
         pw_thread_loop_stop(cap->loop);
@@ -201,10 +216,6 @@ PWCapture* pw_capture_init(uint32_t node_id) {
             &SPA_FRACTION(0, 1),
             &SPA_FRACTION(60, 1)));
 
-    // Connect with node_id target
-    char target[64];
-    snprintf(target, sizeof(target), "%u", node_id);
-
     pw_stream_connect(cap->stream,
         PW_DIRECTION_INPUT,
         node_id,
@@ -273,6 +284,27 @@ int pw_capture_frame(PWCapture *cap, unsigned char *rgba_out, int *width, int *h
     return 1;
 }
 
+// Wait up to timeout_ms for a frame after the one numbered seq and return
+// the number of the latest frame.
+unsigned int pw_capture_wait(PWCapture *cap, unsigned int seq, int timeout_ms) {
+    struct timespec deadline;
+    clock_gettime(CLOCK_REALTIME, &deadline);
+    deadline.tv_sec += timeout_ms / 1000;
+    deadline.tv_nsec += (long)(timeout_ms % 1000) * 1000000;
+    if (deadline.tv_nsec >= 1000000000) {
+        deadline.tv_sec++;
+        deadline.tv_nsec -= 1000000000;
+    }
+
+    pthread_mutex_lock(&cap->mutex);
+    while (cap->frame_seq == seq) {
+        if (pthread_cond_timedwait(&cap->frame_cond, &cap->mutex, &deadline) == ETIMEDOUT) break;
+    }
+    seq = cap->frame_seq;
+    pthread_mutex_unlock(&cap->mutex);
+    return seq;
+}
+
 // Check if stream is started
 int pw_capture_is_started(PWCapture *cap) {
     return cap ? cap->started : 0;
@@ -312,6 +344,7 @@ void pw_capture_destroy(PWCapture *cap) {
         free(cap->frame_data);
     }
     pthread_mutex_unlock(&cap->mutex);
+    pthread_cond_destroy(&cap->frame_cond);
     pthread_mutex_destroy(&cap->mutex);
 
     pw_deinit();
@@ -323,11 +356,16 @@ import (
 	"sync"
 	"time"
 	"unsafe"
+
+	"github.com/exam-gaurd/client/damage"
 )
 
 // WaylandCapturer implements Capturer for Linux Wayland using PipeWire.
-// Note: Requires user to grant screen capture permission via xdg-desktop-portal.
+// Start opens an xdg-desktop-portal screen cast, which asks the student to
+// share their screen the first time, and captures its PipeWire stream.
 type WaylandCapturer struct {
+	portal     *ScreenCastPortal
+	session    *ScreenCastSession
 	cap        *C.PWCapture
 	width      int
 	height     int
@@ -335,18 +373,29 @@ type WaylandCapturer struct {
 	mu         sync.Mutex
 	framePool  *FramePool
 	rgbaBuffer []byte
-	nodeID     uint32
+	target     regionCropper
 
 	prevFrame     []byte
 	keyFrameCount int
+
+	// damaged is signalled by watchFrames for every new stream frame
+	damaged   chan struct{}
+	stopWatch chan struct{}
+	watchDone chan struct{}
+}
+
+// NewWaylandCapturer creates a new Wayland screen capturer using the
+// desktop's screen cast portal.
+func NewWaylandCapturer() *WaylandCapturer {
+	return NewPortalCapturer(&ScreenCastPortal{})
 }
 
-// NewWaylandCapturer creates a new Wayland screen capturer.
-// nodeID is the PipeWire node ID obtained from xdg-desktop-portal.
-func NewWaylandCapturer(nodeID uint32) *WaylandCapturer {
+// NewPortalCapturer creates a Wayland capturer that gets its stream from
+// portal.
+func NewPortalCapturer(portal *ScreenCastPortal) *WaylandCapturer {
 	return &WaylandCapturer{
+		portal:    portal,
 		framePool: NewFramePool(),
-		nodeID:    nodeID,
 	}
 }
 
@@ -358,10 +407,20 @@ func (c *WaylandCapturer) Start() error {
 		return ErrAlreadyStarted
 	}
 
-	c.cap = C.pw_capture_init(C.uint32_t(c.nodeID))
+	session, err := c.portal.Open()
+	if err != nil {
+		return err
+	}
+
+	// PipeWire owns the remote from here on, even if init fails
+	fd := session.FD
+	session.FD = -1
+	c.cap = C.pw_capture_init(C.int(fd), C.uint32_t(session.NodeID))
 	if c.cap == nil {
+		session.Close()
 		return ErrNoDisplay
 	}
+	c.session = session
 
 	timeout := time.After(5 * time.Second)
 	tick := time.NewTicker(100 * time.Millisecond)
@@ -372,6 +431,8 @@ func (c *WaylandCapturer) Start() error {
 		case <-timeout:
 			C.pw_capture_destroy(c.cap)
 			c.cap = nil
+			c.session.Close()
+			c.session = nil
 			return ErrCaptureFailed
 		case <-tick.C:
 			if C.pw_capture_is_started(c.cap) != 0 {
@@ -383,6 +444,11 @@ func (c *WaylandCapturer) Start() error {
 					c.rgbaBuffer = make([]byte, c.width*c.height*4)
 					c.prevFrame = make([]byte, c.width*c.height*4)
 					c.started = true
+
+					c.damaged = make(chan struct{}, 1)
+					c.stopWatch = make(chan struct{})
+					c.watchDone = make(chan struct{})
+					go c.watchFrames(c.cap, c.damaged, c.stopWatch, c.watchDone)
 					return nil
 				}
 			}
@@ -433,7 +499,7 @@ func (c *WaylandCapturer) ReadFrame() (*FrameWithDirty, error) {
 	}
 
 	if !isKeyFrame && len(c.prevFrame) == len(c.rgbaBuffer) {
-		dirtyRects := detectDirtyRects(c.rgbaBuffer, c.prevFrame, c.width, c.height, 64)
+		dirtyRects := damage.Diff(c.rgbaBuffer, c.prevFrame, c.width, c.height, c.width*4, damage.DefaultBlockSize)
 		result.DirtyRects = dirtyRects
 		if len(dirtyRects) == 0 {
 			c.framePool.Put(frame)
@@ -443,7 +509,35 @@ func (c *WaylandCapturer) ReadFrame() (*FrameWithDirty, error) {
 
 	copy(c.prevFrame, c.rgbaBuffer)
 
-	return result, nil
+	return c.target.crop(result, c.framePool), nil
+}
+
+// watchFrames signals damaged for every frame PipeWire delivers, until
+// stop is closed. The compositor only sends frames when the screen changed.
+func (c *WaylandCapturer) watchFrames(cap *C.PWCapture, damaged, stop, done chan struct{}) {
+	defer close(done)
+
+	var seq C.uint
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		next := C.pw_capture_wait(cap, seq, C.int(DAMAGE_WATCH_INTERVAL.Milliseconds()))
+		if next != seq {
+			seq = next
+			notify(damaged)
+		}
+	}
+}
+
+// Damaged returns a channel that receives when the stream has a new frame.
+func (c *WaylandCapturer) Damaged() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.damaged
 }
 
 func (c *WaylandCapturer) Stop() {
@@ -451,55 +545,27 @@ func (c *WaylandCapturer) Stop() {
 	defer c.mu.Unlock()
 
 	if c.started && c.cap != nil {
+		// The watcher uses the stream until it returns
+		close(c.stopWatch)
+		<-c.watchDone
+		c.damaged = nil
 		C.pw_capture_destroy(c.cap)
 		c.cap = nil
+		c.session.Close()
+		c.session = nil
 		c.started = false
 	}
 }
 
+// SetTarget supports the display and regions of it.
+func (c *WaylandCapturer) SetTarget(t Target) error {
+	return c.target.set(t)
+}
+
 func (c *WaylandCapturer) SupportsDirtyRects() bool {
 	return false
 }
 
-func detectDirtyRects(current, prev []byte, width, height, blockSize int) []DirtyRect {
-	var rects []DirtyRect
-
-	blocksX := (width + blockSize - 1) / blockSize
-	blocksY := (height + blockSize - 1) / blockSize
-	stride := width * 4
-
-	for by := 0; by < blocksY; by++ {
-		for bx := 0; bx < blocksX; bx++ {
-			rx := bx * blockSize
-			ry := by * blockSize
-			rw := blockSize
-			rh := blockSize
-			if rx+rw > width {
-				rw = width - rx
-			}
-			if ry+rh > height {
-				rh = height - ry
-			}
-
-			changed := false
-			for cy := ry; cy < ry+rh && !changed; cy += 8 {
-				for cx := rx; cx < rx+rw && !changed; cx += 8 {
-					idx := cy*stride + cx*4
-					if idx+4 <= len(current) && idx+4 <= len(prev) {
-						if current[idx] != prev[idx] ||
-							current[idx+1] != prev[idx+1] ||
-							current[idx+2] != prev[idx+2] {
-							changed = true
-						}
-					}
-				}
-			}
-
-			if changed {
-				rects = append(rects, DirtyRect{X: rx, Y: ry, W: rw, H: rh})
-			}
-		}
-	}
-
-	return rects
+func (c *WaylandCapturer) Name() string {
+	return "PipeWire"
 }
@@ -4,7 +4,8 @@
 package capture
 
 /*
-#cgo LDFLAGS: -lX11 -lXext -lXdamage
+#cgo CFLAGS: -I${SRCDIR}/../internal/xtrap
+#cgo LDFLAGS: -lX11 -lXext -lXdamage -lXfixes -lXcomposite
 
 #include <stdlib.h>
 #include <string.h>
@@ -12,36 +13,219 @@ package capture
 #include <X11/Xutil.h>
 #include <X11/extensions/XShm.h>
 #include <X11/extensions/Xdamage.h>
+#include <X11/extensions/Xfixes.h>
+#include <X11/extensions/Xcomposite.h>
 #include <sys/shm.h>
 #include <sys/ipc.h>
+#include <poll.h>
+#include "xtrap.h"
+
+// x11_capture_frame results other than a dirty rect count
+#define X11_FULL_FRAME  -1
+#define X11_ERROR       -2
+#define X11_RESIZED     -3
+#define X11_TARGET_LOST -4
 
 // X11Capture holds X11 capture state
 typedef struct {
     Display *display;
     Window root;
     int screen;
+    int screen_width;
+    int screen_height;
+
+    // The source being captured: the root window, or the window target's
+    // pixmap. width and height include the window's border.
     int width;
     int height;
     int depth;
+    Visual *visual;
 
-    // XShm for fast capture
+    // XShm for fast capture; without it each frame is read with XGetImage
     XShmSegmentInfo shminfo;
     XImage *image;
     int shm_attached;
+    int shm_supported;
+    int use_shm;
 
-    // XDamage for dirty rectangles
+    // XDamage for dirty rectangles, on the source
     Damage damage;
     int damage_event_base;
     int damage_error_base;
     int damage_supported;
 
-    // Previous frame for comparison fallback
-    unsigned char *prev_frame;
-    int prev_frame_size;
+    // XFixes for the pointer, which XShm and XGetImage leave out
+    int xfixes_supported;
+
+    // Window target, read from its XComposite pixmap so overlapping windows
+    // don't show. window is None while capturing the display.
+    int composite_supported;
+    Window window;
+    Pixmap pixmap;
+    int window_x;
+    int window_y;
+    int border;
 } X11Capture;
 
-// Initialize X11 capture
-X11Capture* x11_capture_init(int *out_width, int *out_height) {
+// A viewable top-level window and the names of the application window in it
+typedef struct {
+    Window frame;
+    char *title;
+    char *res_class;
+    char *res_name;
+} X11Window;
+
+// Release the XShm image, if any
+static void x11_shm_destroy(X11Capture *cap) {
+    if (cap->shm_attached) {
+        XShmDetach(cap->display, &cap->shminfo);
+        cap->shm_attached = 0;
+    }
+    if (cap->image) {
+        if (cap->shminfo.shmaddr) {
+            shmdt(cap->shminfo.shmaddr);
+            cap->shminfo.shmaddr = NULL;
+        }
+        // Don't let XDestroyImage free the shm data
+        cap->image->data = NULL;
+        XDestroyImage(cap->image);
+        cap->image = NULL;
+    }
+}
+
+// Create an XShm image of the source's size. Returns 0 if XShm can't be
+// used, e.g. on a remote display where the segment can't be shared.
+static int x11_shm_create(X11Capture *cap) {
+    cap->image = XShmCreateImage(
+        cap->display,
+        cap->visual,
+        cap->depth,
+        ZPixmap,
+        NULL,
+        &cap->shminfo,
+        cap->width,
+        cap->height
+    );
+    if (!cap->image) return 0;
+
+    cap->shminfo.shmid = shmget(
+        IPC_PRIVATE,
+        cap->image->bytes_per_line * cap->image->height,
+        IPC_CREAT | 0600
+    );
+    if (cap->shminfo.shmid < 0) {
+        x11_shm_destroy(cap);
+        return 0;
+    }
+
+    cap->shminfo.shmaddr = cap->image->data = (char*)shmat(cap->shminfo.shmid, NULL, 0);
+    cap->shminfo.readOnly = False;
+    // Mark shm for removal after detach
+    shmctl(cap->shminfo.shmid, IPC_RMID, NULL);
+    if (cap->shminfo.shmaddr == (char*)-1) {
+        cap->shminfo.shmaddr = NULL;
+        x11_shm_destroy(cap);
+        return 0;
+    }
+
+    xtrap_begin(cap->display);
+    Status ok = XShmAttach(cap->display, &cap->shminfo);
+    if (xtrap_end(cap->display) || !ok) {
+        x11_shm_destroy(cap);
+        return 0;
+    }
+    cap->shm_attached = 1;
+    return 1;
+}
+
+// Recreate the XShm image after the source changed size or visual
+static void x11_shm_reset(X11Capture *cap) {
+    x11_shm_destroy(cap);
+    cap->use_shm = cap->shm_supported && x11_shm_create(cap);
+}
+
+// Move XDamage to drawable. The old damage must still exist.
+static void x11_watch_damage(X11Capture *cap, Drawable drawable) {
+    if (!cap->damage_supported) return;
+    if (cap->damage) XDamageDestroy(cap->display, cap->damage);
+    cap->damage = XDamageCreate(cap->display, drawable, XDamageReportRawRectangles);
+}
+
+// Make the root window the source
+static void x11_use_display(X11Capture *cap) {
+    cap->width = cap->screen_width;
+    cap->height = cap->screen_height;
+    cap->depth = DefaultDepth(cap->display, cap->screen);
+    cap->visual = DefaultVisual(cap->display, cap->screen);
+    cap->border = 0;
+    x11_watch_damage(cap, cap->root);
+}
+
+// Stop reading the window target. The window may be gone already, so
+// errors are ignored.
+static void x11_release_window(X11Capture *cap) {
+    if (!cap->window) return;
+
+    xtrap_begin(cap->display);
+    if (cap->damage) {
+        XDamageDestroy(cap->display, cap->damage);
+        cap->damage = 0;
+    }
+    if (cap->pixmap) XFreePixmap(cap->display, cap->pixmap);
+    XCompositeUnredirectWindow(cap->display, cap->window, CompositeRedirectAutomatic);
+    XSelectInput(cap->display, cap->window, NoEventMask);
+    xtrap_end(cap->display);
+
+    cap->window = None;
+    cap->pixmap = None;
+}
+
+// Make window the source. Automatic redirection keeps the window on screen
+// while its contents are also kept in an offscreen pixmap, complete even
+// where other windows cover it.
+static int x11_redirect_window(X11Capture *cap, Window window) {
+    XWindowAttributes attrs;
+
+    xtrap_begin(cap->display);
+    if (!XGetWindowAttributes(cap->display, window, &attrs) || attrs.map_state != IsViewable) {
+        xtrap_end(cap->display);
+        return 0;
+    }
+    cap->window = window;
+    XSelectInput(cap->display, window, StructureNotifyMask);
+    XCompositeRedirectWindow(cap->display, window, CompositeRedirectAutomatic);
+    cap->pixmap = XCompositeNameWindowPixmap(cap->display, window);
+    x11_watch_damage(cap, window);
+    if (xtrap_end(cap->display)) {
+        x11_release_window(cap);
+        x11_use_display(cap);
+        return 0;
+    }
+
+    cap->border = attrs.border_width;
+    cap->width = attrs.width + 2 * attrs.border_width;
+    cap->height = attrs.height + 2 * attrs.border_width;
+    cap->window_x = attrs.x;
+    cap->window_y = attrs.y;
+    cap->depth = attrs.depth;
+    cap->visual = attrs.visual;
+    return 1;
+}
+
+// Capture window, a top-level window, instead of the display, or the
+// display again if window is None. Returns 0 if the window can't be
+// captured, in which case the display is.
+int x11_capture_set_window(X11Capture *cap, Window window) {
+    x11_release_window(cap);
+    x11_use_display(cap);
+    int ok = window == None || (cap->composite_supported && x11_redirect_window(cap, window));
+    x11_shm_reset(cap);
+    return ok;
+}
+
+// Initialize X11 capture. Without allow_shm every frame is read with
+// XGetImage, for servers where MIT-SHM is present but broken.
+X11Capture* x11_capture_init(int allow_shm, int *out_width, int *out_height) {
     X11Capture *cap = (X11Capture*)calloc(1, sizeof(X11Capture));
     if (!cap) return NULL;
 
@@ -53,12 +237,11 @@ X11Capture* x11_capture_init(int *out_width, int *out_height) {
 
     cap->screen = DefaultScreen(cap->display);
     cap->root = RootWindow(cap->display, cap->screen);
-    cap->width = DisplayWidth(cap->display, cap->screen);
-    cap->height = DisplayHeight(cap->display, cap->screen);
-    cap->depth = DefaultDepth(cap->display, cap->screen);
+    cap->screen_width = DisplayWidth(cap->display, cap->screen);
+    cap->screen_height = DisplayHeight(cap->display, cap->screen);
 
-    *out_width = cap->width;
-    *out_height = cap->height;
+    // Root ConfigureNotify events report screen resizes
+    XSelectInput(cap->display, cap->root, StructureNotifyMask);
 
     // Check XDamage support
     cap->damage_supported = XDamageQueryExtension(
@@ -67,90 +250,159 @@ X11Capture* x11_capture_init(int *out_width, int *out_height) {
         &cap->damage_error_base
     );
 
-    if (cap->damage_supported) {
-        cap->damage = XDamageCreate(cap->display, cap->root, XDamageReportRawRectangles);
+    int xfixes_event_base, xfixes_error_base;
+    cap->xfixes_supported = XFixesQueryExtension(cap->display, &xfixes_event_base, &xfixes_error_base);
+
+    // Window pixmaps need Composite 0.2
+    int composite_event_base, composite_error_base, major = 0, minor = 2;
+    cap->composite_supported =
+        XCompositeQueryExtension(cap->display, &composite_event_base, &composite_error_base) &&
+        XCompositeQueryVersion(cap->display, &major, &minor) &&
+        (major > 0 || minor >= 2);
+
+    x11_use_display(cap);
+    cap->shm_supported = allow_shm && XShmQueryExtension(cap->display);
+    x11_shm_reset(cap);
+
+    *out_width = cap->width;
+    *out_height = cap->height;
+    return cap;
+}
+
+// Check for a change of the source. Returns 0 if there is none, 1 if it
+// changed size, or X11_TARGET_LOST if the window target was unmapped or
+// destroyed, in which case the display is the source again.
+static int x11_check_resize(X11Capture *cap) {
+    XEvent event;
+    int screen_width = cap->screen_width, screen_height = cap->screen_height;
+
+    while (XCheckTypedWindowEvent(cap->display, cap->root, ConfigureNotify, &event)) {
+        screen_width = event.xconfigure.width;
+        screen_height = event.xconfigure.height;
+    }
+    int screen_resized = screen_width != cap->screen_width || screen_height != cap->screen_height;
+    cap->screen_width = screen_width;
+    cap->screen_height = screen_height;
+
+    if (!cap->window) {
+        if (!screen_resized) return 0;
+        cap->width = screen_width;
+        cap->height = screen_height;
+        x11_shm_reset(cap);
+        return 1;
     }
 
-    // Check XShm support
-    if (!XShmQueryExtension(cap->display)) {
-        if (cap->damage_supported) {
-            XDamageDestroy(cap->display, cap->damage);
+    int lost = 0, width = cap->width, height = cap->height;
+    while (XCheckWindowEvent(cap->display, cap->window, StructureNotifyMask, &event)) {
+        switch (event.type) {
+        case ConfigureNotify:
+            cap->border = event.xconfigure.border_width;
+            width = event.xconfigure.width + 2 * event.xconfigure.border_width;
+            height = event.xconfigure.height + 2 * event.xconfigure.border_width;
+            cap->window_x = event.xconfigure.x;
+            cap->window_y = event.xconfigure.y;
+            break;
+        case UnmapNotify:
+        case DestroyNotify:
+            lost = 1;
+            break;
         }
-        XCloseDisplay(cap->display);
-        free(cap);
-        return NULL;
+    }
+    if (!lost && width == cap->width && height == cap->height) return 0;
+
+    if (!lost) {
+        // The window gets a new pixmap whenever it is resized
+        xtrap_begin(cap->display);
+        XFreePixmap(cap->display, cap->pixmap);
+        cap->pixmap = XCompositeNameWindowPixmap(cap->display, cap->window);
+        lost = xtrap_end(cap->display);
+    }
+    if (lost) {
+        x11_capture_set_window(cap, None);
+        return X11_TARGET_LOST;
     }
 
-    // Create shared memory image
-    cap->image = XShmCreateImage(
-        cap->display,
-        DefaultVisual(cap->display, cap->screen),
-        cap->depth,
-        ZPixmap,
-        NULL,
-        &cap->shminfo,
-        cap->width,
-        cap->height
-    );
+    cap->width = width;
+    cap->height = height;
+    x11_shm_reset(cap);
+    return 1;
+}
 
-    if (!cap->image) {
-        if (cap->damage_supported) {
-            XDamageDestroy(cap->display, cap->damage);
-        }
-        XCloseDisplay(cap->display);
-        free(cap);
-        return NULL;
-    }
+// Convert an XImage to RGBA based on its byte order and colour masks
+static void x11_convert(XImage *image, unsigned char *rgba_out, int width, int height) {
+    unsigned char *src = (unsigned char*)image->data;
+    int src_stride = image->bytes_per_line;
+    int dst_stride = width * 4;
+    int bytes_per_pixel = image->bits_per_pixel / 8;
 
-    // Allocate shared memory
-    cap->shminfo.shmid = shmget(
-        IPC_PRIVATE,
-        cap->image->bytes_per_line * cap->image->height,
-        IPC_CREAT | 0777
-    );
+    unsigned long red_mask = image->red_mask;
+    unsigned long green_mask = image->green_mask;
+    unsigned long blue_mask = image->blue_mask;
 
-    if (cap->shminfo.shmid < 0) {
-        XDestroyImage(cap->image);
-        if (cap->damage_supported) {
-            XDamageDestroy(cap->display, cap->damage);
-        }
-        XCloseDisplay(cap->display);
-        free(cap);
-        return NULL;
-    }
+    // Calculate bit shifts from masks
+    int red_shift = 0, green_shift = 0, blue_shift = 0;
+    if (red_mask) while (!(red_mask & (1UL << red_shift))) red_shift++;
+    if (green_mask) while (!(green_mask & (1UL << green_shift))) green_shift++;
+    if (blue_mask) while (!(blue_mask & (1UL << blue_shift))) blue_shift++;
 
-    cap->shminfo.shmaddr = cap->image->data = (char*)shmat(cap->shminfo.shmid, NULL, 0);
-    cap->shminfo.readOnly = False;
+    for (int y = 0; y < height; y++) {
+        for (int x = 0; x < width; x++) {
+            int src_idx = y * src_stride + x * bytes_per_pixel;
+            int dst_idx = y * dst_stride + x * 4;
 
-    if (!XShmAttach(cap->display, &cap->shminfo)) {
-        shmdt(cap->shminfo.shmaddr);
-        shmctl(cap->shminfo.shmid, IPC_RMID, NULL);
-        XDestroyImage(cap->image);
-        if (cap->damage_supported) {
-            XDamageDestroy(cap->display, cap->damage);
+            // Read pixel value based on byte order
+            unsigned long pixel = 0;
+            if (image->byte_order == LSBFirst) {
+                for (int b = 0; b < bytes_per_pixel; b++) {
+                    pixel |= ((unsigned long)src[src_idx + b]) << (b * 8);
+                }
+            } else {
+                for (int b = 0; b < bytes_per_pixel; b++) {
+                    pixel |= ((unsigned long)src[src_idx + b]) << ((bytes_per_pixel - 1 - b) * 8);
+                }
+            }
+
+            rgba_out[dst_idx + 0] = (pixel & red_mask) >> red_shift;
+            rgba_out[dst_idx + 1] = (pixel & green_mask) >> green_shift;
+            rgba_out[dst_idx + 2] = (pixel & blue_mask) >> blue_shift;
+            rgba_out[dst_idx + 3] = 255;
         }
-        XCloseDisplay(cap->display);
-        free(cap);
-        return NULL;
     }
+}
 
-    cap->shm_attached = 1;
+// Read the source into rgba_out. Returns 0 on failure.
+static int x11_read(X11Capture *cap, unsigned char *rgba_out) {
+    Drawable source = cap->window ? cap->pixmap : cap->root;
 
-    // Mark shm for removal after detach
-    shmctl(cap->shminfo.shmid, IPC_RMID, NULL);
-
-    // Allocate previous frame buffer for dirty rect fallback
-    cap->prev_frame_size = cap->width * cap->height * 4;
-    cap->prev_frame = (unsigned char*)malloc(cap->prev_frame_size);
+    if (cap->use_shm) {
+        if (!XShmGetImage(cap->display, source, cap->image, 0, 0, AllPlanes)) {
+            return 0;
+        }
+        x11_convert(cap->image, rgba_out, cap->width, cap->height);
+        return 1;
+    }
 
-    return cap;
+    XImage *image = XGetImage(cap->display, source, 0, 0,
+                              cap->width, cap->height, AllPlanes, ZPixmap);
+    if (!image) return 0;
+    x11_convert(image, rgba_out, cap->width, cap->height);
+    XDestroyImage(image);
+    return 1;
 }
 
-// Capture frame and detect dirty rectangles
-// dirty_rects: output array of 4 ints per rect (x, y, w, h), max 32 rects
-// Returns number of dirty rects, or -1 for full frame
+// Capture frame and collect XDamage rectangles
+// dirty_rects: output array of 4 ints per rect (x, y, w, h), max_rects at
+// most; damage beyond that is merged into the last rect
+// Returns the number of dirty rects, X11_FULL_FRAME, X11_ERROR, or
+// X11_RESIZED or X11_TARGET_LOST if the source changed size, in which case
+// nothing is captured and the caller must call again with a buffer of the
+// new size. Without XDamage every frame is full and the caller diffs it.
 int x11_capture_frame(X11Capture *cap, unsigned char *rgba_out, int *dirty_rects, int max_rects) {
-    if (!cap || !cap->display) return -1;
+    if (!cap || !cap->display) return X11_ERROR;
+
+    int changed = x11_check_resize(cap);
+    if (changed == X11_TARGET_LOST) return X11_TARGET_LOST;
+    if (changed) return X11_RESIZED;
 
     int dirty_count = 0;
     int full_frame = 1;
@@ -158,146 +410,215 @@ int x11_capture_frame(X11Capture *cap, unsigned char *rgba_out, int *dirty_rects
     // Process XDamage events if supported
     if (cap->damage_supported) {
         XEvent event;
-        XRectangle damage_bounds = {0, 0, 0, 0};
         int has_damage = 0;
 
         while (XCheckTypedEvent(cap->display, cap->damage_event_base + XDamageNotify, &event)) {
             XDamageNotifyEvent *dev = (XDamageNotifyEvent*)&event;
 
+            // Drop events still queued for a previous source
+            if (dev->damage != cap->damage) continue;
+
+            // Window damage is relative to the inside of the border
+            int x = dev->area.x + cap->border;
+            int y = dev->area.y + cap->border;
+            int w = dev->area.width;
+            int h = dev->area.height;
             if (dirty_count < max_rects) {
-                dirty_rects[dirty_count * 4 + 0] = dev->area.x;
-                dirty_rects[dirty_count * 4 + 1] = dev->area.y;
-                dirty_rects[dirty_count * 4 + 2] = dev->area.width;
-                dirty_rects[dirty_count * 4 + 3] = dev->area.height;
+                dirty_rects[dirty_count * 4 + 0] = x;
+                dirty_rects[dirty_count * 4 + 1] = y;
+                dirty_rects[dirty_count * 4 + 2] = w;
+                dirty_rects[dirty_count * 4 + 3] = h;
                 dirty_count++;
+            } else if (max_rects > 0) {
+                // Out of slots: grow the last rect to the bounding box, so
+                // no damage is lost
+                int *last = &dirty_rects[(max_rects - 1) * 4];
+                int x1 = last[0] + last[2], y1 = last[1] + last[3];
+                if (x + w > x1) x1 = x + w;
+                if (y + h > y1) y1 = y + h;
+                if (x < last[0]) last[0] = x;
+                if (y < last[1]) last[1] = y;
+                last[2] = x1 - last[0];
+                last[3] = y1 - last[1];
             }
             has_damage = 1;
         }
 
         if (has_damage) {
             XDamageSubtract(cap->display, cap->damage, None, None);
-            full_frame = 0;
+            // With no room for rects the whole frame counts as damaged
+            full_frame = max_rects <= 0;
         }
     }
 
-    // Capture via XShm
-    if (!XShmGetImage(cap->display, cap->root, cap->image, 0, 0, AllPlanes)) {
-        return -1;
+    if (!cap->window) {
+        if (!x11_read(cap, rgba_out)) return X11_ERROR;
+        return full_frame ? X11_FULL_FRAME : dirty_count;
     }
 
-    // Convert to RGBA based on X server byte order
-    // XImage bits_per_pixel tells us bytes per pixel (usually 32 for TrueColor)
-    // XImage byte_order tells us endianness (LSBFirst or MSBFirst)
-    int x, y;
-    unsigned char *src = (unsigned char*)cap->image->data;
-    int src_stride = cap->image->bytes_per_line;
-    int dst_stride = cap->width * 4;
-    int bytes_per_pixel = cap->image->bits_per_pixel / 8;
+    // The window can be destroyed between the event check and the read
+    xtrap_begin(cap->display);
+    int ok = x11_read(cap, rgba_out);
+    if (xtrap_end(cap->display) || !ok) {
+        x11_capture_set_window(cap, None);
+        return X11_TARGET_LOST;
+    }
+    return full_frame ? X11_FULL_FRAME : dirty_count;
+}
 
-    // Get visual info to determine actual color positions
-    unsigned long red_mask = cap->image->red_mask;
-    unsigned long green_mask = cap->image->green_mask;
-    unsigned long blue_mask = cap->image->blue_mask;
+// Copy at most n bytes of s into a new string
+static char *x11_strndup(const char *s, size_t n) {
+    char *copy = malloc(n + 1);
+    if (!copy) return NULL;
+    memcpy(copy, s, n);
+    copy[n] = 0;
+    return copy;
+}
 
-    // Calculate bit shifts from masks
-    int red_shift = 0, green_shift = 0, blue_shift = 0;
-    if (red_mask) while (!(red_mask & (1 << red_shift))) red_shift++;
-    if (green_mask) while (!(green_mask & (1 << green_shift))) green_shift++;
-    if (blue_mask) while (!(blue_mask & (1 << blue_shift))) blue_shift++;
+// Find the application window in the tree under window: the first one
+// with WM_CLASS, which hint receives.
+static Window x11_client_window(Display *display, Window window, int depth, XClassHint *hint) {
+    if (XGetClassHint(display, window, hint)) return window;
+    if (depth == 0) return None;
 
-    for (y = 0; y < cap->height; y++) {
-        for (x = 0; x < cap->width; x++) {
-            int src_idx = y * src_stride + x * bytes_per_pixel;
-            int dst_idx = y * dst_stride + x * 4;
+    Window root, parent, *children = NULL;
+    unsigned int n = 0;
+    if (!XQueryTree(display, window, &root, &parent, &children, &n)) return None;
 
-            // Read pixel value based on byte order
-            unsigned long pixel = 0;
-            if (cap->image->byte_order == LSBFirst) {
-                for (int b = 0; b < bytes_per_pixel; b++) {
-                    pixel |= ((unsigned long)src[src_idx + b]) << (b * 8);
-                }
-            } else {
-                for (int b = 0; b < bytes_per_pixel; b++) {
-                    pixel |= ((unsigned long)src[src_idx + b]) << ((bytes_per_pixel - 1 - b) * 8);
-                }
-            }
-
-            // Extract RGB using masks and shifts
-            unsigned char r = (pixel & red_mask) >> red_shift;
-            unsigned char g = (pixel & green_mask) >> green_shift;
-            unsigned char b = (pixel & blue_mask) >> blue_shift;
+    Window found = None;
+    for (unsigned int i = 0; i < n && !found; i++) {
+        found = x11_client_window(display, children[i], depth - 1, hint);
+    }
+    if (children) XFree(children);
+    return found;
+}
 
-            rgba_out[dst_idx + 0] = r;
-            rgba_out[dst_idx + 1] = g;
-            rgba_out[dst_idx + 2] = b;
-            rgba_out[dst_idx + 3] = 255;
+// The UTF-8 title of window, from _NET_WM_NAME or else WM_NAME
+static char *x11_window_title(X11Capture *cap, Window window) {
+    Atom net_wm_name = XInternAtom(cap->display, "_NET_WM_NAME", False);
+    Atom utf8_string = XInternAtom(cap->display, "UTF8_STRING", False);
+    Atom type;
+    int format;
+    unsigned long n, after;
+    unsigned char *data = NULL;
+    char *title = NULL;
+
+    if (XGetWindowProperty(cap->display, window, net_wm_name, 0, 1024, False, utf8_string,
+                           &type, &format, &n, &after, &data) == Success && data) {
+        if (type == utf8_string && format == 8) title = x11_strndup((char*)data, n);
+        XFree(data);
+    }
+    if (!title) {
+        char *name = NULL;
+        if (XFetchName(cap->display, window, &name) && name) {
+            title = x11_strndup(name, strlen(name));
+            XFree(name);
         }
     }
+    return title;
+}
 
-    // If XDamage not supported, do pixel comparison for dirty rects
-    if (!cap->damage_supported && cap->prev_frame && dirty_count == 0) {
-        // Simple grid-based dirty detection (16x16 blocks)
-        int block_size = 64;
-        int blocks_x = (cap->width + block_size - 1) / block_size;
-        int blocks_y = (cap->height + block_size - 1) / block_size;
-
-        for (int by = 0; by < blocks_y && dirty_count < max_rects; by++) {
-            for (int bx = 0; bx < blocks_x && dirty_count < max_rects; bx++) {
-                int rx = bx * block_size;
-                int ry = by * block_size;
-                int rw = (rx + block_size > cap->width) ? (cap->width - rx) : block_size;
-                int rh = (ry + block_size > cap->height) ? (cap->height - ry) : block_size;
-
-                int changed = 0;
-                for (int cy = ry; cy < ry + rh && !changed; cy += 8) {
-                    for (int cx = rx; cx < rx + rw && !changed; cx += 8) {
-                        int idx = cy * dst_stride + cx * 4;
-                        if (memcmp(&rgba_out[idx], &cap->prev_frame[idx], 4) != 0) {
-                            changed = 1;
-                        }
-                    }
-                }
-
-                if (changed) {
-                    dirty_rects[dirty_count * 4 + 0] = rx;
-                    dirty_rects[dirty_count * 4 + 1] = ry;
-                    dirty_rects[dirty_count * 4 + 2] = rw;
-                    dirty_rects[dirty_count * 4 + 3] = rh;
-                    dirty_count++;
-                    full_frame = 0;
-                }
+// List the viewable top-level windows, topmost first, with the names of
+// the application window in each. Returns the count; free the names with
+// x11_free_windows.
+int x11_list_windows(X11Capture *cap, X11Window *out, int max) {
+    Window root, parent, *children = NULL;
+    unsigned int n = 0;
+    int count = 0;
+
+    // Windows come and go while the tree is walked
+    xtrap_begin(cap->display);
+    if (XQueryTree(cap->display, cap->root, &root, &parent, &children, &n)) {
+        for (int i = (int)n - 1; i >= 0 && count < max; i--) {
+            XWindowAttributes attrs;
+            if (!XGetWindowAttributes(cap->display, children[i], &attrs) ||
+                attrs.map_state != IsViewable || attrs.class == InputOnly) {
+                continue;
             }
+
+            XClassHint hint = {0};
+            Window client = x11_client_window(cap->display, children[i], 2, &hint);
+            if (!client) continue;
+
+            X11Window *win = &out[count++];
+            win->frame = children[i];
+            win->title = x11_window_title(cap, client);
+            win->res_class = hint.res_class ? x11_strndup(hint.res_class, strlen(hint.res_class)) : NULL;
+            win->res_name = hint.res_name ? x11_strndup(hint.res_name, strlen(hint.res_name)) : NULL;
+            if (hint.res_class) XFree(hint.res_class);
+            if (hint.res_name) XFree(hint.res_name);
         }
+        if (children) XFree(children);
+    }
+    xtrap_end(cap->display);
+    return count;
+}
 
-        // Copy current frame to prev
-        memcpy(cap->prev_frame, rgba_out, cap->prev_frame_size);
+void x11_free_windows(X11Window *windows, int n) {
+    for (int i = 0; i < n; i++) {
+        free(windows[i].title);
+        free(windows[i].res_class);
+        free(windows[i].res_name);
     }
+}
 
-    return full_frame ? -1 : dirty_count;
+// Read the pointer through XFixes, relative to the source. Returns 0 if
+// unavailable. The shape is written to rgba_out as premultiplied RGBA if it
+// is at most max_size in either dimension; otherwise *w and *h are 0.
+int x11_capture_cursor(X11Capture *cap, int *x, int *y, int *w, int *h,
+                       int *hot_x, int *hot_y, unsigned long *serial,
+                       unsigned char *rgba_out, int max_size) {
+    if (!cap || !cap->xfixes_supported) return 0;
+
+    XFixesCursorImage *image = XFixesGetCursorImage(cap->display);
+    if (!image) return 0;
+
+    *x = image->x;
+    *y = image->y;
+    if (cap->window) {
+        *x -= cap->window_x;
+        *y -= cap->window_y;
+    }
+    *hot_x = image->xhot;
+    *hot_y = image->yhot;
+    *serial = image->cursor_serial;
+    *w = 0;
+    *h = 0;
+
+    if (image->width <= max_size && image->height <= max_size) {
+        *w = image->width;
+        *h = image->height;
+        // Pixels are premultiplied ARGB in the low 32 bits of each long
+        for (int i = 0; i < image->width * image->height; i++) {
+            unsigned long p = image->pixels[i];
+            rgba_out[i * 4 + 0] = (p >> 16) & 0xff;
+            rgba_out[i * 4 + 1] = (p >> 8) & 0xff;
+            rgba_out[i * 4 + 2] = p & 0xff;
+            rgba_out[i * 4 + 3] = (p >> 24) & 0xff;
+        }
+    }
+
+    XFree(image);
+    return 1;
+}
+
+// Get the current source size
+void x11_capture_size(X11Capture *cap, int *width, int *height) {
+    *width = cap->width;
+    *height = cap->height;
 }
 
 // Cleanup
 void x11_capture_destroy(X11Capture *cap) {
     if (!cap) return;
 
-    if (cap->prev_frame) {
-        free(cap->prev_frame);
-    }
+    x11_release_window(cap);
 
     if (cap->damage_supported && cap->damage) {
         XDamageDestroy(cap->display, cap->damage);
     }
 
-    if (cap->shm_attached) {
-        XShmDetach(cap->display, &cap->shminfo);
-        shmdt(cap->shminfo.shmaddr);
-    }
-
-    if (cap->image) {
-        // Don't XDestroyImage as it would try to free shm data
-        cap->image->data = NULL;
-        XDestroyImage(cap->image);
-    }
+    x11_shm_destroy(cap);
 
     if (cap->display) {
         XCloseDisplay(cap->display);
@@ -310,14 +631,75 @@ void x11_capture_destroy(X11Capture *cap) {
 int x11_capture_has_damage(X11Capture *cap) {
     return cap ? cap->damage_supported : 0;
 }
+
+// Check if frames are read through XShm
+int x11_capture_uses_shm(X11Capture *cap) {
+    return cap ? cap->use_shm : 0;
+}
+
+// Check if windows can be captured
+int x11_capture_has_composite(X11Capture *cap) {
+    return cap ? cap->composite_supported : 0;
+}
+
+// The X connection's socket, readable when events arrive
+int x11_capture_fd(X11Capture *cap) {
+    return ConnectionNumber(cap->display);
+}
+
+// Wait up to timeout_ms for fd to become readable. Touches no Xlib state,
+// so it may run while another thread uses the display.
+void x11_capture_wait(int fd, int timeout_ms) {
+    struct pollfd p = { .fd = fd, .events = POLLIN };
+    poll(&p, 1, timeout_ms);
+}
+
+typedef struct {
+    X11Capture *cap;
+    int changed;
+} X11ChangeCheck;
+
+static Bool x11_is_change(Display *display, XEvent *event, XPointer arg) {
+    X11ChangeCheck *check = (X11ChangeCheck*)arg;
+    if (event->type == check->cap->damage_event_base + XDamageNotify) {
+        if (((XDamageNotifyEvent*)event)->damage == check->cap->damage) check->changed = 1;
+    } else if (event->type == ConfigureNotify || event->type == UnmapNotify || event->type == DestroyNotify) {
+        check->changed = 1;
+    }
+    // Leave every event queued for x11_capture_frame
+    return False;
+}
+
+// Check, without blocking or removing events, whether damage or a source
+// change is queued for the next frame
+int x11_capture_changed(X11Capture *cap) {
+    if (!cap || !cap->display) return 0;
+    XEventsQueued(cap->display, QueuedAfterReading);
+
+    X11ChangeCheck check = { cap, 0 };
+    XEvent event;
+    XCheckIfEvent(cap->display, &event, x11_is_change, (XPointer)&check);
+    return check.changed;
+}
 */
 import "C"
 import (
+	"fmt"
+	"image"
 	"sync"
+	"time"
 	"unsafe"
+
+	"github.com/exam-gaurd/client/damage"
+	_ "github.com/exam-gaurd/client/internal/xtrap"
 )
 
-const maxDirtyRects = 32
+const (
+	maxDirtyRects = 32
+	maxWindows    = 256
+
+	WINDOW_SEARCH_INTERVAL = time.Second // How often a lost window target is looked for
+)
 
 type X11Capturer struct {
 	cap       *C.X11Capture
@@ -326,15 +708,42 @@ type X11Capturer struct {
 	started   bool
 	mu        sync.Mutex
 	framePool *FramePool
+	target    regionCropper
 
 	rgbaBuffer []byte
 	dirtyBuf   []C.int
+
+	// prevFrame is diffed against when the server lacks XDamage
+	prevFrame []byte
+
+	cursorBuf []byte
+
+	// window is the window target, zero while capturing the display.
+	// While no window matches, the display is captured and the window is
+	// looked for again every WINDOW_SEARCH_INTERVAL.
+	window       Target
+	windowActive bool
+	lastSearch   time.Time
+
+	// shm allows MIT-SHM; without it frames are read with XGetImage
+	shm bool
+
+	// damaged is signalled by watchDamage, nil without XDamage
+	damaged   chan struct{}
+	stopWatch chan struct{}
 }
 
 func NewX11Capturer() *X11Capturer {
+	return newX11Capturer(true)
+}
+
+// newX11Capturer creates an X11 capturer, reading frames through MIT-SHM
+// if shm is set and the server supports it.
+func newX11Capturer(shm bool) *X11Capturer {
 	return &X11Capturer{
 		framePool: NewFramePool(),
 		dirtyBuf:  make([]C.int, maxDirtyRects*4),
+		shm:       shm,
 	}
 }
 
@@ -346,8 +755,11 @@ func (c *X11Capturer) Start() error {
 		return ErrAlreadyStarted
 	}
 
-	var width, height C.int
-	c.cap = C.x11_capture_init(&width, &height)
+	var width, height, shm C.int
+	if c.shm {
+		shm = 1
+	}
+	c.cap = C.x11_capture_init(shm, &width, &height)
 	if c.cap == nil {
 		return ErrNoDisplay
 	}
@@ -358,9 +770,49 @@ func (c *X11Capturer) Start() error {
 
 	c.rgbaBuffer = make([]byte, c.width*c.height*4)
 
+	if c.window.Kind == TargetWindow {
+		c.windowActive = false
+		c.findWindow()
+	}
+
+	if C.x11_capture_has_damage(c.cap) != 0 {
+		c.damaged = make(chan struct{}, 1)
+		c.stopWatch = make(chan struct{})
+		go c.watchDamage(int(C.x11_capture_fd(c.cap)), c.damaged, c.stopWatch)
+	}
+
 	return nil
 }
 
+// watchDamage signals damaged whenever XDamage or a source change is
+// queued, until stop is closed.
+func (c *X11Capturer) watchDamage(fd int, damaged, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		c.mu.Lock()
+		changed := c.started && C.x11_capture_changed(c.cap) != 0
+		c.mu.Unlock()
+		if changed {
+			notify(damaged)
+		}
+
+		C.x11_capture_wait(C.int(fd), C.int(DAMAGE_WATCH_INTERVAL.Milliseconds()))
+	}
+}
+
+// Damaged returns a channel that receives when XDamage reports a change,
+// or nil if the server lacks XDamage.
+func (c *X11Capturer) Damaged() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.damaged
+}
+
 func (c *X11Capturer) ReadFrame() (*FrameWithDirty, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -369,14 +821,22 @@ func (c *X11Capturer) ReadFrame() (*FrameWithDirty, error) {
 		return nil, ErrNotStarted
 	}
 
-	numDirty := C.x11_capture_frame(
-		c.cap,
-		(*C.uchar)(unsafe.Pointer(&c.rgbaBuffer[0])),
-		&c.dirtyBuf[0],
-		maxDirtyRects,
-	)
+	if c.window.Kind == TargetWindow && !c.windowActive && time.Since(c.lastSearch) >= WINDOW_SEARCH_INTERVAL {
+		c.findWindow()
+	}
 
-	if numDirty < -1 {
+	numDirty := c.capture()
+	resized := numDirty == C.X11_RESIZED || numDirty == C.X11_TARGET_LOST
+	if numDirty == C.X11_TARGET_LOST {
+		c.windowActive = false
+		c.lastSearch = time.Now()
+	}
+	if resized {
+		// The source changed size: capture again into a buffer that fits
+		c.resize()
+		numDirty = c.capture()
+	}
+	if numDirty == C.X11_ERROR || numDirty == C.X11_RESIZED || numDirty == C.X11_TARGET_LOST {
 		return nil, ErrCaptureFailed
 	}
 
@@ -385,10 +845,25 @@ func (c *X11Capturer) ReadFrame() (*FrameWithDirty, error) {
 
 	result := &FrameWithDirty{
 		Frame:      frame,
-		IsKeyFrame: numDirty == -1,
+		IsKeyFrame: numDirty == C.X11_FULL_FRAME || resized,
 	}
 
-	if numDirty > 0 {
+	if resized {
+		// Damage from before the resize no longer applies
+		c.prevFrame = append(c.prevFrame[:0], frame.Pix...)
+	} else if C.x11_capture_has_damage(c.cap) == 0 {
+		if len(c.prevFrame) == len(frame.Pix) {
+			result.DirtyRects = damage.Diff(frame.Pix, c.prevFrame, c.width, c.height, frame.Stride, damage.DefaultBlockSize)
+			if len(result.DirtyRects) == 0 {
+				c.framePool.Put(frame)
+				return nil, nil
+			}
+			result.IsKeyFrame = false
+		} else {
+			c.prevFrame = make([]byte, len(frame.Pix))
+		}
+		copy(c.prevFrame, frame.Pix)
+	} else if numDirty > 0 {
 		result.DirtyRects = make([]DirtyRect, numDirty)
 		for i := 0; i < int(numDirty); i++ {
 			result.DirtyRects[i] = DirtyRect{
@@ -400,7 +875,143 @@ func (c *X11Capturer) ReadFrame() (*FrameWithDirty, error) {
 		}
 	}
 
-	return result, nil
+	return c.target.crop(result, c.framePool), nil
+}
+
+// capture reads the source into rgbaBuffer. Must be called with c.mu held.
+func (c *X11Capturer) capture() C.int {
+	return C.x11_capture_frame(
+		c.cap,
+		(*C.uchar)(unsafe.Pointer(&c.rgbaBuffer[0])),
+		&c.dirtyBuf[0],
+		maxDirtyRects,
+	)
+}
+
+// resize picks up a new source size after X11_RESIZED, X11_TARGET_LOST or
+// a target change. Must be called with c.mu held.
+func (c *X11Capturer) resize() {
+	var width, height C.int
+	C.x11_capture_size(c.cap, &width, &height)
+	c.width = int(width)
+	c.height = int(height)
+	c.rgbaBuffer = make([]byte, c.width*c.height*4)
+	c.prevFrame = nil
+}
+
+// SetTarget supports the display, regions of it and, with the Composite
+// extension, windows. A window target set before Start is looked for when
+// capture starts.
+func (c *X11Capturer) SetTarget(t Target) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.Kind != TargetWindow {
+		if err := c.target.set(t); err != nil {
+			return err
+		}
+		if c.window.Kind == TargetWindow {
+			c.window = Target{}
+			if c.windowActive {
+				C.x11_capture_set_window(c.cap, 0)
+				c.windowActive = false
+				c.resize()
+			}
+		}
+		return nil
+	}
+
+	if c.started {
+		if C.x11_capture_has_composite(c.cap) == 0 {
+			return fmt.Errorf("%w: window capture needs the Composite extension", ErrNotSupported)
+		}
+		prev := c.window
+		c.window = t
+		if !c.findWindow() {
+			// A window target that was being captured stays
+			c.window = prev
+			return fmt.Errorf("%w: %s", ErrTargetNotFound, t)
+		}
+	} else {
+		c.window = t
+	}
+	c.target.set(Target{})
+	return nil
+}
+
+// findWindow starts capturing the topmost window matching c.window and
+// reports whether there was one. Must be called with c.mu held.
+func (c *X11Capturer) findWindow() bool {
+	c.lastSearch = time.Now()
+
+	windows := make([]C.X11Window, maxWindows)
+	n := C.x11_list_windows(c.cap, &windows[0], maxWindows)
+	defer C.x11_free_windows(&windows[0], n)
+
+	for _, w := range windows[:n] {
+		if !c.window.matchWindow(C.GoString(w.title), C.GoString(w.res_class), C.GoString(w.res_name)) {
+			continue
+		}
+		// A window that can't be captured leaves the display as the source
+		c.windowActive = C.x11_capture_set_window(c.cap, w.frame) != 0
+		c.resize()
+		if c.windowActive {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesShm reports whether frames are read through XShm rather than the
+// slower XGetImage fallback.
+func (c *X11Capturer) UsesShm() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cap == nil {
+		return false
+	}
+	return C.x11_capture_uses_shm(c.cap) != 0
+}
+
+// Cursor reads the pointer through XFixes.
+func (c *X11Capturer) Cursor() (Cursor, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started {
+		return Cursor{}, false
+	}
+	if c.cursorBuf == nil {
+		c.cursorBuf = make([]byte, MAX_CURSOR_SIZE*MAX_CURSOR_SIZE*4)
+	}
+
+	var x, y, w, h, hotX, hotY C.int
+	var serial C.ulong
+	ok := C.x11_capture_cursor(c.cap, &x, &y, &w, &h, &hotX, &hotY, &serial,
+		(*C.uchar)(unsafe.Pointer(&c.cursorBuf[0])), MAX_CURSOR_SIZE)
+	if ok == 0 {
+		return Cursor{}, false
+	}
+
+	cursor := Cursor{X: int(x), Y: int(y), Visible: true}
+	if w > 0 && h > 0 {
+		shape := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+		copy(shape.Pix, c.cursorBuf)
+		cursor.Shape = shape
+		cursor.HotX, cursor.HotY = int(hotX), int(hotY)
+		// Serial 0 is reserved for unknown shapes
+		cursor.Serial = uint32(serial) | 1<<31
+	}
+	if cursor.X < 0 || cursor.Y < 0 || cursor.X >= c.width || cursor.Y >= c.height {
+		// Outside the window target
+		cursor.Visible = false
+	}
+	return c.target.cursor(cursor, c.width, c.height), true
 }
 
 func (c *X11Capturer) Stop() {
@@ -411,6 +1022,12 @@ func (c *X11Capturer) Stop() {
 		C.x11_capture_destroy(c.cap)
 		c.cap = nil
 		c.started = false
+		c.windowActive = false
+		if c.stopWatch != nil {
+			close(c.stopWatch)
+			c.stopWatch = nil
+			c.damaged = nil
+		}
 	}
 }
 
@@ -423,3 +1040,10 @@ func (c *X11Capturer) SupportsDirtyRects() bool {
 	}
 	return C.x11_capture_has_damage(c.cap) != 0
 }
+
+func (c *X11Capturer) Name() string {
+	if !c.shm {
+		return "X11 XGetImage"
+	}
+	return "X11"
+}
@@ -0,0 +1,328 @@
+//go:build linux && !wayland && cgo
+// +build linux,!wayland,cgo
+
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	xvfbW, xvfbH     = 1024, 768
+	resizeW, resizeH = 800, 600
+)
+
+// xvfb starts an Xvfb server on a free display, points DISPLAY at it and
+// returns a connection to draw with. The test is skipped without Xvfb; it
+// never draws on the display the tests run under.
+func xvfb(t *testing.T, shm bool) *xscreen {
+	t.Helper()
+	bin, err := exec.LookPath("Xvfb")
+	if err != nil {
+		t.Skip("Xvfb not installed")
+	}
+
+	// Xvfb picks a free display and writes its number to -displayfd
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	args := []string{"-displayfd", "3", "-screen", "0", fmt.Sprintf("%dx%dx24", xvfbW, xvfbH), "-nolisten", "tcp"}
+	if !shm {
+		args = append(args, "-extension", "MIT-SHM")
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		t.Skipf("Xvfb: %v", err)
+	}
+	w.Close()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	number := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		number <- strings.TrimSpace(line)
+	}()
+	var display string
+	select {
+	case n := <-number:
+		if n == "" {
+			t.Fatal("Xvfb exited without a display")
+		}
+		display = ":" + n
+	case <-time.After(10 * time.Second):
+		t.Fatal("Xvfb did not start")
+	}
+	t.Setenv("DISPLAY", display)
+
+	conn, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	s := &xscreen{t: t, conn: conn, root: xproto.Setup(conn).DefaultScreen(conn).Root}
+
+	s.gc, err = xproto.NewGcontextId(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xproto.CreateGC(conn, s.gc, xproto.Drawable(s.root), 0, nil)
+	s.fill(0, 0, xvfbW, xvfbH, 0x000000)
+	return s
+}
+
+// xscreen draws on an Xvfb root window.
+type xscreen struct {
+	t    *testing.T
+	conn *xgb.Conn
+	root xproto.Window
+	gc   xproto.Gcontext
+}
+
+// sync waits until the server has handled every request sent so far.
+func (s *xscreen) sync() {
+	if _, err := xproto.GetInputFocus(s.conn).Reply(); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// fill paints a rectangle of the root window with a 0xRRGGBB colour.
+func (s *xscreen) fill(x, y, w, h int, rgb uint32) {
+	xproto.ChangeGC(s.conn, s.gc, xproto.GcForeground, []uint32{rgb})
+	xproto.PolyFillRectangle(s.conn, xproto.Drawable(s.root), s.gc, []xproto.Rectangle{
+		{X: int16(x), Y: int16(y), Width: uint16(w), Height: uint16(h)},
+	})
+	s.sync()
+}
+
+// window maps an override-redirect window filled with a 0xRRGGBB colour.
+func (s *xscreen) window(x, y, w, h int, rgb uint32, title string) {
+	id, err := xproto.NewWindowId(s.conn)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	err = xproto.CreateWindowChecked(s.conn, 0, id, s.root, int16(x), int16(y), uint16(w), uint16(h), 0,
+		xproto.WindowClassInputOutput, 0, xproto.CwBackPixel|xproto.CwOverrideRedirect, []uint32{rgb, 1}).Check()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	xproto.ChangeProperty(s.conn, xproto.PropModeReplace, id, xproto.AtomWmName, xproto.AtomString,
+		8, uint32(len(title)), []byte(title))
+	class := title + "\x00ExamGuardCheck\x00"
+	xproto.ChangeProperty(s.conn, xproto.PropModeReplace, id, xproto.AtomWmClass, xproto.AtomString,
+		8, uint32(len(class)), []byte(class))
+	xproto.MapWindow(s.conn, id)
+	s.sync()
+}
+
+// resize changes the screen size through RandR, as xrandr --fb does.
+func (s *xscreen) resize(w, h int) error {
+	if err := randr.Init(s.conn); err != nil {
+		return err
+	}
+	// At 96 dpi
+	return randr.SetScreenSizeChecked(s.conn, s.root, uint16(w), uint16(h),
+		uint32(w*254/960), uint32(h*254/960)).Check()
+}
+
+func checkPixel(t *testing.T, frame *Frame, x, y int, r, g, b byte, name string) {
+	t.Helper()
+	i := y*frame.Stride + x*4
+	if p := frame.Pix[i : i+4]; p[0] != r || p[1] != g || p[2] != b || p[3] != 255 {
+		t.Errorf("%s pixel at %d,%d is %v, want [%d %d %d 255]", name, x, y, p, r, g, b)
+	}
+}
+
+// covers reports whether every pixel of want lies in one of rects.
+func covers(rects []DirtyRect, want DirtyRect) bool {
+	for y := want.Y; y < want.Y+want.H; y++ {
+		for x := want.X; x < want.X+want.W; x++ {
+			inside := false
+			for _, r := range rects {
+				if x >= r.X && x < r.X+r.W && y >= r.Y && y < r.Y+r.H {
+					inside = true
+					break
+				}
+			}
+			if !inside {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// readFrame reads a frame, failing the test if there is none.
+func readFrame(t *testing.T, c *X11Capturer, what string) *FrameWithDirty {
+	t.Helper()
+	f, err := c.ReadFrame()
+	if err != nil || f == nil {
+		t.Fatalf("%s: frame %v, %v", what, f, err)
+	}
+	return f
+}
+
+func TestX11Capture(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverShm bool // Xvfb offers MIT-SHM
+		allowShm  bool // newX11Capturer's shm
+	}{
+		{"shm", true, true},
+		{"xgetimage", true, false},
+		{"server without shm", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scr := xvfb(t, tt.serverShm)
+			scr.fill(10, 10, 50, 40, 0xff0000)
+			scr.fill(100, 10, 50, 40, 0x00ff00)
+			scr.fill(200, 10, 50, 40, 0x0000ff)
+
+			c := newX11Capturer(tt.allowShm)
+			if err := c.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer c.Stop()
+			if want := tt.serverShm && tt.allowShm; c.UsesShm() != want {
+				t.Errorf("uses XShm = %v, want %v", c.UsesShm(), want)
+			}
+
+			// Pixels and channel order
+			f := readFrame(t, c, "first frame")
+			if f.Frame.W != xvfbW || f.Frame.H != xvfbH {
+				t.Fatalf("frame is %dx%d, want %dx%d", f.Frame.W, f.Frame.H, xvfbW, xvfbH)
+			}
+			if !f.IsKeyFrame {
+				t.Error("first frame is not a keyframe")
+			}
+			checkPixel(t, f.Frame, 30, 30, 255, 0, 0, "red")
+			checkPixel(t, f.Frame, 120, 30, 0, 255, 0, "green")
+			checkPixel(t, f.Frame, 220, 30, 0, 0, 255, "blue")
+			checkPixel(t, f.Frame, 5, 5, 0, 0, 0, "background")
+
+			// Dirty rectangles, announced through Damaged
+			damaged := c.Damaged()
+			if damaged == nil {
+				t.Fatal("no damage notifications")
+			}
+			select {
+			case <-damaged: // The first frame's
+			default:
+			}
+			drawn := DirtyRect{X: 300, Y: 200, W: 20, H: 20}
+			scr.fill(drawn.X, drawn.Y, drawn.W, drawn.H, 0xffffff)
+			select {
+			case <-damaged:
+			case <-time.After(time.Second):
+				t.Error("no damage notified within a second")
+			}
+			time.Sleep(100 * time.Millisecond)
+			f = readFrame(t, c, "after drawing")
+			if f.IsKeyFrame {
+				t.Error("change reported as a keyframe, want dirty rects")
+			}
+			if !covers(f.DirtyRects, drawn) {
+				t.Errorf("dirty rects %v don't cover %v", f.DirtyRects, drawn)
+			}
+			checkPixel(t, f.Frame, 310, 210, 255, 255, 255, "drawn")
+
+			// Resize
+			if err := scr.resize(resizeW, resizeH); err != nil {
+				t.Skipf("resize through RandR: %v", err)
+			}
+			time.Sleep(200 * time.Millisecond)
+			f = readFrame(t, c, "after resize")
+			if f.Frame.W != resizeW || f.Frame.H != resizeH {
+				t.Errorf("resized frame is %dx%d, want %dx%d", f.Frame.W, f.Frame.H, resizeW, resizeH)
+			}
+			if !f.IsKeyFrame {
+				t.Error("resized frame is not a keyframe")
+			}
+			checkPixel(t, f.Frame, 30, 30, 255, 0, 0, "red after resize")
+		})
+	}
+}
+
+func TestX11CaptureDamageOverflow(t *testing.T) {
+	scr := xvfb(t, true)
+	c := newX11Capturer(true)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	readFrame(t, c, "first frame")
+
+	// More separate changes than there are damage slots
+	var drawn []DirtyRect
+	for i := 0; i < maxDirtyRects*2; i++ {
+		r := DirtyRect{X: (i % 16) * 60, Y: (i / 16) * 60, W: 10, H: 10}
+		scr.fill(r.X, r.Y, r.W, r.H, 0xffffff)
+		drawn = append(drawn, r)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	f := readFrame(t, c, "after drawing")
+	for _, r := range drawn {
+		if !f.IsKeyFrame && !covers(f.DirtyRects, r) {
+			t.Errorf("dirty rects %v lost the change at %v", f.DirtyRects, r)
+		}
+	}
+	if len(f.DirtyRects) > maxDirtyRects {
+		t.Errorf("%d dirty rects, want at most %d", len(f.DirtyRects), maxDirtyRects)
+	}
+}
+
+func TestX11CaptureWindow(t *testing.T) {
+	scr := xvfb(t, true)
+	c := newX11Capturer(true)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	// The exam window is half covered by another; XComposite still sees
+	// all of it
+	scr.window(100, 100, 200, 150, 0x00ff00, "exam browser")
+	scr.window(200, 100, 200, 150, 0x0000ff, "chat")
+	time.Sleep(100 * time.Millisecond)
+
+	if err := c.SetTarget(Target{Kind: TargetWindow, Title: "EXAM"}); err != nil {
+		t.Fatal(err)
+	}
+	f := readFrame(t, c, "window")
+	if f.Frame.W != 200 || f.Frame.H != 150 {
+		t.Errorf("window frame is %dx%d, want 200x150", f.Frame.W, f.Frame.H)
+	}
+	if !f.IsKeyFrame {
+		t.Error("first window frame is not a keyframe")
+	}
+	checkPixel(t, f.Frame, 10, 10, 0, 255, 0, "window")
+	checkPixel(t, f.Frame, 150, 10, 0, 255, 0, "covered part of the window")
+
+	if err := c.SetTarget(Target{Kind: TargetWindow, Class: "no-such-class"}); err == nil {
+		t.Error("a target matching no window was accepted")
+	}
+
+	c.SetTarget(Target{})
+	if f := readFrame(t, c, "display"); f.Frame.W != xvfbW {
+		t.Errorf("back on the display: frame is %d wide, want %d", f.Frame.W, xvfbW)
+	}
+}
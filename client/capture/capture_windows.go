@@ -12,6 +12,8 @@ package capture
 #include <d3d11.h>
 #include <dxgi1_2.h>
 
+#define DXGI_MAX_CURSOR_SIZE 256
+
 // DXGI Desktop Duplication capture state
 typedef struct {
     ID3D11Device *device;
@@ -31,8 +33,107 @@ typedef struct {
     RECT *dirty_rects;
     int dirty_rect_count;
     int dirty_rect_capacity;
+
+    // Pointer as reported with the frames; the desktop image leaves it out
+    int pointer_known;
+    int pointer_visible;
+    int pointer_x;
+    int pointer_y;
+    unsigned char *shape_buffer;
+    UINT shape_buffer_size;
+    unsigned char *shape_rgba; // DXGI_MAX_CURSOR_SIZE² premultiplied RGBA
+    int shape_w;
+    int shape_h;
+    int shape_hot_x;
+    int shape_hot_y;
+    unsigned int shape_serial;
 } DXGICapture;
 
+// Convert the pointer shape of the current frame to premultiplied RGBA.
+// Masked shapes that invert the screen can't be shown as an image and are
+// approximated.
+static void dxgi_read_pointer_shape(DXGICapture *cap, UINT size) {
+    if (size > cap->shape_buffer_size) {
+        unsigned char *buffer = (unsigned char*)realloc(cap->shape_buffer, size);
+        if (!buffer) return;
+        cap->shape_buffer = buffer;
+        cap->shape_buffer_size = size;
+    }
+    if (!cap->shape_rgba) {
+        cap->shape_rgba = (unsigned char*)malloc(DXGI_MAX_CURSOR_SIZE * DXGI_MAX_CURSOR_SIZE * 4);
+        if (!cap->shape_rgba) return;
+    }
+
+    DXGI_OUTDUPL_POINTER_SHAPE_INFO info;
+    UINT required = 0;
+    HRESULT hr = cap->duplication->lpVtbl->GetFramePointerShape(
+        cap->duplication, size, cap->shape_buffer, &required, &info);
+    if (FAILED(hr)) return;
+
+    int w = info.Width;
+    int h = info.Height;
+    if (info.Type == DXGI_OUTDUPL_POINTER_SHAPE_TYPE_MONOCHROME) {
+        h /= 2; // AND mask above XOR mask
+    }
+    if (w <= 0 || h <= 0 || w > DXGI_MAX_CURSOR_SIZE || h > DXGI_MAX_CURSOR_SIZE) {
+        cap->shape_w = 0;
+        cap->shape_h = 0;
+        cap->shape_serial++;
+        return;
+    }
+
+    unsigned char *src = cap->shape_buffer;
+    unsigned char *out = cap->shape_rgba;
+    for (int y = 0; y < h; y++) {
+        for (int x = 0; x < w; x++) {
+            unsigned char *o = out + (y * w + x) * 4;
+            unsigned char r = 0, g = 0, b = 0, a = 0;
+
+            if (info.Type == DXGI_OUTDUPL_POINTER_SHAPE_TYPE_MONOCHROME) {
+                int bit = 0x80 >> (x % 8);
+                int and_bit = src[y * info.Pitch + x / 8] & bit;
+                int xor_bit = src[(y + h) * info.Pitch + x / 8] & bit;
+                if (!and_bit) {
+                    // Opaque black or white
+                    r = g = b = xor_bit ? 255 : 0;
+                    a = 255;
+                } else if (xor_bit) {
+                    // Inverts the screen; show black
+                    a = 255;
+                }
+            } else {
+                unsigned char *p = src + y * info.Pitch + x * 4;
+                if (info.Type == DXGI_OUTDUPL_POINTER_SHAPE_TYPE_COLOR) {
+                    // Straight alpha BGRA
+                    a = p[3];
+                    r = p[2] * a / 255;
+                    g = p[1] * a / 255;
+                    b = p[0] * a / 255;
+                } else if (p[3] == 0 || p[0] || p[1] || p[2]) {
+                    // Masked colour: alpha 0 replaces the screen, otherwise
+                    // the colour is XORed with it, which black leaves as is
+                    r = p[2];
+                    g = p[1];
+                    b = p[0];
+                    a = 255;
+                }
+            }
+
+            o[0] = r;
+            o[1] = g;
+            o[2] = b;
+            o[3] = a;
+        }
+    }
+
+    cap->shape_w = w;
+    cap->shape_h = h;
+    cap->shape_hot_x = info.HotSpot.x;
+    cap->shape_hot_y = info.HotSpot.y;
+    cap->shape_serial++;
+}
+
+
 // Initialize DXGI Desktop Duplication
 DXGICapture* dxgi_capture_init(int *out_width, int *out_height) {
     DXGICapture *cap = (DXGICapture*)calloc(1, sizeof(DXGICapture));
@@ -182,6 +283,18 @@ int dxgi_capture_frame(DXGICapture *cap, unsigned char *rgba_out, int *dirty_rec
         return -2;
     }
 
+    // Pointer updates come with the frame; a zero update time means the
+    // pointer didn't change
+    if (frameInfo.LastMouseUpdateTime.QuadPart != 0) {
+        cap->pointer_known = 1;
+        cap->pointer_visible = frameInfo.PointerPosition.Visible;
+        cap->pointer_x = frameInfo.PointerPosition.Position.x;
+        cap->pointer_y = frameInfo.PointerPosition.Position.y;
+    }
+    if (frameInfo.PointerShapeBufferSize > 0) {
+        dxgi_read_pointer_shape(cap, frameInfo.PointerShapeBufferSize);
+    }
+
     int dirty_count = 0;
     int full_frame = 1;
 
@@ -278,6 +391,29 @@ void dxgi_capture_get_size(DXGICapture *cap, int *width, int *height) {
     }
 }
 
+// Get the pointer. Returns 0 if no pointer update has been seen yet. The
+// shape is copied to rgba_out, which must hold DXGI_MAX_CURSOR_SIZE² pixels;
+// *w and *h are 0 if the shape is unknown.
+int dxgi_capture_cursor(DXGICapture *cap, int *x, int *y, int *visible,
+                        int *w, int *h, int *hot_x, int *hot_y,
+                        unsigned int *serial, unsigned char *rgba_out) {
+    if (!cap || !cap->pointer_known) return 0;
+
+    // DXGI positions the shape's top left corner, not the hotspot
+    *x = cap->pointer_x + cap->shape_hot_x;
+    *y = cap->pointer_y + cap->shape_hot_y;
+    *visible = cap->pointer_visible;
+    *w = cap->shape_w;
+    *h = cap->shape_h;
+    *hot_x = cap->shape_hot_x;
+    *hot_y = cap->shape_hot_y;
+    *serial = cap->shape_serial;
+    if (cap->shape_rgba && cap->shape_w > 0) {
+        memcpy(rgba_out, cap->shape_rgba, cap->shape_w * cap->shape_h * 4);
+    }
+    return 1;
+}
+
 // Check if initialized
 int dxgi_capture_is_valid(DXGICapture *cap) {
     return cap && cap->initialized;
@@ -289,6 +425,8 @@ void dxgi_capture_destroy(DXGICapture *cap) {
 
     if (cap->dirty_rects) free(cap->dirty_rects);
     if (cap->prev_frame) free(cap->prev_frame);
+    if (cap->shape_buffer) free(cap->shape_buffer);
+    if (cap->shape_rgba) free(cap->shape_rgba);
 
     if (cap->staging_texture) cap->staging_texture->lpVtbl->Release(cap->staging_texture);
     if (cap->duplication) cap->duplication->lpVtbl->Release(cap->duplication);
@@ -300,6 +438,7 @@ void dxgi_capture_destroy(DXGICapture *cap) {
 */
 import "C"
 import (
+	"image"
 	"sync"
 	"unsafe"
 )
@@ -313,9 +452,11 @@ type DXGICapturer struct {
 	started   bool
 	mu        sync.Mutex
 	framePool *FramePool
+	target    regionCropper
 
 	rgbaBuffer []byte
 	dirtyBuf   []C.int
+	cursorBuf  []byte
 
 	keyFrameCounter int
 }
@@ -408,7 +549,38 @@ func (c *DXGICapturer) ReadFrame() (*FrameWithDirty, error) {
 		}
 	}
 
-	return result, nil
+	return c.target.crop(result, c.framePool), nil
+}
+
+// Cursor returns the pointer as of the last frame.
+func (c *DXGICapturer) Cursor() (Cursor, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started || c.cap == nil {
+		return Cursor{}, false
+	}
+	if c.cursorBuf == nil {
+		c.cursorBuf = make([]byte, C.DXGI_MAX_CURSOR_SIZE*C.DXGI_MAX_CURSOR_SIZE*4)
+	}
+
+	var x, y, visible, w, h, hotX, hotY C.int
+	var serial C.uint
+	ok := C.dxgi_capture_cursor(c.cap, &x, &y, &visible, &w, &h, &hotX, &hotY, &serial,
+		(*C.uchar)(unsafe.Pointer(&c.cursorBuf[0])))
+	if ok == 0 {
+		return Cursor{}, false
+	}
+
+	cursor := Cursor{X: int(x), Y: int(y), Visible: visible != 0}
+	if w > 0 && h > 0 {
+		shape := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+		copy(shape.Pix, c.cursorBuf)
+		cursor.Shape = shape
+		cursor.HotX, cursor.HotY = int(hotX), int(hotY)
+		cursor.Serial = uint32(serial)
+	}
+	return c.target.cursor(cursor, c.width, c.height), true
 }
 
 func (c *DXGICapturer) Stop() {
@@ -422,6 +594,15 @@ func (c *DXGICapturer) Stop() {
 	}
 }
 
+// SetTarget supports the display and regions of it.
+func (c *DXGICapturer) SetTarget(t Target) error {
+	return c.target.set(t)
+}
+
 func (c *DXGICapturer) SupportsDirtyRects() bool {
 	return true
 }
+
+func (c *DXGICapturer) Name() string {
+	return "DXGI"
+}
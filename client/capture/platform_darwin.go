@@ -3,6 +3,10 @@
 
 package capture
 
-func NewPlatformCapturer() Capturer {
-	return NewSCKCapturer()
+// platformBackends lists the macOS backends, best first.
+func platformBackends() []func() Capturer {
+	return []func() Capturer{
+		func() Capturer { return NewSCKCapturer() },
+		func() Capturer { return NewFallbackCapturer() },
+	}
 }
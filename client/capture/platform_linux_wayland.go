@@ -3,6 +3,12 @@
 
 package capture
 
-func NewPlatformCapturer() Capturer {
-	return NewWaylandCapturer()
+// platformBackends lists the Wayland backends: the portal's PipeWire
+// stream, then the screenshot library, which takes single screenshots
+// through the portal when the stream can't be set up.
+func platformBackends() []func() Capturer {
+	return []func() Capturer{
+		func() Capturer { return NewWaylandCapturer() },
+		func() Capturer { return NewFallbackCapturer() },
+	}
 }
@@ -3,14 +3,44 @@
 
 package capture
 
-import "os"
+import (
+	"fmt"
+	"os"
+)
 
-// NewPlatformCapturer creates the platform-specific capturer for Linux.
-// On Wayland (detected via XDG_SESSION_TYPE), uses the portal capturer.
-// On X11, uses the X11 capturer.
-func NewPlatformCapturer() Capturer {
+// platformBackends lists the Linux backends, best first: X11 with MIT-SHM,
+// X11 with plain XGetImage, then the screenshot library. Wayland sessions
+// (detected via XDG_SESSION_TYPE) are only streamed through the portal's
+// PipeWire stream, which needs the wayland build. Without it the chain
+// reports why and degrades to the screenshot library, which takes single
+// screenshots through the portal.
+func platformBackends() []func() Capturer {
 	if os.Getenv("XDG_SESSION_TYPE") == "wayland" {
-		return NewPortalCapturer()
+		return []func() Capturer{
+			func() Capturer {
+				return unavailableCapturer{
+					err: fmt.Errorf("%w: Wayland session needs a client built with -tags wayland", ErrNotSupported),
+				}
+			},
+			func() Capturer { return NewFallbackCapturer() },
+		}
 	}
-	return NewX11Capturer()
+	return []func() Capturer{
+		func() Capturer { return newX11Capturer(true) },
+		func() Capturer { return newX11Capturer(false) },
+		func() Capturer { return NewFallbackCapturer() },
+	}
+}
+
+// unavailableCapturer stands in when no backend can capture this session
+// and reports why from Start.
+type unavailableCapturer struct {
+	err error
 }
+
+func (c unavailableCapturer) Start() error                        { return c.err }
+func (c unavailableCapturer) ReadFrame() (*FrameWithDirty, error) { return nil, ErrNotStarted }
+func (c unavailableCapturer) Stop()                               {}
+func (c unavailableCapturer) SupportsDirtyRects() bool            { return false }
+func (c unavailableCapturer) Name() string                        { return "None" }
+func (c unavailableCapturer) SetTarget(t Target) error            { return c.err }
@@ -0,0 +1,25 @@
+//go:build linux && !wayland && cgo
+// +build linux,!wayland,cgo
+
+package capture
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWaylandSessionFallsBack(t *testing.T) {
+	t.Setenv("XDG_SESSION_TYPE", "wayland")
+
+	backends := platformBackends()
+	if len(backends) < 2 {
+		t.Fatalf("%d backends, want the screenshot library after the unavailable one", len(backends))
+	}
+	first := backends[0]()
+	if err := first.Start(); !errors.Is(err, ErrNotSupported) || classify(err) != failFallback {
+		t.Errorf("first backend: got %v, want an ErrNotSupported the supervisor falls back from", err)
+	}
+	if name := backends[len(backends)-1]().Name(); name != "fallback" {
+		t.Errorf("last backend is %q, want the screenshot library", name)
+	}
+}
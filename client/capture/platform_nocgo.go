@@ -3,6 +3,9 @@
 
 package capture
 
-func NewPlatformCapturer() Capturer {
-	return NewFallbackCapturer()
+// platformBackends lists the backends that work without cgo.
+func platformBackends() []func() Capturer {
+	return []func() Capturer{
+		func() Capturer { return NewFallbackCapturer() },
+	}
 }
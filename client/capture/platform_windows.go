@@ -3,6 +3,10 @@
 
 package capture
 
-func NewPlatformCapturer() Capturer {
-	return NewDXGICapturer()
+// platformBackends lists the Windows backends, best first.
+func platformBackends() []func() Capturer {
+	return []func() Capturer{
+		func() Capturer { return NewDXGICapturer() },
+		func() Capturer { return NewFallbackCapturer() },
+	}
 }
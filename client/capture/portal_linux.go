@@ -0,0 +1,337 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	portalDest      = "org.freedesktop.portal.Desktop"
+	portalPath      = "/org/freedesktop/portal/desktop"
+	screenCastIface = "org.freedesktop.portal.ScreenCast"
+	requestIface    = "org.freedesktop.portal.Request"
+	sessionIface    = "org.freedesktop.portal.Session"
+
+	PORTAL_TIMEOUT = 2 * time.Minute // Start waits for the student to answer the share dialog
+)
+
+// ScreenCast option values, see org.freedesktop.portal.ScreenCast.
+const (
+	portalSourceMonitor       uint32 = 1
+	portalCursorEmbedded      uint32 = 2 // Drawn into the stream
+	portalPersistUntilRevoked uint32 = 2
+)
+
+// Request response codes.
+const (
+	portalResponseSuccess   uint32 = 0
+	portalResponseCancelled uint32 = 1
+)
+
+var (
+	ErrPortalTimeout  = errors.New("capture: portal did not respond")
+	ErrPortalNoStream = errors.New("capture: portal returned no stream")
+)
+
+// ScreenCastPortal opens screen cast sessions through xdg-desktop-portal.
+// The zero value talks to the real portal on the session bus and keeps the
+// restore token in the user config dir, so a student approves the share
+// dialog once rather than on every reconnect.
+type ScreenCastPortal struct {
+	// Conn is the bus to use. If nil, a private session bus connection is
+	// opened per session and closed with it.
+	Conn *dbus.Conn
+	// Dest is the portal's bus name, portalDest if empty.
+	Dest string
+	// TokenFile stores the restore token. If empty, defaultTokenFile is used.
+	TokenFile string
+	// Timeout bounds each portal request, PORTAL_TIMEOUT if zero.
+	Timeout time.Duration
+}
+
+// ScreenCastSession is a started screen cast. The PipeWire stream NodeID is
+// read from the remote behind FD.
+type ScreenCastSession struct {
+	NodeID uint32
+	Width  int // Stream size when the portal reports it, else 0
+	Height int
+	// FD is a PipeWire remote limited to the shared stream. Whoever
+	// connects to it takes ownership; it is -1 once taken.
+	FD int
+
+	conn    *dbus.Conn
+	ownConn bool
+	dest    string
+	handle  dbus.ObjectPath
+}
+
+// portalCall runs the request/response pattern of one session.
+type portalCall struct {
+	conn    *dbus.Conn
+	portal  dbus.BusObject
+	signals chan *dbus.Signal
+	timeout time.Duration
+}
+
+// Open asks the portal for a monitor and starts the screen cast. It blocks
+// while the portal shows its dialog.
+func (p *ScreenCastPortal) Open() (*ScreenCastSession, error) {
+	conn, ownConn := p.Conn, false
+	if conn == nil {
+		var err error
+		conn, err = dbus.ConnectSessionBus()
+		if err != nil {
+			return nil, fmt.Errorf("capture: session bus: %w", err)
+		}
+		ownConn = true
+	}
+
+	session, err := p.open(conn)
+	if err != nil {
+		if ownConn {
+			conn.Close()
+		}
+		return nil, err
+	}
+	session.conn = conn
+	session.ownConn = ownConn
+	return session, nil
+}
+
+func (p *ScreenCastPortal) open(conn *dbus.Conn) (*ScreenCastSession, error) {
+	dest := p.Dest
+	if dest == "" {
+		dest = portalDest
+	}
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = PORTAL_TIMEOUT
+	}
+
+	call := &portalCall{
+		conn:    conn,
+		portal:  conn.Object(dest, portalPath),
+		signals: make(chan *dbus.Signal, 8),
+		timeout: timeout,
+	}
+	match := []dbus.MatchOption{
+		dbus.WithMatchInterface(requestIface),
+		dbus.WithMatchMember("Response"),
+	}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return nil, fmt.Errorf("capture: portal signals: %w", err)
+	}
+	conn.Signal(call.signals)
+	defer func() {
+		conn.RemoveSignal(call.signals)
+		conn.RemoveMatchSignal(match...)
+	}()
+
+	results, err := call.request("CreateSession", map[string]dbus.Variant{
+		"session_handle_token": dbus.MakeVariant(portalToken()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var handle string
+	if v, ok := results["session_handle"]; !ok || v.Store(&handle) != nil || handle == "" {
+		return nil, fmt.Errorf("capture: portal CreateSession: no session handle")
+	}
+	session := &ScreenCastSession{FD: -1, dest: dest, handle: dbus.ObjectPath(handle)}
+
+	if err := p.start(call, session); err != nil {
+		closePortalSession(conn, dest, session.handle)
+		return nil, err
+	}
+	return session, nil
+}
+
+// start selects a monitor, starts the cast and opens the PipeWire remote.
+func (p *ScreenCastPortal) start(call *portalCall, session *ScreenCastSession) error {
+	options := map[string]dbus.Variant{
+		"types":        dbus.MakeVariant(portalSourceMonitor),
+		"multiple":     dbus.MakeVariant(false),
+		"cursor_mode":  dbus.MakeVariant(portalCursorEmbedded),
+		"persist_mode": dbus.MakeVariant(portalPersistUntilRevoked),
+	}
+	if token := p.loadToken(); token != "" {
+		options["restore_token"] = dbus.MakeVariant(token)
+	}
+	if _, err := call.request("SelectSources", options, session.handle); err != nil {
+		return err
+	}
+
+	results, err := call.request("Start", map[string]dbus.Variant{}, session.handle, "")
+	if err != nil {
+		return err
+	}
+
+	var streams []struct {
+		NodeID     uint32
+		Properties map[string]dbus.Variant
+	}
+	if v, ok := results["streams"]; !ok || v.Store(&streams) != nil || len(streams) == 0 {
+		return ErrPortalNoStream
+	}
+	session.NodeID = streams[0].NodeID
+	if v, ok := streams[0].Properties["size"]; ok {
+		// A (ii) struct
+		var size struct{ W, H int32 }
+		if v.Store(&size) == nil {
+			session.Width, session.Height = int(size.W), int(size.H)
+		}
+	}
+
+	// A token is only issued when the portal supports persistence; an old
+	// token has been consumed either way
+	var token string
+	if v, ok := results["restore_token"]; ok {
+		v.Store(&token)
+	}
+	p.saveToken(token)
+
+	var fd dbus.UnixFD
+	err = call.portal.Call(screenCastIface+".OpenPipeWireRemote", 0,
+		session.handle, map[string]dbus.Variant{}).Store(&fd)
+	if err != nil {
+		return fmt.Errorf("capture: portal OpenPipeWireRemote: %w", err)
+	}
+	session.FD = int(fd)
+	return nil
+}
+
+// request calls a ScreenCast method that answers through a Request object
+// and waits for its Response. options is the trailing vardict of the
+// method; args come before it.
+func (c *portalCall) request(method string, options map[string]dbus.Variant, args ...interface{}) (map[string]dbus.Variant, error) {
+	token := portalToken()
+	options["handle_token"] = dbus.MakeVariant(token)
+
+	// The request path is predictable, so a Response that arrives before
+	// the call returns is still recognised
+	expected := requestPath(c.conn, token)
+
+	var handle dbus.ObjectPath
+	args = append(args, options)
+	if err := c.portal.Call(screenCastIface+"."+method, 0, args...).Store(&handle); err != nil {
+		return nil, fmt.Errorf("capture: portal %s: %w", method, err)
+	}
+
+	timeout := time.NewTimer(c.timeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case sig := <-c.signals:
+			if sig.Name != requestIface+".Response" || (sig.Path != handle && sig.Path != expected) {
+				continue
+			}
+			if len(sig.Body) < 2 {
+				return nil, fmt.Errorf("capture: portal %s: bad response", method)
+			}
+			code, _ := sig.Body[0].(uint32)
+			results, _ := sig.Body[1].(map[string]dbus.Variant)
+			switch code {
+			case portalResponseSuccess:
+				return results, nil
+			case portalResponseCancelled:
+				return nil, ErrPermissionDenied
+			default:
+				return nil, fmt.Errorf("capture: portal %s failed", method)
+			}
+		case <-timeout.C:
+			return nil, ErrPortalTimeout
+		}
+	}
+}
+
+// requestPath is the object path the portal uses for a request made with
+// token by conn.
+func requestPath(conn *dbus.Conn, token string) dbus.ObjectPath {
+	var sender string
+	if names := conn.Names(); len(names) > 0 {
+		sender = strings.ReplaceAll(strings.TrimPrefix(names[0], ":"), ".", "_")
+	}
+	return dbus.ObjectPath(portalPath + "/request/" + sender + "/" + token)
+}
+
+// portalToken returns a handle token, unique enough for one connection.
+func portalToken() string {
+	return "examguard_" + strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// Close ends the screen cast. The PipeWire stream stops with it.
+func (s *ScreenCastSession) Close() {
+	if s.FD >= 0 {
+		os.NewFile(uintptr(s.FD), "pipewire").Close()
+		s.FD = -1
+	}
+	if s.conn == nil {
+		return
+	}
+	closePortalSession(s.conn, s.dest, s.handle)
+	if s.ownConn {
+		s.conn.Close()
+	}
+	s.conn = nil
+}
+
+func closePortalSession(conn *dbus.Conn, dest string, handle dbus.ObjectPath) {
+	conn.Object(dest, handle).Call(sessionIface+".Close", 0)
+}
+
+// defaultTokenFile is where the restore token is kept between runs.
+func defaultTokenFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "exam-guard", "screencast-token")
+}
+
+func (p *ScreenCastPortal) tokenFile() string {
+	if p.TokenFile != "" {
+		return p.TokenFile
+	}
+	return defaultTokenFile()
+}
+
+func (p *ScreenCastPortal) loadToken() string {
+	path := p.tokenFile()
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// saveToken stores token, or forgets the old one if token is empty.
+// Failing to save only means the dialog is shown again next time.
+func (p *ScreenCastPortal) saveToken(token string) {
+	path := p.tokenFile()
+	if path == "" {
+		return
+	}
+	if token == "" {
+		os.Remove(path)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	os.WriteFile(path, []byte(token), 0o600)
+}
@@ -0,0 +1,269 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const testPortalDest = "org.exam_guard.TestPortal"
+
+// privateBus starts a dbus-daemon of its own and returns its address.
+func privateBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "bus")
+	config := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(config, []byte(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=`+socket+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--nopidfile")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			return "unix:path=" + socket
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("dbus-daemon did not create its socket")
+	return ""
+}
+
+// fakeScreenCast is a ScreenCast portal that shares one 1920x1080 monitor.
+// Each request answers with a Response signal from the request path, sent
+// before the method returns as the real portal may.
+type fakeScreenCast struct {
+	conn *dbus.Conn
+
+	mu          sync.Mutex
+	cancel      bool   // The student dismisses the share dialog
+	gotToken    string // restore_token passed to SelectSources
+	issueToken  string // restore_token returned by Start
+	closed      []dbus.ObjectPath
+	openedPipes []*os.File
+}
+
+// state returns what the portal saw, under its lock: the exported methods
+// run on the bus connection's goroutines.
+func (p *fakeScreenCast) state() (gotToken string, closed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gotToken, len(p.closed)
+}
+
+func (p *fakeScreenCast) set(cancel bool, issueToken string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cancel, p.issueToken = cancel, issueToken
+}
+
+func (p *fakeScreenCast) respond(sender dbus.Sender, options map[string]dbus.Variant, code uint32, results map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	var token string
+	if v, ok := options["handle_token"]; !ok || v.Store(&token) != nil {
+		return "", dbus.MakeFailedError(errors.New("no handle_token"))
+	}
+	name := strings.ReplaceAll(strings.TrimPrefix(string(sender), ":"), ".", "_")
+	path := dbus.ObjectPath(portalPath + "/request/" + name + "/" + token)
+	if err := p.conn.Emit(path, requestIface+".Response", code, results); err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return path, nil
+}
+
+func (p *fakeScreenCast) CreateSession(sender dbus.Sender, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	session := dbus.ObjectPath(portalPath + "/session/test")
+	if err := p.conn.Export(&fakeSession{portal: p, path: session}, session, sessionIface); err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return p.respond(sender, options, portalResponseSuccess, map[string]dbus.Variant{
+		"session_handle": dbus.MakeVariant(string(session)),
+	})
+}
+
+func (p *fakeScreenCast) SelectSources(sender dbus.Sender, session dbus.ObjectPath, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.gotToken = ""
+	if v, ok := options["restore_token"]; ok {
+		v.Store(&p.gotToken)
+	}
+	p.mu.Unlock()
+
+	if cancel {
+		return p.respond(sender, options, portalResponseCancelled, map[string]dbus.Variant{})
+	}
+	return p.respond(sender, options, portalResponseSuccess, map[string]dbus.Variant{})
+}
+
+func (p *fakeScreenCast) Start(sender dbus.Sender, session dbus.ObjectPath, parent string, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	type size struct{ W, H int32 }
+	streams := []struct {
+		NodeID     uint32
+		Properties map[string]dbus.Variant
+	}{
+		{NodeID: 42, Properties: map[string]dbus.Variant{"size": dbus.MakeVariant(size{1920, 1080})}},
+	}
+	p.mu.Lock()
+	token := p.issueToken
+	p.mu.Unlock()
+	return p.respond(sender, options, portalResponseSuccess, map[string]dbus.Variant{
+		"streams":       dbus.MakeVariant(streams),
+		"restore_token": dbus.MakeVariant(token),
+	})
+}
+
+func (p *fakeScreenCast) OpenPipeWireRemote(session dbus.ObjectPath, options map[string]dbus.Variant) (dbus.UnixFD, *dbus.Error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, dbus.MakeFailedError(err)
+	}
+	p.mu.Lock()
+	p.openedPipes = append(p.openedPipes, r, w)
+	p.mu.Unlock()
+	return dbus.UnixFD(r.Fd()), nil
+}
+
+type fakeSession struct {
+	portal *fakeScreenCast
+	path   dbus.ObjectPath
+}
+
+func (s *fakeSession) Close() *dbus.Error {
+	s.portal.mu.Lock()
+	s.portal.closed = append(s.portal.closed, s.path)
+	s.portal.mu.Unlock()
+	return nil
+}
+
+// startFakePortal exports a fakeScreenCast on the bus at addr and returns
+// it with a client connection to the same bus.
+func startFakePortal(t *testing.T, addr string) (*fakeScreenCast, *dbus.Conn) {
+	t.Helper()
+	connect := func() *dbus.Conn {
+		conn, err := dbus.Connect(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	portal := &fakeScreenCast{conn: connect()}
+	t.Cleanup(func() {
+		portal.mu.Lock()
+		defer portal.mu.Unlock()
+		for _, f := range portal.openedPipes {
+			f.Close()
+		}
+	})
+	if err := portal.conn.Export(portal, portalPath, screenCastIface); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := portal.conn.RequestName(testPortalDest, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request name: %v, %v", reply, err)
+	}
+	return portal, connect()
+}
+
+func TestScreenCastPortal(t *testing.T) {
+	portal, conn := startFakePortal(t, privateBus(t))
+	portal.set(false, "token-1")
+	tokenFile := filepath.Join(t.TempDir(), "exam-guard", "screencast-token")
+	p := &ScreenCastPortal{Conn: conn, Dest: testPortalDest, TokenFile: tokenFile, Timeout: 5 * time.Second}
+
+	session, err := p.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.NodeID != 42 {
+		t.Errorf("NodeID = %d, want 42", session.NodeID)
+	}
+	if session.Width != 1920 || session.Height != 1080 {
+		t.Errorf("size = %dx%d, want 1920x1080", session.Width, session.Height)
+	}
+	if session.FD < 0 {
+		t.Error("no PipeWire remote")
+	}
+	if got, _ := portal.state(); got != "" {
+		t.Errorf("first session sent restore token %q", got)
+	}
+	if data, err := os.ReadFile(tokenFile); err != nil || string(data) != "token-1" {
+		t.Errorf("saved token %q, %v; want token-1", data, err)
+	}
+
+	session.Close()
+	if session.FD != -1 {
+		t.Error("Close left the remote open")
+	}
+	if _, closed := portal.state(); closed != 1 {
+		t.Errorf("%d sessions closed on the portal, want 1", closed)
+	}
+
+	// The next session restores the grant and consumes the token
+	portal.set(false, "")
+	session, err = p.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Close()
+	if got, _ := portal.state(); got != "token-1" {
+		t.Errorf("second session sent restore token %q, want token-1", got)
+	}
+	if _, err := os.Stat(tokenFile); !os.IsNotExist(err) {
+		t.Errorf("a consumed token was kept: %v", err)
+	}
+}
+
+func TestScreenCastPortalCancelled(t *testing.T) {
+	portal, conn := startFakePortal(t, privateBus(t))
+	portal.set(true, "")
+	p := &ScreenCastPortal{Conn: conn, Dest: testPortalDest, TokenFile: filepath.Join(t.TempDir(), "token"), Timeout: 5 * time.Second}
+
+	if _, err := p.Open(); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("got %v, want ErrPermissionDenied", err)
+	}
+	// The supervisor moves on to the next backend
+	if classify(ErrPermissionDenied) != failFallback {
+		t.Error("a refused share dialog is not a fallback error")
+	}
+	if _, closed := portal.state(); closed != 1 {
+		t.Errorf("%d sessions closed after the dialog was dismissed, want 1", closed)
+	}
+}
@@ -0,0 +1,201 @@
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/exam-gaurd/client/damage"
+)
+
+// ReplayCapturer plays back a directory of PNG screenshots in name order,
+// looping at the end. A screenshot may have a sidecar file with the same
+// name and a .rects extension listing its dirty rectangles, one "x y w h"
+// per line, and an empty one sends it as a keyframe. Without a sidecar the
+// rectangles are found by diffing against the previous screenshot.
+type ReplayCapturer struct {
+	dir       string
+	width     int
+	height    int
+	started   bool
+	mu        sync.Mutex
+	framePool *FramePool
+	target    regionCropper
+
+	files     []string
+	next      int
+	prevFrame []byte
+}
+
+func NewReplayCapturer(dir string) *ReplayCapturer {
+	return &ReplayCapturer{
+		dir:       dir,
+		framePool: NewFramePool(),
+	}
+}
+
+func (c *ReplayCapturer) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.started {
+		return ErrAlreadyStarted
+	}
+
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.png"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("capture: no PNG files in %s", c.dir)
+	}
+	sort.Strings(files)
+
+	c.files = files
+	c.next = 0
+	c.prevFrame = nil
+	c.started = true
+	return nil
+}
+
+func (c *ReplayCapturer) ReadFrame() (*FrameWithDirty, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started {
+		return nil, ErrNotStarted
+	}
+
+	path := c.files[c.next]
+	c.next = (c.next + 1) % len(c.files)
+
+	img, err := loadPNG(path)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	c.width, c.height = bounds.Dx(), bounds.Dy()
+
+	frame := c.framePool.Get(c.width, c.height)
+	copy(frame.Pix, img.Pix)
+
+	result := &FrameWithDirty{Frame: frame, IsKeyFrame: true}
+	if len(c.prevFrame) == len(frame.Pix) {
+		rects, scripted, err := loadRects(strings.TrimSuffix(path, ".png") + ".rects")
+		if err != nil {
+			c.framePool.Put(frame)
+			return nil, err
+		}
+		if !scripted {
+			rects = damage.Diff(frame.Pix, c.prevFrame, c.width, c.height, frame.Stride, damage.DefaultBlockSize)
+		}
+		if len(rects) > 0 {
+			result.DirtyRects = rects
+			result.IsKeyFrame = false
+		} else if !scripted {
+			// Nothing changed since the previous screenshot
+			c.framePool.Put(frame)
+			return nil, nil
+		}
+	}
+
+	if len(c.prevFrame) != len(frame.Pix) {
+		c.prevFrame = make([]byte, len(frame.Pix))
+	}
+	copy(c.prevFrame, frame.Pix)
+
+	return c.target.crop(result, c.framePool), nil
+}
+
+// loadPNG decodes a screenshot into tightly packed RGBA.
+func loadPNG(path string) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("capture: %s: %w", path, err)
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba, nil
+}
+
+// loadRects reads a .rects sidecar. scripted is false if there is none.
+func loadRects(path string) (rects []DirtyRect, scripted bool, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		r, err := parseRect(text)
+		if err != nil {
+			return nil, false, fmt.Errorf("capture: %s:%d: %w", path, line, err)
+		}
+		rects = append(rects, r)
+	}
+	return rects, true, scanner.Err()
+}
+
+// parseRect parses an "x y w h" line.
+func parseRect(text string) (DirtyRect, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 4 {
+		return DirtyRect{}, fmt.Errorf("want \"x y w h\", got %q", text)
+	}
+	var n [4]int
+	for i, field := range fields {
+		v, err := strconv.Atoi(field)
+		if err != nil {
+			return DirtyRect{}, fmt.Errorf("want \"x y w h\", got %q", text)
+		}
+		n[i] = v
+	}
+	r := DirtyRect{X: n[0], Y: n[1], W: n[2], H: n[3]}
+	if r.W <= 0 || r.H <= 0 {
+		return DirtyRect{}, fmt.Errorf("empty rectangle %q", text)
+	}
+	return r, nil
+}
+
+func (c *ReplayCapturer) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.started = false
+	c.prevFrame = nil
+}
+
+// SetTarget supports the display and regions of it.
+func (c *ReplayCapturer) SetTarget(t Target) error {
+	return c.target.set(t)
+}
+
+func (c *ReplayCapturer) SupportsDirtyRects() bool {
+	return true
+}
+
+func (c *ReplayCapturer) Name() string {
+	return "Replay"
+}
@@ -0,0 +1,138 @@
+package capture
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadRects(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []DirtyRect
+		err    string // Part of the error, with the line number
+	}{
+		{"empty", "", nil, ""},
+		{
+			"comments and blank lines",
+			"# typing\n\n10 20 8 14\n  300 40 64 32  \n",
+			[]DirtyRect{{X: 10, Y: 20, W: 8, H: 14}, {X: 300, Y: 40, W: 64, H: 32}},
+			"",
+		},
+		{"too few fields", "10 20 8 14\n10 20 8\n", nil, ":2:"},
+		{"too many fields", "10 20 8 14 5\n", nil, ":1:"},
+		{"not a number", "10 20 eight 14\n", nil, ":1:"},
+		{"empty rectangle", "# ok\n10 20 0 14\n", nil, ":2:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "frame.rects")
+			if err := os.WriteFile(path, []byte(tt.script), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			rects, scripted, err := loadRects(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got %v, want an error at %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil || !scripted {
+				t.Fatalf("got %v, scripted %v", err, scripted)
+			}
+			if !reflect.DeepEqual(rects, tt.want) {
+				t.Errorf("got %v, want %v", rects, tt.want)
+			}
+		})
+	}
+
+	if _, scripted, err := loadRects(filepath.Join(t.TempDir(), "missing.rects")); scripted || err != nil {
+		t.Errorf("no sidecar: scripted %v, %v", scripted, err)
+	}
+}
+
+// writePNG writes a 100x60 screenshot of one colour with an optional
+// differing pixel at x, y.
+func writePNG(t *testing.T, path string, c color.RGBA, x, y int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, 255
+	}
+	if x >= 0 {
+		img.Set(x, y, color.RGBA{255, 255, 255, 255})
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayCapturer(t *testing.T) {
+	dir := t.TempDir()
+	gray := color.RGBA{80, 80, 80, 255}
+	writePNG(t, filepath.Join(dir, "01.png"), gray, -1, 0)
+	// Diffed: one pixel in the second, partial block column
+	writePNG(t, filepath.Join(dir, "02.png"), gray, 70, 50)
+	// Scripted
+	writePNG(t, filepath.Join(dir, "03.png"), gray, 5, 5)
+	os.WriteFile(filepath.Join(dir, "03.rects"), []byte("0 0 16 16\n4 4 2 2\n"), 0o600)
+	// An empty script makes a keyframe
+	writePNG(t, filepath.Join(dir, "04.png"), gray, 5, 5)
+	os.WriteFile(filepath.Join(dir, "04.rects"), nil, 0o600)
+	// Unchanged and unscripted: no frame
+	writePNG(t, filepath.Join(dir, "05.png"), gray, 5, 5)
+
+	c := NewReplayCapturer(dir)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	want := []*FrameWithDirty{
+		{IsKeyFrame: true},
+		{DirtyRects: []DirtyRect{{X: 64, Y: 0, W: 36, H: 60}}},
+		{DirtyRects: []DirtyRect{{X: 0, Y: 0, W: 16, H: 16}, {X: 4, Y: 4, W: 2, H: 2}}},
+		{IsKeyFrame: true},
+		nil,
+	}
+	for i, w := range want {
+		got, err := c.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if w == nil {
+			if got != nil {
+				t.Errorf("frame %d: got %+v, want none", i, got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Fatalf("frame %d: no frame", i)
+		}
+		if got.IsKeyFrame != w.IsKeyFrame || !reflect.DeepEqual(got.DirtyRects, w.DirtyRects) {
+			t.Errorf("frame %d: keyframe %v, rects %v; want %v, %v", i, got.IsKeyFrame, got.DirtyRects, w.IsKeyFrame, w.DirtyRects)
+		}
+		if got.Frame.W != 100 || got.Frame.H != 60 {
+			t.Errorf("frame %d: %dx%d, want 100x60", i, got.Frame.W, got.Frame.H)
+		}
+	}
+
+	// A broken script is reported
+	os.WriteFile(filepath.Join(dir, "02.rects"), []byte("1 2 3\n"), 0o600)
+	c.ReadFrame() // 01.png again, diffed against 05.png
+	if _, err := c.ReadFrame(); err == nil || !strings.Contains(err.Error(), "02.rects:1") {
+		t.Errorf("broken script: got %v", err)
+	}
+}
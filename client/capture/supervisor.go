@@ -0,0 +1,352 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Supervisor tuning.
+const (
+	SUPERVISOR_MAX_RETRIES  = 5                // Failed frames in a row before the backend is restarted
+	SUPERVISOR_MAX_RESTARTS = 3                // Restarts before falling back to the next backend
+	SUPERVISOR_RETRY_DELAY  = time.Second      // Wait after a failed restart, doubled each time
+	SUPERVISOR_RESCAN_DELAY = 30 * time.Second // Wait before starting over when every backend failed, and between probes
+)
+
+// Supervisor states.
+const (
+	StateRunning  = "running"  // Frames are being captured
+	StateRetrying = "retrying" // The backend failed and is retried or restarted
+	StateFailed   = "failed"   // Every backend failed; the chain is tried again later
+)
+
+var ErrNoBackend = errors.New("capture: no backend could start")
+
+// Status describes what a Supervisor is capturing with.
+type Status struct {
+	Backend   string // Name of the backend in use or last tried
+	State     string
+	LastError string // Most recent failure, empty once frames flow again
+	Failures  int    // Failures since the supervisor started
+}
+
+// Failure classes, from mildest to worst.
+const (
+	failRetry    = iota // Read the next frame as usual
+	failRestart         // Restart the backend
+	failFallback        // The backend can't work here; use the next one
+)
+
+// classify decides how to react to a backend error.
+func classify(err error) int {
+	switch {
+	case errors.Is(err, ErrNoDisplay), errors.Is(err, ErrNotSupported), errors.Is(err, ErrPermissionDenied):
+		return failFallback
+	case errors.Is(err, ErrNotStarted):
+		// The backend stopped itself, e.g. after losing its device
+		return failRestart
+	}
+	return failRetry
+}
+
+// Supervisor is a Capturer that runs the first working backend of a
+// fallback chain. Transient errors are retried, a backend that keeps
+// failing is restarted with a growing delay, and one that can't work at
+// all, or fails every restart, is replaced by the next in the chain. When
+// the whole chain failed it starts over after SUPERVISOR_RESCAN_DELAY.
+// While a fallback runs, the backends preferred to it are probed every
+// SUPERVISOR_RESCAN_DELAY and the first that starts takes over.
+//
+// ReadFrame never returns backend errors: while no backend works it
+// returns no frames, and Status tells what is going on.
+type Supervisor struct {
+	mu       sync.Mutex
+	backends []func() Capturer
+	started  bool
+
+	active   Capturer // nil while no backend runs
+	index    int      // Backend in use or being restarted, -1 before the first
+	retries  int      // Failed frames in a row
+	restarts int      // Restarts of the current backend
+	retryAt  time.Time
+	probeAt  time.Time // Next probe of the backends before index, zero on the first
+	target   Target
+	status   Status
+
+	now func() time.Time // The clock, replaced in tests
+}
+
+// NewSupervisor creates a supervisor over backends, in order of preference.
+func NewSupervisor(backends ...func() Capturer) *Supervisor {
+	return &Supervisor{backends: backends, index: -1, now: time.Now}
+}
+
+// Start starts the first backend that works. If none does, it returns an
+// error wrapping ErrNoBackend, but the supervisor counts as started and
+// keeps trying the chain from ReadFrame.
+func (s *Supervisor) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return ErrAlreadyStarted
+	}
+	s.started = true
+	s.index = -1
+	s.probeAt = time.Time{}
+
+	var err error
+	for s.active == nil && s.index < len(s.backends)-1 {
+		s.index++
+		s.restarts = 0
+		err = s.startBackend()
+	}
+	if s.active == nil {
+		s.giveUp()
+		return fmt.Errorf("%w: %v", ErrNoBackend, err)
+	}
+	return nil
+}
+
+func (s *Supervisor) ReadFrame() (*FrameWithDirty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		return nil, ErrNotStarted
+	}
+
+	if s.active == nil {
+		if s.now().Before(s.retryAt) {
+			return nil, nil
+		}
+		s.recover()
+		if s.active == nil {
+			return nil, nil
+		}
+	} else if s.index > 0 && !s.now().Before(s.probeAt) {
+		s.probe()
+	}
+
+	frame, err := s.active.ReadFrame()
+	if err != nil {
+		s.fail(err)
+		return nil, nil
+	}
+	if s.retries > 0 || s.status.State != StateRunning {
+		s.retries = 0
+		s.restarts = 0
+		s.status.State = StateRunning
+		s.status.LastError = ""
+	}
+	return frame, nil
+}
+
+// startBackend creates and starts the backend at s.index. Must be called
+// with s.mu held.
+func (s *Supervisor) startBackend() error {
+	c := s.backends[s.index]()
+	s.status.Backend = c.Name()
+
+	if err := c.SetTarget(s.target); err != nil {
+		// The backend can't capture the target; it captures the display
+		// instead, which Target reports
+		s.target = Target{}
+		c.SetTarget(s.target)
+	}
+	if err := c.Start(); err != nil {
+		s.record(err)
+		if classify(err) == failFallback {
+			s.restarts = SUPERVISOR_MAX_RESTARTS
+		}
+		return err
+	}
+
+	s.active = c
+	s.retries = 0
+	if s.index == 0 {
+		s.probeAt = time.Time{}
+	} else if s.probeAt.IsZero() {
+		s.probeAt = s.now().Add(SUPERVISOR_RESCAN_DELAY)
+	}
+	if s.status.State == "" {
+		s.status.State = StateRunning
+	}
+	// Otherwise the state says retrying until the first frame arrives
+	return nil
+}
+
+// fail handles an error from the running backend. Must be called with
+// s.mu held.
+func (s *Supervisor) fail(err error) {
+	s.record(err)
+
+	class := classify(err)
+	if class == failRetry {
+		s.retries++
+		if s.retries < SUPERVISOR_MAX_RETRIES {
+			return
+		}
+	}
+	if class == failFallback {
+		s.restarts = SUPERVISOR_MAX_RESTARTS
+	}
+
+	s.active.Stop()
+	s.active = nil
+	s.retries = 0
+	s.retryAt = time.Time{}
+}
+
+// recover restarts the current backend or falls back to the next one.
+// Must be called with s.mu held.
+func (s *Supervisor) recover() {
+	if s.index >= 0 && s.restarts < SUPERVISOR_MAX_RESTARTS {
+		s.restarts++
+		if s.startBackend() != nil {
+			s.retryAt = s.now().Add(SUPERVISOR_RETRY_DELAY << (s.restarts - 1))
+		}
+		return
+	}
+
+	for s.active == nil && s.index < len(s.backends)-1 {
+		s.index++
+		s.restarts = 0
+		s.startBackend()
+	}
+	if s.active == nil {
+		s.giveUp()
+	}
+}
+
+// probe starts the backends preferred to the running one, in order, and
+// switches to the first that starts. Backends that can't capture the
+// target are skipped, as the running one can. Failed probes are not
+// recorded in the status: the running backend still works. Must be called
+// with s.mu held.
+func (s *Supervisor) probe() {
+	s.probeAt = s.now().Add(SUPERVISOR_RESCAN_DELAY)
+
+	for i := 0; i < s.index; i++ {
+		c := s.backends[i]()
+		if c.SetTarget(s.target) != nil {
+			continue
+		}
+		if c.Start() != nil {
+			continue
+		}
+
+		s.active.Stop()
+		s.active = c
+		s.index = i
+		s.retries = 0
+		s.restarts = 0
+		s.status.Backend = c.Name()
+		if i == 0 {
+			s.probeAt = time.Time{}
+		}
+		return
+	}
+}
+
+// giveUp waits SUPERVISOR_RESCAN_DELAY before the chain is tried again.
+// Must be called with s.mu held.
+func (s *Supervisor) giveUp() {
+	s.status.State = StateFailed
+	s.index = -1
+	s.restarts = 0
+	s.retryAt = s.now().Add(SUPERVISOR_RESCAN_DELAY)
+	s.probeAt = time.Time{}
+}
+
+// record notes a failure in the status. Must be called with s.mu held.
+func (s *Supervisor) record(err error) {
+	s.status.State = StateRetrying
+	s.status.LastError = err.Error()
+	s.status.Failures++
+}
+
+// Status returns what the supervisor is capturing with.
+func (s *Supervisor) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Cursor forwards to the backend if it captures the pointer.
+func (s *Supervisor) Cursor() (Cursor, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cc, ok := s.active.(CursorCapturer); ok {
+		return cc.Cursor()
+	}
+	return Cursor{}, false
+}
+
+// Damaged forwards to the backend if it reports damage. It returns nil
+// while no backend runs, so callers poll until one does.
+func (s *Supervisor) Damaged() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n, ok := s.active.(DamageNotifier); ok {
+		return n.Damaged()
+	}
+	return nil
+}
+
+// SetTarget sets the target on the running backend and on every backend
+// started later. Backends later in the chain that can't capture it
+// capture the display instead.
+func (s *Supervisor) SetTarget(t Target) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		if err := s.active.SetTarget(t); err != nil {
+			return err
+		}
+	}
+	s.target = t
+	return nil
+}
+
+// Target returns the target being captured. It falls back to the display
+// when a backend taking over can't capture the target that was set.
+func (s *Supervisor) Target() Target {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.target
+}
+
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active != nil {
+		s.active.Stop()
+		s.active = nil
+	}
+	s.started = false
+}
+
+func (s *Supervisor) SupportsDirtyRects() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.active != nil && s.active.SupportsDirtyRects()
+}
+
+// Name returns the name of the backend in use or last tried.
+func (s *Supervisor) Name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.Backend
+}
@@ -0,0 +1,271 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeBackend scripts the errors of a backend across the capturers the
+// supervisor creates from it.
+type fakeBackend struct {
+	name     string
+	starts   []error // Returned by successive Starts; nil once used up
+	frames   []error // Returned by successive ReadFrames; nil once used up
+	attempts int     // Calls to Start
+	running  int     // Capturers started and not stopped
+}
+
+func (b *fakeBackend) new() Capturer {
+	return &fakeCapturer{b: b}
+}
+
+// next pops the first scripted error.
+func next(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+type fakeCapturer struct {
+	b       *fakeBackend
+	running bool
+}
+
+func (c *fakeCapturer) Start() error {
+	c.b.attempts++
+	if err := next(&c.b.starts); err != nil {
+		return err
+	}
+	c.running = true
+	c.b.running++
+	return nil
+}
+
+func (c *fakeCapturer) ReadFrame() (*FrameWithDirty, error) {
+	if err := next(&c.b.frames); err != nil {
+		return nil, err
+	}
+	frame := &Frame{Pix: make([]byte, 4), W: 1, H: 1, Stride: 4}
+	return &FrameWithDirty{Frame: frame, IsKeyFrame: true}, nil
+}
+
+func (c *fakeCapturer) Stop() {
+	if c.running {
+		c.running = false
+		c.b.running--
+	}
+}
+
+func (c *fakeCapturer) SupportsDirtyRects() bool { return false }
+func (c *fakeCapturer) Name() string             { return c.b.name }
+func (c *fakeCapturer) SetTarget(Target) error   { return nil }
+
+func TestSupervisor(t *testing.T) {
+	errTimeout := errors.New("capture: timeout")
+	repeat := func(err error, n int) []error {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	// A step reads a frame at an offset from the start and checks the
+	// status afterwards.
+	type step struct {
+		at      time.Duration
+		frame   bool
+		backend string
+		state   string
+	}
+	tests := []struct {
+		name     string
+		backends []*fakeBackend
+		startErr bool
+		steps    []step
+		attempts []int // Calls to Start per backend
+	}{
+		{
+			name: "transient errors are retried",
+			backends: []*fakeBackend{
+				{name: "A", frames: repeat(errTimeout, SUPERVISOR_MAX_RETRIES-1)},
+			},
+			steps: []step{
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying},
+				{0, true, "A", StateRunning},
+			},
+			attempts: []int{1},
+		},
+		{
+			name: "too many transient errors restart",
+			backends: []*fakeBackend{
+				{name: "A", frames: repeat(errTimeout, SUPERVISOR_MAX_RETRIES)},
+			},
+			steps: []step{
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying},
+				{0, true, "A", StateRunning},
+			},
+			attempts: []int{2},
+		},
+		{
+			name: "stopped backend restarts with backoff",
+			backends: []*fakeBackend{
+				{name: "A", starts: []error{nil, ErrNotStarted, ErrNotStarted}, frames: []error{ErrNotStarted}},
+				{name: "B"},
+			},
+			steps: []step{
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying}, // First restart fails
+				{time.Second - 1, false, "A", StateRetrying},
+				{time.Second, false, "A", StateRetrying}, // Second restart fails
+				{3*time.Second - 1, false, "A", StateRetrying},
+				{3 * time.Second, true, "A", StateRunning},
+			},
+			attempts: []int{4, 0},
+		},
+		{
+			name: "restarts exhausted fall back",
+			backends: []*fakeBackend{
+				{name: "A", starts: []error{nil, ErrNotStarted, ErrNotStarted, ErrNotStarted}, frames: []error{ErrNotStarted}},
+				{name: "B"},
+			},
+			steps: []step{
+				{0, false, "A", StateRetrying},
+				{0, false, "A", StateRetrying},
+				{time.Second, false, "A", StateRetrying},
+				{3 * time.Second, false, "A", StateRetrying},
+				{7*time.Second - 1, false, "A", StateRetrying},
+				{7 * time.Second, true, "B", StateRunning},
+			},
+			attempts: []int{1 + SUPERVISOR_MAX_RESTARTS, 1},
+		},
+		{
+			name: "no display falls back at start",
+			backends: []*fakeBackend{
+				{name: "A", starts: []error{fmt.Errorf("%w: DISPLAY unset", ErrNoDisplay)}},
+				{name: "B"},
+			},
+			steps:    []step{{0, true, "B", StateRunning}},
+			attempts: []int{1, 1},
+		},
+		{
+			name: "unsupported falls back at start",
+			backends: []*fakeBackend{
+				{name: "A", starts: []error{fmt.Errorf("%w: no XShm", ErrNotSupported)}},
+				{name: "B"},
+			},
+			steps:    []step{{0, true, "B", StateRunning}},
+			attempts: []int{1, 1},
+		},
+		{
+			name: "permission denied falls back while running",
+			backends: []*fakeBackend{
+				{name: "A", frames: []error{fmt.Errorf("%w: portal", ErrPermissionDenied)}},
+				{name: "B"},
+			},
+			steps: []step{
+				{0, false, "A", StateRetrying},
+				{0, true, "B", StateRunning},
+			},
+			attempts: []int{1, 1},
+		},
+		{
+			name: "failed chain is rescanned",
+			backends: []*fakeBackend{
+				{name: "A", starts: []error{ErrNoDisplay}},
+				{name: "B", starts: []error{ErrNoDisplay}},
+			},
+			startErr: true,
+			steps: []step{
+				{0, false, "B", StateFailed},
+				{SUPERVISOR_RESCAN_DELAY - 1, false, "B", StateFailed},
+				{SUPERVISOR_RESCAN_DELAY, true, "A", StateRunning},
+			},
+			attempts: []int{2, 1},
+		},
+		{
+			name: "preferred backend is probed while falling back",
+			backends: []*fakeBackend{
+				{name: "A", starts: []error{ErrNoDisplay, ErrNoDisplay}},
+				{name: "B", starts: []error{ErrNoDisplay}},
+				{name: "C"},
+			},
+			steps: []step{
+				{0, true, "C", StateRunning},
+				{SUPERVISOR_RESCAN_DELAY - 1, true, "C", StateRunning},
+				// A still fails, B takes over; a failed probe is no failure
+				{SUPERVISOR_RESCAN_DELAY, true, "B", StateRunning},
+				{2*SUPERVISOR_RESCAN_DELAY - 1, true, "B", StateRunning},
+				{2 * SUPERVISOR_RESCAN_DELAY, true, "A", StateRunning},
+				{10 * SUPERVISOR_RESCAN_DELAY, true, "A", StateRunning},
+			},
+			attempts: []int{3, 2, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backends []func() Capturer
+			for _, b := range tt.backends {
+				backends = append(backends, b.new)
+			}
+			s := NewSupervisor(backends...)
+			start := time.Now()
+			var now time.Time
+			s.now = func() time.Time { return now }
+			now = start
+
+			if err := s.Start(); (err != nil) != tt.startErr {
+				t.Fatalf("Start: %v", err)
+			} else if err != nil && !errors.Is(err, ErrNoBackend) {
+				t.Fatalf("Start: %v, want ErrNoBackend", err)
+			}
+
+			for i, st := range tt.steps {
+				now = start.Add(st.at)
+				frame, err := s.ReadFrame()
+				if err != nil {
+					t.Fatalf("step %d: ReadFrame: %v", i, err)
+				}
+				status := s.Status()
+				if (frame != nil) != st.frame || status.Backend != st.backend || status.State != st.state {
+					t.Fatalf("step %d: frame %v, %s %s (%s), want frame %v, %s %s",
+						i, frame != nil, status.Backend, status.State, status.LastError, st.frame, st.backend, st.state)
+				}
+			}
+
+			var attempts []int
+			running := 0
+			for _, b := range tt.backends {
+				attempts = append(attempts, b.attempts)
+				running += b.running
+			}
+			if !reflect.DeepEqual(attempts, tt.attempts) {
+				t.Errorf("Start calls %v, want %v", attempts, tt.attempts)
+			}
+			if running != 1 {
+				t.Errorf("%d backends running, want 1", running)
+			}
+
+			s.Stop()
+			for _, b := range tt.backends {
+				if b.running != 0 {
+					t.Errorf("backend %s still running after Stop", b.name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package capture
+
+import (
+	"math"
+	"sync"
+)
+
+// Synthetic screen layout.
+const (
+	SYNTHETIC_WIDTH  = 1280
+	SYNTHETIC_HEIGHT = 720
+
+	synthWindowW = 320
+	synthWindowH = 200
+	synthWindowV = 12 // Pixels the window moves per frame
+	synthCellW   = 8  // Typed character cell
+	synthCellH   = 14
+	synthVideoW  = 240
+	synthVideoH  = 135
+
+	SYNTHETIC_PHASE = 60 // Frames the window moves, then stands still for typing
+)
+
+// SyntheticCapturer generates deterministic screens without a display: a
+// window moving across a gradient desktop, text being typed one character
+// per frame and a video region that changes every frame. It reports exact
+// dirty rectangles, so the whole streaming pipeline can run on headless
+// machines. The same frame number always yields the same pixels.
+type SyntheticCapturer struct {
+	width     int
+	height    int
+	started   bool
+	mu        sync.Mutex
+	framePool *FramePool
+	target    regionCropper
+
+	screen []byte
+	frame  int
+	window DirtyRect // Current position of the moving window
+	dx, dy int
+	typed  int // Characters typed into the window
+}
+
+// NewSyntheticCapturer creates a synthetic capturer with a w×h screen. Zero
+// sizes use SYNTHETIC_WIDTH×SYNTHETIC_HEIGHT.
+func NewSyntheticCapturer(w, h int) *SyntheticCapturer {
+	if w <= 0 || h <= 0 {
+		w, h = SYNTHETIC_WIDTH, SYNTHETIC_HEIGHT
+	}
+	return &SyntheticCapturer{
+		width:     w,
+		height:    h,
+		framePool: NewFramePool(),
+	}
+}
+
+func (c *SyntheticCapturer) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.started {
+		return ErrAlreadyStarted
+	}
+
+	c.screen = make([]byte, c.width*c.height*4)
+	c.frame = 0
+	c.window = DirtyRect{X: 0, Y: 0, W: min(synthWindowW, c.width), H: min(synthWindowH, c.height)}
+	c.dx, c.dy = synthWindowV, synthWindowV/2
+	c.typed = 0
+
+	c.drawDesktop(DirtyRect{W: c.width, H: c.height})
+	if video := c.videoRect(); !video.Empty() {
+		c.drawVideo(video)
+	}
+	c.drawWindow()
+	c.started = true
+	return nil
+}
+
+func (c *SyntheticCapturer) ReadFrame() (*FrameWithDirty, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started {
+		return nil, ErrNotStarted
+	}
+
+	result := &FrameWithDirty{IsKeyFrame: c.frame == 0}
+	if c.frame > 0 {
+		result.DirtyRects = c.step()
+	}
+	c.frame++
+
+	frame := c.framePool.Get(c.width, c.height)
+	copy(frame.Pix, c.screen)
+	result.Frame = frame
+	return c.target.crop(result, c.framePool), nil
+}
+
+// step advances the scene by one frame and returns what changed. The
+// window alternates between moving and standing still while text is typed
+// into it, so both large and single-character damage occur.
+func (c *SyntheticCapturer) step() []DirtyRect {
+	var dirty []DirtyRect
+
+	if (c.frame/SYNTHETIC_PHASE)%2 == 0 {
+		old := c.window
+		c.drawDesktop(old)
+		c.moveWindow()
+		c.drawWindow()
+		dirty = append(dirty, old, c.window)
+	} else if cell, ok := c.typeChar(); ok {
+		dirty = append(dirty, cell)
+	} else {
+		// The window filled up and was cleared
+		c.drawWindow()
+		dirty = append(dirty, c.window)
+	}
+
+	if video := c.videoRect(); !video.Empty() {
+		c.drawVideo(video)
+		dirty = append(dirty, video)
+	}
+	return dirty
+}
+
+// moveWindow moves the window one step, bouncing off the screen edges.
+func (c *SyntheticCapturer) moveWindow() {
+	next := c.window
+	if next.X+c.dx < 0 || next.X+next.W+c.dx > c.width {
+		c.dx = -c.dx
+	}
+	if next.Y+c.dy < 0 || next.Y+next.H+c.dy > c.height {
+		c.dy = -c.dy
+	}
+	next.X = max(0, min(next.X+c.dx, c.width-next.W))
+	next.Y = max(0, min(next.Y+c.dy, c.height-next.H))
+	c.window = next
+}
+
+// typeChar types the next character and returns its cell, or false when
+// the window is full and the text starts over.
+func (c *SyntheticCapturer) typeChar() (DirtyRect, bool) {
+	perLine, lines := c.textSize()
+	if perLine <= 0 || lines <= 0 {
+		return DirtyRect{}, false
+	}
+	c.typed++
+	if c.typed > perLine*lines {
+		c.typed = 0
+		return DirtyRect{}, false
+	}
+	cell := c.cell(c.typed - 1)
+	c.drawGlyph(cell, uint32(c.typed-1))
+	return cell, true
+}
+
+// drawDesktop paints the background gradient inside r.
+func (c *SyntheticCapturer) drawDesktop(r DirtyRect) {
+	for y := r.Y; y < r.Y+r.H; y++ {
+		for x := r.X; x < r.X+r.W; x++ {
+			c.set(x, y, byte(x*255/c.width), byte(y*255/c.height), 160)
+		}
+	}
+}
+
+// drawWindow paints the window with a title bar and the typed text.
+func (c *SyntheticCapturer) drawWindow() {
+	w := c.window
+	for y := w.Y; y < w.Y+w.H; y++ {
+		for x := w.X; x < w.X+w.W; x++ {
+			if y-w.Y < 20 {
+				c.set(x, y, 40, 60, 120)
+			} else {
+				c.set(x, y, 250, 250, 250)
+			}
+		}
+	}
+
+	for i := 0; i < c.typed; i++ {
+		c.drawGlyph(c.cell(i), uint32(i))
+	}
+}
+
+// textSize returns how many characters fit on a line and how many lines fit.
+func (c *SyntheticCapturer) textSize() (perLine, lines int) {
+	return (c.window.W - 8) / synthCellW, (c.window.H - 28) / synthCellH
+}
+
+// cell returns the screen rectangle of the i-th typed character.
+func (c *SyntheticCapturer) cell(i int) DirtyRect {
+	perLine, _ := c.textSize()
+	return DirtyRect{
+		X: c.window.X + 4 + (i%perLine)*synthCellW,
+		Y: c.window.Y + 24 + (i/perLine)*synthCellH,
+		W: synthCellW,
+		H: synthCellH,
+	}
+}
+
+// drawGlyph draws a pseudo-character into cell: a fixed bit pattern picked
+// by n.
+func (c *SyntheticCapturer) drawGlyph(cell DirtyRect, n uint32) {
+	bits := n*2654435761 + 0x9e3779b9
+	for y := 1; y < cell.H-3; y++ {
+		for x := 1; x < cell.W-2; x++ {
+			if bits&(1<<((x+y*5)%32)) != 0 {
+				c.set(cell.X+x, cell.Y+y, 20, 20, 20)
+			}
+		}
+	}
+}
+
+// videoRect is the fixed region in the bottom right that plays "video".
+func (c *SyntheticCapturer) videoRect() DirtyRect {
+	r := DirtyRect{
+		X: c.width - synthVideoW - 16,
+		Y: c.height - synthVideoH - 16,
+		W: synthVideoW,
+		H: synthVideoH,
+	}
+	return r.Clip(c.width, c.height)
+}
+
+// drawVideo fills r with a pattern that differs every frame, skipping the
+// part covered by the window.
+func (c *SyntheticCapturer) drawVideo(r DirtyRect) {
+	w := c.window
+	seed := uint32(c.frame) * 747796405
+	for y := r.Y; y < r.Y+r.H; y++ {
+		for x := r.X; x < r.X+r.W; x++ {
+			if x >= w.X && x < w.X+w.W && y >= w.Y && y < w.Y+w.H {
+				continue
+			}
+			v := seed + uint32((x/8)*31+(y/8)*17)*2891336453
+			c.set(x, y, byte(v>>24), byte(v>>16), byte(v>>8))
+		}
+	}
+}
+
+func (c *SyntheticCapturer) set(x, y int, r, g, b byte) {
+	i := (y*c.width + x) * 4
+	c.screen[i+0] = r
+	c.screen[i+1] = g
+	c.screen[i+2] = b
+	c.screen[i+3] = 255
+}
+
+// Cursor circles the middle of the screen. Its shape is left to the viewer.
+func (c *SyntheticCapturer) Cursor() (Cursor, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started {
+		return Cursor{}, false
+	}
+	angle := float64(c.frame) * 2 * math.Pi / SYNTHETIC_PHASE
+	radius := float64(min(c.width, c.height)) / 4
+	cursor := Cursor{
+		X:       c.width/2 + int(radius*math.Cos(angle)),
+		Y:       c.height/2 + int(radius*math.Sin(angle)),
+		Visible: true,
+	}
+	return c.target.cursor(cursor, c.width, c.height), true
+}
+
+func (c *SyntheticCapturer) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.started = false
+	c.screen = nil
+}
+
+// SetTarget supports the display and regions of it.
+func (c *SyntheticCapturer) SetTarget(t Target) error {
+	return c.target.set(t)
+}
+
+func (c *SyntheticCapturer) SupportsDirtyRects() bool {
+	return true
+}
+
+func (c *SyntheticCapturer) Name() string {
+	return "Synthetic"
+}
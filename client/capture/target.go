@@ -0,0 +1,185 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// TargetKind selects what a capturer captures.
+type TargetKind int
+
+const (
+	TargetDisplay TargetKind = iota // The whole display
+	TargetWindow                    // One application window
+	TargetRegion                    // A fixed rectangle of the display
+)
+
+// Target is what a capturer captures. The zero value is the whole display.
+type Target struct {
+	Kind TargetKind
+	// Title and Class pick a TargetWindow. Title matches part of the window
+	// title and Class the whole window class or instance name, both
+	// ignoring case. A window must match every one given.
+	Title string
+	Class string
+	// Region is the TargetRegion rectangle in display pixels.
+	Region DirtyRect
+}
+
+var ErrTargetNotFound = errors.New("capture: no window matches the target")
+
+func (t Target) String() string {
+	switch t.Kind {
+	case TargetWindow:
+		return fmt.Sprintf("window title=%q class=%q", t.Title, t.Class)
+	case TargetRegion:
+		r := t.Region
+		return fmt.Sprintf("region %dx%d+%d+%d", r.W, r.H, r.X, r.Y)
+	}
+	return "display"
+}
+
+// validate checks that t names something to capture.
+func (t Target) validate() error {
+	switch t.Kind {
+	case TargetDisplay:
+		return nil
+	case TargetWindow:
+		if t.Title == "" && t.Class == "" {
+			return errors.New("capture: window target needs a title or class")
+		}
+		return nil
+	case TargetRegion:
+		if t.Region.Empty() {
+			return errors.New("capture: empty region")
+		}
+		return nil
+	}
+	return fmt.Errorf("capture: unknown target kind %d", t.Kind)
+}
+
+// matchWindow reports whether a window with the given title, class and
+// instance name is picked by t.
+func (t Target) matchWindow(title, class, instance string) bool {
+	if t.Title != "" && !strings.Contains(strings.ToLower(title), strings.ToLower(t.Title)) {
+		return false
+	}
+	if t.Class != "" && !strings.EqualFold(class, t.Class) && !strings.EqualFold(instance, t.Class) {
+		return false
+	}
+	return true
+}
+
+// regionCropper gives backends that capture the whole display the display
+// and region targets, by cropping their frames. Backends call crop on
+// every frame and cursor on every pointer they return.
+type regionCropper struct {
+	mu      sync.Mutex
+	target  Target
+	changed bool // The next frame starts the new target and is a keyframe
+}
+
+// set switches to a display or region target. Window targets need the
+// backend's own support.
+func (r *regionCropper) set(t Target) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if t.Kind == TargetWindow {
+		return fmt.Errorf("%w: window capture", ErrNotSupported)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t != r.target {
+		r.target = t
+		r.changed = true
+	}
+	return nil
+}
+
+// region returns the rectangle frames are cropped to in a w×h display,
+// and false if they are sent whole. A region entirely off the display
+// sends the whole display rather than nothing.
+func (r *regionCropper) region(w, h int) (DirtyRect, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.target.crop(w, h)
+}
+
+// crop returns the rectangle of a w×h display that t captures, and false
+// if that is the whole display.
+func (t Target) crop(w, h int) (DirtyRect, bool) {
+	if t.Kind != TargetRegion {
+		return DirtyRect{}, false
+	}
+	region := t.Region.Clip(w, h)
+	if region.Empty() || region == (DirtyRect{W: w, H: h}) {
+		return DirtyRect{}, false
+	}
+	return region, true
+}
+
+// crop cuts result down to the region target, returning the source frame
+// to pool. It returns nil if nothing inside the region changed.
+func (r *regionCropper) crop(result *FrameWithDirty, pool *FramePool) *FrameWithDirty {
+	if result == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	target, changed := r.target, r.changed
+	r.changed = false
+	r.mu.Unlock()
+
+	if changed {
+		result.IsKeyFrame = true
+		result.DirtyRects = nil
+	}
+
+	src := result.Frame
+	region, ok := target.crop(src.W, src.H)
+	if !ok {
+		return result
+	}
+
+	frame := pool.Get(region.W, region.H)
+	for y := 0; y < region.H; y++ {
+		row := (region.Y+y)*src.Stride + region.X*4
+		copy(frame.Pix[y*frame.Stride:(y+1)*frame.Stride], src.Pix[row:row+region.W*4])
+	}
+	pool.Put(src)
+
+	cropped := &FrameWithDirty{Frame: frame, IsKeyFrame: result.IsKeyFrame}
+	if cropped.IsKeyFrame || len(result.DirtyRects) == 0 {
+		return cropped
+	}
+	for _, d := range result.DirtyRects {
+		d.X -= region.X
+		d.Y -= region.Y
+		if d = d.Clip(region.W, region.H); !d.Empty() {
+			cropped.DirtyRects = append(cropped.DirtyRects, d)
+		}
+	}
+	if len(cropped.DirtyRects) == 0 {
+		pool.Put(frame)
+		return nil
+	}
+	return cropped
+}
+
+// cursor moves a pointer on a w×h display into region coordinates,
+// hiding it outside the region.
+func (r *regionCropper) cursor(cur Cursor, w, h int) Cursor {
+	region, ok := r.region(w, h)
+	if !ok {
+		return cur
+	}
+	cur.X -= region.X
+	cur.Y -= region.Y
+	if cur.X < 0 || cur.Y < 0 || cur.X >= region.W || cur.Y >= region.H {
+		cur.Visible = false
+	}
+	return cur
+}
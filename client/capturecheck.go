@@ -20,7 +20,6 @@ func main() {
 
 	// Create platform capturer
 	cap := capture.NewPlatformCapturer()
-	fmt.Printf("Capturer type: %T\n", cap)
 
 	// Start capture
 	if err := cap.Start(); err != nil {
@@ -28,6 +27,7 @@ func main() {
 		os.Exit(1)
 	}
 	defer cap.Stop()
+	fmt.Printf("Capturer: %s\n", cap.Name())
 
 	fmt.Println("Capture started, reading frame...")
 
@@ -39,7 +39,7 @@ func main() {
 	}
 
 	if frameData == nil || frameData.Frame == nil {
-		fmt.Println("No frame data returned!")
+		fmt.Printf("No frame data returned! Status: %+v\n", cap.Status())
 		os.Exit(1)
 	}
 
@@ -55,6 +55,9 @@ func main() {
 		}
 	}
 	fmt.Printf("Non-zero bytes in first 10000: %d\n", nonZeroCount)
+	if c, ok := capture.UniformColor(frame); ok {
+		fmt.Printf("Warning: frame is a single colour #%02x%02x%02x\n", c.R, c.G, c.B)
+	}
 
 	// Create image and save to PNG
 	img := &image.RGBA{
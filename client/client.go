@@ -1,22 +1,20 @@
 package main
 
 import (
-	"encoding/binary"
 	"net"
 	"sync/atomic"
 	"time"
 
+	"github.com/exam-gaurd/client/activity"
 	"github.com/exam-gaurd/client/capture"
+	"github.com/exam-gaurd/client/damage"
 	"github.com/exam-gaurd/client/encoder"
+	"github.com/exam-gaurd/protocol"
 )
 
-// Protocol constants
 const (
-	UPDATE_INTERVAL = time.Second / 6 // 6 FPS for better performance
-	NAME            = 0
-	MESSAGE         = 1
-	PICTURE         = 2
-	HEADER_SIZE     = 8
+	UPDATE_INTERVAL    = time.Second / 6 // 6 FPS for better performance
+	HEARTBEAT_INTERVAL = 5 * time.Second // Longest time without a frame when the backend reports damage
 )
 
 const (
@@ -36,9 +34,20 @@ type Client struct {
 	cachedServerIP string
 
 	// New capture system
-	capturer capture.Capturer
+	capturer *capture.Supervisor
 	enc      *encoder.Encoder
 
+	// Exam policy pushed by the server, nil until received
+	policy atomic.Pointer[activity.Policy]
+	// Set to make the next encoded frame a keyframe
+	forceKeyFrame atomic.Bool
+	// Capture target requested by the server, applied by the streaming loop
+	pendingTarget atomic.Pointer[protocol.CaptureTarget]
+	// Capture target in use, owned by the streaming loop
+	target capture.Target
+	// Protocol version negotiated with the server
+	protocolVersion atomic.Int32
+
 	// Statistics
 	framesSent    atomic.Int64
 	framesDropped atomic.Int64
@@ -123,7 +132,8 @@ func (client *Client) Start(studentId, studentName string, port int, updateUI fu
 			updateUI()
 			retryDelay = 1 * time.Second
 
-			client.SendStudentName(studentId + "###" + studentName)
+			client.join(studentId, studentName)
+			go client.runReader(client.socket)
 
 			// Run the new streaming loop with compositor-based capture
 			client.runStreamingLoop(updateUI)
@@ -137,27 +147,59 @@ func (client *Client) Start(studentId, studentName string, port int, updateUI fu
 func (client *Client) runStreamingLoop(updateUI func()) {
 	// Initialize platform-specific capturer (auto-detected via build tags)
 	client.capturer = capture.NewPlatformCapturer()
+	client.target = capture.Target{}
 	if err := client.capturer.Start(); err != nil {
+		// Stay connected: the supervisor keeps trying the backends and
+		// the server sees why the screen is missing
 		if client.onError != nil {
 			client.onError(err)
 		}
-		client.isConnected.Store(false)
-		return
 	}
 	defer client.capturer.Stop()
 
+	// Complete the machine report now that the backend is known
+	client.SendReport(protocol.MsgMachine, collectMachineReport(client.capturer.Name()))
+
+	// Report foreground window and processes for as long as we stream
+	activityDone := make(chan struct{})
+	defer close(activityDone)
+	go client.runActivityLoop(activityDone)
+
 	// Create encoder with optimized settings
 	client.enc = encoder.NewEncoder(encoder.EncoderConfig{
-		Quality:  45, // Lower quality for bandwidth efficiency
-		MaxWidth: 720,
+		Quality:        45, // Lower quality for bandwidth efficiency
+		MaxWidth:       throttleLevels[0].maxWidth,
+		KeyFrameStrips: 4, // Encode keyframes in parallel
+		LosslessTiles:  true,
 	})
 
-	// Frame timing at 6 FPS
-	ticker := time.NewTicker(UPDATE_INTERVAL)
+	// Frame timing at 6 FPS, slower while the governor throttles
+	gov := client.startGovernor()
+	level := gov.current()
+	ticker := time.NewTicker(level.interval)
 	defer ticker.Stop()
 
 	frameCount := 0
 	keyFrameInterval := 30 // Force keyframe every 5 seconds at 6 FPS
+	damageOpts := damage.DefaultOptions()
+
+	// Pointer position, sent separately from frames when the backend has it
+	var cursor cursorReporter
+	screenW, screenH := 0, 0
+
+	// Backend health, sent whenever it changes
+	var status captureStatusReporter
+	// Frames of a single colour, reported once they last
+	var blank blankReporter
+
+	// Backends that report damage are only read after damage, at most
+	// every frame interval, and at least every HEARTBEAT_INTERVAL; the
+	// others are polled every tick
+	damaged := false
+	var lastRead time.Time
+	// The last frame read, resent as a keyframe when a heartbeat or a
+	// keyframe request finds nothing new
+	var lastFrame *capture.Frame
 
 	// Send queue with frame dropping to prevent memory growth
 	sendQueue := make(chan []byte, 2)
@@ -170,7 +212,9 @@ func (client *Client) runStreamingLoop(updateUI func()) {
 			if !client.isConnected.Load() {
 				return
 			}
+			start := time.Now()
 			err := client.SendScreenshot(data)
+			gov.spend(time.Since(start))
 			if err != nil {
 				client.isConnected.Store(false)
 				return
@@ -186,28 +230,95 @@ func (client *Client) runStreamingLoop(updateUI func()) {
 	}()
 
 	for client.isConnected.Load() && client.isRunning.Load() {
-		// Wait for next frame interval
-		<-ticker.C
+		// Wait for the next frame interval, or damage
+		notifier := client.capturer.Damaged()
+		select {
+		case <-ticker.C:
+		case <-notifier:
+			damaged = true
+			if time.Since(lastRead) < level.interval {
+				continue // Read on a later tick
+			}
+		}
 
-		// Capture frame using compositor-based capture
-		frameData, err := client.capturer.ReadFrame()
-		if err != nil {
-			client.isConnected.Store(false)
-			return
+		if gov.update(time.Now()) {
+			next := gov.current()
+			ticker.Reset(next.interval)
+			if next.maxWidth != level.maxWidth {
+				client.enc.SetMaxWidth(next.maxWidth)
+				client.forceKeyFrame.Store(true)
+			}
+			level = next
+			client.SendReport(protocol.MsgThrottle, gov.report())
+		}
+
+		if target := client.pendingTarget.Swap(nil); target != nil {
+			client.applyCaptureTarget(*target)
+			// The new target needs a fresh frame
+			damaged = true
+			lastFrame = nil
+		}
+
+		sinceRead := time.Since(lastRead)
+		resend := (notifier != nil && sinceRead >= HEARTBEAT_INTERVAL) || client.forceKeyFrame.Load()
+		read := notifier == nil || resend || (damaged && sinceRead >= level.interval)
+
+		// Capture frame using compositor-based capture. The supervisor
+		// handles backend failures itself and returns no frame meanwhile
+		var frameData *capture.FrameWithDirty
+		if read {
+			start := time.Now()
+			frameData, _ = client.capturer.ReadFrame()
+			lastRead = time.Now()
+			gov.spend(lastRead.Sub(start))
+			damaged = false
+			if frameData == nil && resend && lastFrame != nil {
+				frameData = &capture.FrameWithDirty{Frame: lastFrame, IsKeyFrame: true}
+			}
+		}
+		status.update(client)
+
+		var frame *capture.Frame
+		if frameData != nil {
+			frame = frameData.Frame
+			lastFrame = frame
+			screenW, screenH = frame.W, frame.H
 		}
+		cursor.update(client, client.capturer, screenW, screenH)
+		blank.update(client, frame, time.Now())
 
 		if frameData == nil {
 			continue // No new frame available
 		}
 
-		// Force keyframe periodically for reliability
-		if frameCount%keyFrameInterval == 0 {
+		// Force keyframe periodically for reliability, unless throttled to
+		// changes only, or when requested
+		periodic := frameCount%keyFrameInterval == 0 && (frameCount == 0 || !level.dirtyOnly)
+		if periodic || client.forceKeyFrame.Swap(false) {
 			frameData.IsKeyFrame = true
 		}
 		frameCount++
 
-		// Encode frame (handles both keyframes and dirty rects)
+		// Merge raw damage into a few larger tiles, or send a keyframe
+		// when most of the screen changed anyway
+		start := time.Now()
+		if !frameData.IsKeyFrame && len(frameData.DirtyRects) > 0 {
+			rects, keyframe := damage.Process(frameData.DirtyRects, frameData.Frame.W, frameData.Frame.H, damageOpts)
+			if keyframe {
+				frameData.IsKeyFrame = true
+			} else if len(rects) == 0 {
+				gov.spend(time.Since(start))
+				continue // Damage was entirely off-screen
+			} else {
+				frameData.DirtyRects = rects
+			}
+		}
+
+		// Encode frame (handles both keyframes and dirty rects) with the
+		// frame types the server negotiated
+		client.enc.SetProtocolVersion(int(client.protocolVersion.Load()))
 		encoded, err := client.enc.Encode(frameData)
+		gov.spend(time.Since(start))
 		if err != nil || encoded == nil {
 			continue
 		}
@@ -216,6 +327,7 @@ func (client *Client) runStreamingLoop(updateUI func()) {
 		select {
 		case sendQueue <- encoded.Data:
 			// Successfully queued
+			gov.frame()
 		default:
 			// Queue full, drop frame to maintain responsiveness
 			client.framesDropped.Add(1)
@@ -251,23 +363,36 @@ func discoverServerWithTimeout(port int, timeout time.Duration) (string, error)
 	}
 }
 
-func (client *Client) SendStudentName(name string) error {
+// join identifies the student and their machine, and offers a protocol
+// version. The machine report goes out before capture starts so the server
+// has it even if no backend works.
+func (client *Client) join(id, name string) error {
+	if err := client.SendStudentName(id, name); err != nil {
+		return err
+	}
+	if err := client.SendReport(protocol.MsgMachine, collectMachineReport("")); err != nil {
+		return err
+	}
+	return client.sendHello()
+}
+
+func (client *Client) SendStudentName(id, name string) error {
 	if client.socket != nil {
-		return client.sendData(NAME, []byte(name))
+		return client.sendData(protocol.PacketName, protocol.JoinPayload(id, name))
 	}
 	return nil
 }
 
 func (client *Client) SendScreenshot(screenshot []byte) error {
 	if client.socket != nil {
-		return client.sendData(PICTURE, screenshot)
+		return client.sendData(protocol.PacketPicture, screenshot)
 	}
 	return nil
 }
 
 func (client *Client) SendMessage(msg string) error {
 	if client.socket != nil {
-		return client.sendData(MESSAGE, []byte(msg))
+		return client.sendData(protocol.PacketMessage, []byte(msg))
 	}
 	return nil
 }
@@ -280,13 +405,9 @@ func (client *Client) Stop() {
 	}
 }
 
-func (client *Client) sendData(dataType uint16, dataBytes []byte) error {
-	data := make([]byte, HEADER_SIZE+len(dataBytes))
-	copy(data, client.packHeader(dataType, len(dataBytes)))
-	copy(data[HEADER_SIZE:], dataBytes)
-
+func (client *Client) sendData(dataType protocol.PacketType, dataBytes []byte) error {
 	client.socket.SetWriteDeadline(time.Now().Add(5 * time.Second))
-	_, err := client.socket.Write(data)
+	err := protocol.WritePacket(client.socket, dataType, dataBytes)
 
 	if err != nil {
 		client.isConnected.Store(false)
@@ -295,16 +416,6 @@ func (client *Client) sendData(dataType uint16, dataBytes []byte) error {
 	return nil
 }
 
-func (client *Client) packHeader(status uint16, length int) []byte {
-	data := make([]byte, HEADER_SIZE)
-
-	copy(data, []byte("HE"))
-	binary.BigEndian.PutUint16(data[2:], status)
-	binary.BigEndian.PutUint32(data[4:], uint32(length))
-
-	return data
-}
-
 // Stats returns frame transmission statistics.
 func (client *Client) Stats() (sent, dropped int64) {
 	return client.framesSent.Load(), client.framesDropped.Load()
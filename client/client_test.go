@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// connectedClient returns a client connected to a listener of its own and
+// the server's end of the connection.
+func connectedClient(t *testing.T) (*Client, net.Conn) {
+	t.Helper()
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client := NewClient()
+	client.socket, err = net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.socket.Close() })
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return client, server
+}
+
+// readMessage reads the next packet, which must be a message of msgType,
+// into v.
+func readMessage(t *testing.T, conn net.Conn, msgType string, v interface{}) {
+	t.Helper()
+	typ, payload, err := protocol.ReadPacket(conn, nil, protocol.MaxServerMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != protocol.PacketMessage {
+		t.Fatalf("got packet %v, want a %s message", typ, msgType)
+	}
+	env, err := protocol.DecodeMessage(payload)
+	if err != nil || env.Type != msgType {
+		t.Fatalf("got %q message, %v; want %s", env.Type, err, msgType)
+	}
+	if err := env.Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	client, server := connectedClient(t)
+	if err := client.join("s1", "Ada"); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, payload, err := protocol.ReadPacket(server, nil, protocol.MaxServerMessage)
+	if err != nil || typ != protocol.PacketName {
+		t.Fatalf("first packet %v, %v; want the name", typ, err)
+	}
+	if id, name, err := protocol.ParseJoin(payload); id != "s1" || name != "Ada" || err != nil {
+		t.Errorf("joined as %q, %q, %v", id, name, err)
+	}
+
+	// The machine report comes before capture starts, which may fail
+	var report protocol.MachineReport
+	readMessage(t, server, protocol.MsgMachine, &report)
+	if report.Hostname == "" || report.OS == "" || report.Version != CLIENT_VERSION {
+		t.Errorf("machine report %+v", report)
+	}
+	if report.Backend != "" {
+		t.Errorf("backend %q reported before capture started", report.Backend)
+	}
+	if report.MachineID != machineID() {
+		t.Errorf("machine ID %q, want %q", report.MachineID, machineID())
+	}
+
+	var hello protocol.Hello
+	readMessage(t, server, protocol.MsgHello, &hello)
+	if hello.Version != protocol.Version {
+		t.Errorf("hello offers version %d, want %d", hello.Version, protocol.Version)
+	}
+}
+
+func TestMachineID(t *testing.T) {
+	if rawMachineID() == "" {
+		t.Skip("no machine ID on this system")
+	}
+	id := machineID()
+	if len(id) != 16 || id != machineID() {
+		t.Errorf("machine ID %q is not a stable 16 digit hash", id)
+	}
+	if id == rawMachineID() {
+		t.Error("the OS machine ID is sent as is")
+	}
+}
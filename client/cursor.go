@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+
+	"github.com/exam-gaurd/client/capture"
+	"github.com/exam-gaurd/protocol"
+)
+
+// cursorReporter sends the mouse pointer as its own small message so it
+// moves smoothly without re-encoding the frame underneath it. The shape is
+// only sent when it changes.
+type cursorReporter struct {
+	last      protocol.CursorReport
+	sent      bool
+	lastShape uint32
+}
+
+// update reads the pointer from capturer and reports it if it changed.
+// screenW and screenH are the size of the frames the position refers to.
+func (r *cursorReporter) update(client *Client, capturer capture.CursorCapturer, screenW, screenH int) {
+	if screenW <= 0 || screenH <= 0 {
+		return
+	}
+	cur, ok := capturer.Cursor()
+	if !ok {
+		return
+	}
+
+	report := protocol.CursorReport{
+		X:       cur.X,
+		Y:       cur.Y,
+		ScreenW: screenW,
+		ScreenH: screenH,
+		Visible: cur.Visible,
+		ShapeID: cur.Serial,
+	}
+	if r.sent && report == r.last {
+		return
+	}
+
+	msg := report
+	if cur.Serial != 0 && cur.Serial != r.lastShape && cur.Shape != nil {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, cur.Shape); err == nil {
+			msg.Shape = &protocol.CursorShape{
+				ID:   cur.Serial,
+				HotX: cur.HotX,
+				HotY: cur.HotY,
+				PNG:  buf.Bytes(),
+			}
+		}
+	}
+
+	if err := client.SendReport(protocol.MsgCursor, msg); err != nil {
+		return
+	}
+	r.last = report
+	r.sent = true
+	if msg.Shape != nil {
+		r.lastShape = cur.Serial
+	}
+}
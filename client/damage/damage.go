@@ -0,0 +1,190 @@
+// Package damage post-processes the dirty rectangles reported by capturers
+// before encoding: it clips, unions overlapping and adjacent rectangles, caps
+// the rectangle count and decides when a full keyframe is cheaper.
+package damage
+
+import "sort"
+
+// Rect is a changed region of the screen in frame pixels.
+type Rect struct {
+	X, Y, W, H int
+}
+
+// Empty reports whether the rectangle covers no pixels.
+func (r Rect) Empty() bool {
+	return r.W <= 0 || r.H <= 0
+}
+
+// Area returns the number of pixels covered.
+func (r Rect) Area() int {
+	if r.Empty() {
+		return 0
+	}
+	return r.W * r.H
+}
+
+// Union returns the smallest rectangle containing both r and o.
+func (r Rect) Union(o Rect) Rect {
+	x0, y0 := min(r.X, o.X), min(r.Y, o.Y)
+	x1, y1 := max(r.X+r.W, o.X+o.W), max(r.Y+r.H, o.Y+o.H)
+	return Rect{X: x0, Y: y0, W: x1 - x0, H: y1 - y0}
+}
+
+// Near reports whether r and o overlap or are at most gap pixels apart.
+func (r Rect) Near(o Rect, gap int) bool {
+	return r.X <= o.X+o.W+gap && o.X <= r.X+r.W+gap &&
+		r.Y <= o.Y+o.H+gap && o.Y <= r.Y+r.H+gap
+}
+
+// Clip returns the part of r inside a w×h frame.
+func (r Rect) Clip(w, h int) Rect {
+	x0, y0 := max(r.X, 0), max(r.Y, 0)
+	x1, y1 := min(r.X+r.W, w), min(r.Y+r.H, h)
+	if x1 <= x0 || y1 <= y0 {
+		return Rect{}
+	}
+	return Rect{X: x0, Y: y0, W: x1 - x0, H: y1 - y0}
+}
+
+// Options tune rectangle processing.
+type Options struct {
+	// MergeGap merges rectangles at most this many pixels apart.
+	MergeGap int
+
+	// MaxWaste is how much unchanged area a merge may add, as a fraction
+	// of the merged rectangles' own area.
+	MaxWaste float64
+
+	// MaxRects caps the number of rectangles; the cheapest pairs are
+	// merged until the count fits.
+	MaxRects int
+
+	// KeyframeRatio is the fraction of the frame above which the dirty
+	// area is sent as a keyframe instead.
+	KeyframeRatio float64
+}
+
+// DefaultOptions returns the options used by the client.
+func DefaultOptions() Options {
+	return Options{
+		MergeGap:      8,
+		MaxWaste:      0.5,
+		MaxRects:      16,
+		KeyframeRatio: 0.6,
+	}
+}
+
+// Process clips rects to a w×h frame and merges them. keyframe is true when
+// the dirty area is large enough that a full frame is cheaper to send, in
+// which case the returned rectangles should be ignored.
+func Process(rects []Rect, w, h int, opts Options) (out []Rect, keyframe bool) {
+	out = make([]Rect, 0, len(rects))
+	for _, r := range rects {
+		if r = r.Clip(w, h); !r.Empty() {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		return nil, false
+	}
+
+	out = Merge(out, opts.MergeGap, opts.MaxWaste)
+	if opts.MaxRects > 0 && len(out) > opts.MaxRects {
+		out = Coalesce(out, opts.MaxRects)
+	}
+
+	if opts.KeyframeRatio > 0 && float64(TotalArea(out)) > opts.KeyframeRatio*float64(w*h) {
+		return nil, true
+	}
+	return out, false
+}
+
+// Merge unions rectangles that are within gap pixels of each other as long
+// as the union adds at most maxWaste unchanged area. Rows of adjacent blocks
+// collapse into strips first so the pairwise pass stays small.
+func Merge(rects []Rect, gap int, maxWaste float64) []Rect {
+	rects = append([]Rect(nil), rects...)
+	sort.Slice(rects, func(i, j int) bool {
+		a, b := rects[i], rects[j]
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		if a.H != b.H {
+			return a.H < b.H
+		}
+		return a.X < b.X
+	})
+	rects = collapseRows(rects, gap, maxWaste)
+
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(rects); i++ {
+			for j := i + 1; j < len(rects); j++ {
+				a, b := rects[i], rects[j]
+				if !a.Near(b, gap) || !cheapUnion(a, b, maxWaste) {
+					continue
+				}
+				rects[i] = a.Union(b)
+				rects = append(rects[:j], rects[j+1:]...)
+				merged = true
+				j = i
+			}
+		}
+	}
+	return rects
+}
+
+// collapseRows joins runs of rectangles that share a row, i.e. have the
+// same Y and H, and follow each other within gap pixels, as a block-based
+// diff reports a changed line of text. rects must be sorted by Y, H and X;
+// the result keeps that order.
+func collapseRows(rects []Rect, gap int, maxWaste float64) []Rect {
+	out := rects[:0]
+	for _, r := range rects {
+		if n := len(out); n > 0 {
+			last := out[n-1]
+			if last.Y == r.Y && last.H == r.H && r.X <= last.X+last.W+gap && cheapUnion(last, r, maxWaste) {
+				out[n-1] = last.Union(r)
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// cheapUnion reports whether the union of a and b adds at most maxWaste
+// unchanged area.
+func cheapUnion(a, b Rect, maxWaste float64) bool {
+	return float64(a.Union(b).Area()) <= float64(a.Area()+b.Area())*(1+maxWaste)
+}
+
+// Coalesce merges the pair whose union grows the covered area least until at
+// most n rectangles remain.
+func Coalesce(rects []Rect, n int) []Rect {
+	rects = append([]Rect(nil), rects...)
+	for len(rects) > n && len(rects) > 1 {
+		bi, bj, best := 0, 1, -1
+		for i := 0; i < len(rects); i++ {
+			for j := i + 1; j < len(rects); j++ {
+				growth := rects[i].Union(rects[j]).Area() - rects[i].Area() - rects[j].Area()
+				if best < 0 || growth < best {
+					bi, bj, best = i, j, growth
+				}
+			}
+		}
+		rects[bi] = rects[bi].Union(rects[bj])
+		rects = append(rects[:bj], rects[bj+1:]...)
+	}
+	return rects
+}
+
+// TotalArea returns the summed area of rects. Overlaps are counted twice,
+// which only matters for unmerged input.
+func TotalArea(rects []Rect) int {
+	total := 0
+	for _, r := range rects {
+		total += r.Area()
+	}
+	return total
+}
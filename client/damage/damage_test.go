@@ -0,0 +1,164 @@
+package damage
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// sorted returns rects in reading order, for comparisons.
+func sorted(rects []Rect) []Rect {
+	rects = append([]Rect(nil), rects...)
+	sort.Slice(rects, func(i, j int) bool {
+		if rects[i].Y != rects[j].Y {
+			return rects[i].Y < rects[j].Y
+		}
+		return rects[i].X < rects[j].X
+	})
+	return rects
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		rects []Rect
+		want  []Rect
+	}{
+		{
+			name:  "overlapping",
+			rects: []Rect{{X: 0, Y: 0, W: 100, H: 100}, {X: 50, Y: 50, W: 100, H: 100}},
+			// 22500 px for 17500 px of change is within half again
+			want: []Rect{{X: 0, Y: 0, W: 150, H: 150}},
+		},
+		{
+			name:  "contained",
+			rects: []Rect{{X: 10, Y: 10, W: 20, H: 20}, {X: 0, Y: 0, W: 100, H: 100}},
+			want:  []Rect{{X: 0, Y: 0, W: 100, H: 100}},
+		},
+		{
+			name: "adjacent row",
+			rects: []Rect{
+				{X: 128, Y: 64, W: 64, H: 64}, {X: 0, Y: 64, W: 64, H: 64},
+				{X: 64, Y: 64, W: 64, H: 64}, {X: 192, Y: 64, W: 64, H: 64},
+			},
+			want: []Rect{{X: 0, Y: 64, W: 256, H: 64}},
+		},
+		{
+			name: "adjacent rows form a block",
+			rects: []Rect{
+				{X: 0, Y: 0, W: 64, H: 64}, {X: 64, Y: 0, W: 64, H: 64},
+				{X: 0, Y: 64, W: 64, H: 64}, {X: 64, Y: 64, W: 64, H: 64},
+			},
+			want: []Rect{{X: 0, Y: 0, W: 128, H: 128}},
+		},
+		{
+			name:  "within gap",
+			rects: []Rect{{X: 0, Y: 0, W: 64, H: 64}, {X: 70, Y: 0, W: 64, H: 64}},
+			want:  []Rect{{X: 0, Y: 0, W: 134, H: 64}},
+		},
+		{
+			name:  "disjoint",
+			rects: []Rect{{X: 0, Y: 0, W: 64, H: 64}, {X: 500, Y: 300, W: 64, H: 64}},
+			want:  []Rect{{X: 0, Y: 0, W: 64, H: 64}, {X: 500, Y: 300, W: 64, H: 64}},
+		},
+		{
+			name: "diagonal neighbours waste too much",
+			// Touching corners: the union is twice the changed area
+			rects: []Rect{{X: 0, Y: 0, W: 64, H: 64}, {X: 64, Y: 64, W: 64, H: 64}},
+			want:  []Rect{{X: 0, Y: 0, W: 64, H: 64}, {X: 64, Y: 64, W: 64, H: 64}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sorted(Merge(tt.rects, 8, 0.5))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeWasteThreshold(t *testing.T) {
+	// An L shape of 3 blocks: the union adds a fourth, a third more area
+	rects := []Rect{{X: 0, Y: 0, W: 128, H: 64}, {X: 0, Y: 64, W: 64, H: 64}}
+
+	if got := Merge(rects, 0, 0.3); len(got) != 2 {
+		t.Errorf("maxWaste 0.3: got %v, want the rectangles kept apart", got)
+	}
+	if got := Merge(rects, 0, 0.34); len(got) != 1 || got[0] != (Rect{W: 128, H: 128}) {
+		t.Errorf("maxWaste 0.34: got %v, want one 128x128 rectangle", got)
+	}
+
+	// Row collapsing obeys the threshold too: the gap between these two is
+	// almost as wide as both together
+	row := []Rect{{X: 0, Y: 0, W: 8, H: 8}, {X: 22, Y: 0, W: 8, H: 8}}
+	if got := Merge(row, 16, 0.5); len(got) != 2 {
+		t.Errorf("wide row gap: got %v, want the rectangles kept apart", got)
+	}
+}
+
+func TestMergeKeepsInput(t *testing.T) {
+	rects := []Rect{{X: 64, Y: 0, W: 64, H: 64}, {X: 0, Y: 0, W: 64, H: 64}}
+	want := append([]Rect(nil), rects...)
+	Merge(rects, 8, 0.5)
+	if !reflect.DeepEqual(rects, want) {
+		t.Errorf("Merge modified its input: %v", rects)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	rects := []Rect{
+		{X: 0, Y: 0, W: 10, H: 10},
+		{X: 12, Y: 0, W: 10, H: 10}, // Cheapest to join with the first
+		{X: 500, Y: 500, W: 10, H: 10},
+	}
+	got := sorted(Coalesce(rects, 2))
+	want := []Rect{{X: 0, Y: 0, W: 22, H: 10}, {X: 500, Y: 500, W: 10, H: 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Coalesce = %v, want %v", got, want)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	opts := DefaultOptions()
+
+	out, key := Process([]Rect{{X: -10, Y: -10, W: 20, H: 20}, {X: 90, Y: 90, W: 20, H: 20}}, 100, 100, opts)
+	if key {
+		t.Fatal("small damage: want dirty rects, got keyframe")
+	}
+	want := []Rect{{X: 0, Y: 0, W: 10, H: 10}, {X: 90, Y: 90, W: 10, H: 10}}
+	if got := sorted(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("clipped = %v, want %v", got, want)
+	}
+
+	if out, key := Process([]Rect{{X: 200, Y: 200, W: 10, H: 10}}, 100, 100, opts); out != nil || key {
+		t.Errorf("damage outside the frame: got %v, %v", out, key)
+	}
+
+	if _, key := Process([]Rect{{W: 100, H: 70}}, 100, 100, opts); !key {
+		t.Error("70% damage: want a keyframe")
+	}
+
+	many := make([]Rect, 0, 50)
+	for i := 0; i < 50; i++ {
+		many = append(many, Rect{X: i * 40 % 1000, Y: i * 97 % 1000, W: 4, H: 4})
+	}
+	if out, _ := Process(many, 1000, 1000, opts); len(out) > opts.MaxRects {
+		t.Errorf("got %d rects, want at most %d", len(out), opts.MaxRects)
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	// A scrolled 1080p window as 64px diff blocks
+	var rects []Rect
+	for y := 0; y < 1080; y += 64 {
+		for x := 0; x < 1280; x += 64 {
+			rects = append(rects, Rect{X: x, Y: y, W: 64, H: 64})
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Merge(rects, 8, 0.5)
+	}
+}
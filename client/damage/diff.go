@@ -0,0 +1,102 @@
+package damage
+
+import "bytes"
+
+// DefaultBlockSize is the block edge, in pixels, used by capturers that find
+// dirty regions by comparing frames.
+const DefaultBlockSize = 64
+
+// Diff compares two w×h RGBA frames with the given row stride and returns
+// the blocks that changed, merged into rectangles. Every pixel is compared,
+// so a single changed character is found; rows are compared with
+// bytes.Equal, which works a machine word or vector at a time, and a row
+// identical across the frame skips all its blocks at once.
+//
+// If prev is too short to hold a frame, the whole frame is reported.
+func Diff(cur, prev []byte, w, h, stride, blockSize int) []Rect {
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	size := (h-1)*stride + w*4
+	if len(cur) < size || len(prev) < size {
+		return []Rect{{W: w, H: h}}
+	}
+
+	blocksX := (w + blockSize - 1) / blockSize
+	dirty := make([]bool, blocksX)
+
+	var rects, above []Rect
+	for by := 0; by*blockSize < h; by++ {
+		y0 := by * blockSize
+		y1 := min(y0+blockSize, h)
+
+		clear(dirty)
+		remaining := blocksX
+		for y := y0; y < y1 && remaining > 0; y++ {
+			row := y * stride
+			if bytes.Equal(cur[row:row+w*4], prev[row:row+w*4]) {
+				continue
+			}
+			for bx := 0; bx < blocksX; bx++ {
+				if dirty[bx] {
+					continue
+				}
+				start := row + bx*blockSize*4
+				end := row + min((bx+1)*blockSize, w)*4
+				if !bytes.Equal(cur[start:end], prev[start:end]) {
+					dirty[bx] = true
+					remaining--
+				}
+			}
+		}
+
+		row := blockRuns(dirty, blockSize, w, y0, y1-y0)
+		rects, above = extendRuns(rects, above, row)
+	}
+
+	return append(rects, above...)
+}
+
+// blockRuns turns runs of dirty blocks in one block row into rectangles.
+func blockRuns(dirty []bool, blockSize, w, y, h int) []Rect {
+	var runs []Rect
+	for bx := 0; bx < len(dirty); {
+		if !dirty[bx] {
+			bx++
+			continue
+		}
+		start := bx
+		for bx < len(dirty) && dirty[bx] {
+			bx++
+		}
+		x := start * blockSize
+		runs = append(runs, Rect{X: x, Y: y, W: min(bx*blockSize, w) - x, H: h})
+	}
+	return runs
+}
+
+// extendRuns grows rectangles from the block row above downwards when a run
+// in row spans exactly the same columns. Rectangles that stop growing are
+// moved to done. It returns done and the rectangles open at row.
+func extendRuns(done, above, row []Rect) ([]Rect, []Rect) {
+	open := make([]Rect, 0, len(row))
+	for _, r := range row {
+		extended := false
+		for i, a := range above {
+			if a.X == r.X && a.W == r.W && a.Y+a.H == r.Y {
+				a.H += r.H
+				open = append(open, a)
+				above = append(above[:i], above[i+1:]...)
+				extended = true
+				break
+			}
+		}
+		if !extended {
+			open = append(open, r)
+		}
+	}
+	return append(done, above...), open
+}
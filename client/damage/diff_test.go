@@ -0,0 +1,168 @@
+package damage
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// frames returns two identical w×h frames with the given stride, filled
+// with a pattern and with junk in the row padding.
+func frames(w, h, stride int) (cur, prev []byte) {
+	cur = make([]byte, (h-1)*stride+w*4)
+	for i := range cur {
+		cur[i] = byte(i * 7)
+	}
+	prev = append([]byte(nil), cur...)
+	return cur, prev
+}
+
+// setPixel changes one channel of the pixel at x, y.
+func setPixel(frame []byte, stride, x, y int) {
+	frame[y*stride+x*4+1]++
+}
+
+// pixelDiff is the comparison the capturers used before Diff: a pixel
+// loop per block, reporting each changed block. It checks every pixel
+// rather than every 8th as they did, so it finds what Diff finds.
+func pixelDiff(cur, prev []byte, w, h, stride, blockSize int) []Rect {
+	var rects []Rect
+	for by := 0; by*blockSize < h; by++ {
+		for bx := 0; bx*blockSize < w; bx++ {
+			r := Rect{X: bx * blockSize, Y: by * blockSize, W: blockSize, H: blockSize}
+			r.W = min(r.W, w-r.X)
+			r.H = min(r.H, h-r.Y)
+
+			changed := false
+			for y := r.Y; y < r.Y+r.H && !changed; y++ {
+				for x := r.X; x < r.X+r.W && !changed; x++ {
+					o := y*stride + x*4
+					changed = cur[o] != prev[o] || cur[o+1] != prev[o+1] ||
+						cur[o+2] != prev[o+2] || cur[o+3] != prev[o+3]
+				}
+			}
+			if changed {
+				rects = append(rects, r)
+			}
+		}
+	}
+	return rects
+}
+
+// blocks returns the blockSize blocks the rects cover.
+func blocks(rects []Rect, blockSize int) map[[2]int]bool {
+	set := make(map[[2]int]bool)
+	for _, r := range rects {
+		for y := r.Y; y < r.Y+r.H; y += blockSize {
+			for x := r.X; x < r.X+r.W; x += blockSize {
+				set[[2]int{x / blockSize, y / blockSize}] = true
+			}
+		}
+	}
+	return set
+}
+
+func TestDiff(t *testing.T) {
+	const w, h = 150, 100 // Partial blocks at the right and bottom edges
+	tests := []struct {
+		name   string
+		stride int
+		pixels [][2]int
+		want   []Rect
+	}{
+		{"identical", w * 4, nil, nil},
+		{"single pixel", w * 4, [][2]int{{70, 10}}, []Rect{{X: 64, Y: 0, W: 64, H: 64}}},
+		{"right edge", w * 4, [][2]int{{149, 10}}, []Rect{{X: 128, Y: 0, W: 22, H: 64}}},
+		{"bottom edge", w * 4, [][2]int{{10, 99}}, []Rect{{X: 0, Y: 64, W: 64, H: 36}}},
+		{"corner", w * 4, [][2]int{{149, 99}}, []Rect{{X: 128, Y: 64, W: 22, H: 36}}},
+		{"padded single pixel", w*4 + 24, [][2]int{{149, 99}}, []Rect{{X: 128, Y: 64, W: 22, H: 36}}},
+		{
+			"column of blocks",
+			w * 4,
+			[][2]int{{0, 0}, {0, 99}},
+			[]Rect{{X: 0, Y: 0, W: 64, H: 100}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur, prev := frames(w, h, tt.stride)
+			for _, p := range tt.pixels {
+				setPixel(cur, tt.stride, p[0], p[1])
+			}
+			if got := Diff(cur, prev, w, h, tt.stride, 64); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffIgnoresStridePadding(t *testing.T) {
+	const w, h, stride = 150, 100, 150*4 + 24
+	cur, prev := frames(w, h, stride)
+	for y := 0; y < h-1; y++ {
+		for i := w * 4; i < stride; i++ {
+			cur[y*stride+i]++
+		}
+	}
+	if got := Diff(cur, prev, w, h, stride, 64); len(got) != 0 {
+		t.Errorf("changed padding reported as %v", got)
+	}
+}
+
+func TestDiffShortFrame(t *testing.T) {
+	cur, _ := frames(150, 100, 600)
+	want := []Rect{{W: 150, H: 100}}
+	if got := Diff(cur, nil, 150, 100, 600, 64); !reflect.DeepEqual(got, want) {
+		t.Errorf("no previous frame: got %v, want %v", got, want)
+	}
+}
+
+func TestDiffMatchesPixelDiff(t *testing.T) {
+	const w, h, stride = 1000, 700, 1000*4 + 32
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 20; run++ {
+		cur, prev := frames(w, h, stride)
+		for i := rng.Intn(40); i > 0; i-- {
+			setPixel(cur, stride, rng.Intn(w), rng.Intn(h))
+		}
+		got := blocks(Diff(cur, prev, w, h, stride, 64), 64)
+		want := blocks(pixelDiff(cur, prev, w, h, stride, 64), 64)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: Diff covers blocks %v, want %v", run, got, want)
+		}
+	}
+}
+
+func benchmarkDiff(b *testing.B, diff func(cur, prev []byte, w, h, stride, blockSize int) []Rect) {
+	const w, h = 1920, 1080
+	for _, c := range []struct {
+		name    string
+		changed int
+	}{
+		{"identical", 0},
+		{"typing", 3},
+		{"scattered", 200},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			cur, prev := frames(w, h, w*4)
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < c.changed; i++ {
+				setPixel(cur, w*4, rng.Intn(w), rng.Intn(h))
+			}
+			b.SetBytes(int64(len(cur)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				diff(cur, prev, w, h, w*4, DefaultBlockSize)
+			}
+		})
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	benchmarkDiff(b, Diff)
+}
+
+func BenchmarkPixelDiff(b *testing.B) {
+	benchmarkDiff(b, pixelDiff)
+}
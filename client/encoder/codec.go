@@ -0,0 +1,166 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// Tile codec identifiers, sent as one byte per tile in FrameDirtyCodec
+// and FrameKeyStripsCodec frames.
+const (
+	CodecJPEG = protocol.CodecJPEG // Lossy, for photos and gradients
+	CodecPNG  = protocol.CodecPNG  // Lossless paletted PNG, for UI and text
+	CodecRLE  = protocol.CodecRLE  // Palette + run-length, for flat text such as terminals
+)
+
+// Colour-count thresholds for choosing a tile codec.
+const (
+	rleMaxColors     = 16
+	losslessMaxColor = 256
+)
+
+// Codec compresses one tile.
+type Codec interface {
+	// ID is the codec byte written before the tile data.
+	ID() byte
+
+	// Encode writes img to buf.
+	Encode(buf *bytes.Buffer, img *image.RGBA) error
+}
+
+// jpegCodec encodes tiles as baseline JPEG at a fixed quality.
+type jpegCodec struct {
+	quality int
+}
+
+func (c jpegCodec) ID() byte { return CodecJPEG }
+
+func (c jpegCodec) Encode(buf *bytes.Buffer, img *image.RGBA) error {
+	return jpeg.Encode(buf, img, &jpeg.Options{Quality: c.quality})
+}
+
+// pngCodec encodes tiles as paletted PNG. The palette must cover every
+// colour in the tile.
+type pngCodec struct {
+	palette []color.RGBA
+}
+
+var pngEncoder = &png.Encoder{CompressionLevel: png.BestSpeed}
+
+func (c pngCodec) ID() byte { return CodecPNG }
+
+func (c pngCodec) Encode(buf *bytes.Buffer, img *image.RGBA) error {
+	pal := make(color.Palette, len(c.palette))
+	index := make(map[color.RGBA]uint8, len(c.palette))
+	for i, col := range c.palette {
+		pal[i] = col
+		index[col] = uint8(i)
+	}
+
+	b := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), pal)
+	for y := 0; y < b.Dy(); y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		out := paletted.Pix[y*paletted.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			p := row[x*4:]
+			out[x] = index[color.RGBA{R: p[0], G: p[1], B: p[2], A: 255}]
+		}
+	}
+	return pngEncoder.Encode(buf, paletted)
+}
+
+// rleCodec encodes tiles as a palette followed by runs over the pixels in
+// row-major order.
+// Format: [ncolors:1][R,G,B]*ncolors then [index:1][run-1:1]...
+// ncolors of 0 means 256.
+type rleCodec struct {
+	palette []color.RGBA
+}
+
+func (c rleCodec) ID() byte { return CodecRLE }
+
+func (c rleCodec) Encode(buf *bytes.Buffer, img *image.RGBA) error {
+	index := make(map[color.RGBA]uint8, len(c.palette))
+	buf.WriteByte(uint8(len(c.palette)))
+	for i, col := range c.palette {
+		buf.Write([]byte{col.R, col.G, col.B})
+		index[col] = uint8(i)
+	}
+
+	b := img.Bounds()
+	run := 0
+	var current uint8
+	flush := func() {
+		if run > 0 {
+			buf.Write([]byte{current, uint8(run - 1)})
+		}
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			p := row[x*4:]
+			i := index[color.RGBA{R: p[0], G: p[1], B: p[2], A: 255}]
+			if run > 0 && (i != current || run == 256) {
+				flush()
+				run = 0
+			}
+			current = i
+			run++
+		}
+	}
+	flush()
+
+	return nil
+}
+
+// chooseCodec picks a codec from the tile's colour count: few colours mean
+// text or UI, which lossless codecs keep sharp and usually compress better
+// than JPEG; many colours mean photos or video, which JPEG handles best.
+func chooseCodec(img *image.RGBA, quality int) Codec {
+	palette, ok := tilePalette(img, losslessMaxColor)
+	switch {
+	case !ok:
+		return jpegCodec{quality: quality}
+	case len(palette) <= rleMaxColors:
+		return rleCodec{palette: palette}
+	default:
+		return pngCodec{palette: palette}
+	}
+}
+
+// tilePalette collects the distinct opaque colours in img, giving up once
+// there are more than limit.
+func tilePalette(img *image.RGBA, limit int) ([]color.RGBA, bool) {
+	seen := make(map[uint32]struct{}, limit)
+	var palette []color.RGBA
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):]
+		last := uint32(1 << 31) // Never a packed RGB value
+		for x := 0; x < b.Dx(); x++ {
+			p := row[x*4:]
+			key := uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+			if key == last {
+				continue
+			}
+			last = key
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			if len(palette) == limit {
+				return nil, false
+			}
+			seen[key] = struct{}{}
+			palette = append(palette, color.RGBA{R: p[0], G: p[1], B: p[2], A: 255})
+		}
+	}
+	return palette, true
+}
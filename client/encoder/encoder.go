@@ -4,17 +4,20 @@ package encoder
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"image"
 	"image/jpeg"
+	"runtime"
 	"sync"
 
 	"github.com/exam-gaurd/client/capture"
+	"github.com/exam-gaurd/protocol"
 )
 
 // EncodedFrame represents an encoded frame ready for transmission.
 type EncodedFrame struct {
 	// Data contains the encoded frame data.
-	// For keyframes: just JPEG data
+	// For keyframes: just JPEG data, or a header + JPEG strips
 	// For dirty rect frames: header + multiple JPEG tiles
 	Data []byte
 
@@ -22,18 +25,25 @@ type EncodedFrame struct {
 	IsKeyFrame bool
 }
 
-// FrameType constants for protocol
-const (
-	FrameTypeKey   byte = 0x01 // Full JPEG frame
-	FrameTypeDirty byte = 0x02 // Dirty rectangles
-)
+// maxWorkers bounds the default tile encoding parallelism so the client
+// leaves CPU for the exam itself.
+const maxWorkers = 4
+
+// ErrEncodeFailed is returned when part of a keyframe could not be encoded.
+var ErrEncodeFailed = errors.New("encoder: failed to encode frame")
 
 // Encoder handles MJPEG encoding with dirty rectangle optimization.
 type Encoder struct {
-	quality     int
-	maxWidth    int
-	bufferPool  *sync.Pool
-	jpegBufPool *sync.Pool
+	quality      int
+	maxWidth     int
+	scaleQuality ScaleQuality
+	scaler       *scaler
+	workers      int
+	strips       int
+	lossless     bool
+	version      int
+	bufferPool   *sync.Pool
+	jpegBufPool  *sync.Pool
 
 	// Statistics
 	keyFramesSent   int64
@@ -47,13 +57,30 @@ type EncoderConfig struct {
 
 	// MaxWidth is the maximum output width. 0 means no scaling.
 	MaxWidth int
+
+	// ScaleQuality is the downscaling filter. Default: ScaleArea
+	ScaleQuality ScaleQuality
+
+	// Workers is the number of goroutines encoding tiles in parallel.
+	// Default: GOMAXPROCS, at most 4. 1 encodes on the caller's goroutine.
+	Workers int
+
+	// KeyFrameStrips splits keyframes into this many horizontal strips
+	// encoded in parallel. 0 or 1 sends a single JPEG.
+	KeyFrameStrips int
+
+	// LosslessTiles lets tiles with few colours (text, terminals, UI) use
+	// a lossless codec instead of JPEG.
+	LosslessTiles bool
 }
 
 // DefaultConfig returns the default encoder configuration.
 func DefaultConfig() EncoderConfig {
 	return EncoderConfig{
-		Quality:  45,
-		MaxWidth: 720,
+		Quality:       45,
+		MaxWidth:      720,
+		ScaleQuality:  ScaleArea,
+		LosslessTiles: true,
 	}
 }
 
@@ -62,10 +89,18 @@ func NewEncoder(config EncoderConfig) *Encoder {
 	if config.Quality <= 0 || config.Quality > 100 {
 		config.Quality = 45
 	}
+	if config.Workers <= 0 {
+		config.Workers = min(runtime.GOMAXPROCS(0), maxWorkers)
+	}
 
 	return &Encoder{
-		quality:  config.Quality,
-		maxWidth: config.MaxWidth,
+		quality:      config.Quality,
+		maxWidth:     config.MaxWidth,
+		scaleQuality: config.ScaleQuality,
+		workers:      config.Workers,
+		strips:       config.KeyFrameStrips,
+		lossless:     config.LosslessTiles,
+		version:      protocol.Version,
 		bufferPool: &sync.Pool{
 			New: func() interface{} {
 				return bytes.NewBuffer(make([]byte, 0, 128*1024))
@@ -79,6 +114,18 @@ func NewEncoder(config EncoderConfig) *Encoder {
 	}
 }
 
+// SetProtocolVersion limits the frame types to those the server speaks.
+// Strip keyframes and lossless tiles need protocol.Version2.
+func (e *Encoder) SetProtocolVersion(v int) {
+	e.version = v
+}
+
+// SetMaxWidth changes the maximum output width. Dirty rects are scaled to
+// the size of the last keyframe, so the next frame must be a keyframe.
+func (e *Encoder) SetMaxWidth(w int) {
+	e.maxWidth = w
+}
+
 // Encode encodes a frame with optional dirty rectangles.
 // Returns nil if the frame should be dropped (no changes).
 func (e *Encoder) Encode(frame *capture.FrameWithDirty) (*EncodedFrame, error) {
@@ -99,23 +146,57 @@ func (e *Encoder) Encode(frame *capture.FrameWithDirty) (*EncodedFrame, error) {
 	return e.encodeDirtyRects(frame.Frame, frame.DirtyRects, buf)
 }
 
-// encodeKeyFrame encodes a full frame as JPEG.
+// encodeKeyFrame encodes a full frame as JPEG, or as parallel strips when
+// configured.
+// Strips format: [type:1][w:2][h:2][count:2] then tiles as in dirty frames
+// (FrameKeyStripsCodec when lossless tiles are enabled)
 func (e *Encoder) encodeKeyFrame(frame *capture.Frame, buf *bytes.Buffer) (*EncodedFrame, error) {
-	// Create image from frame
-	img := e.frameToImage(frame)
+	sc := e.scalerFor(frame)
+	bounds := image.Rect(0, 0, frame.W, frame.H)
+	if sc != nil {
+		bounds = sc.Bounds()
+	}
 
-	// Scale if needed
-	if e.maxWidth > 0 && img.Bounds().Dx() > e.maxWidth {
-		img = e.scaleImage(img, e.maxWidth)
+	strips := e.strips
+	if e.version < protocol.Version2 {
+		strips = 0
 	}
 
-	// Write frame type header
-	buf.WriteByte(FrameTypeKey)
+	if strips > 1 && bounds.Dy() >= strips*stripAlign {
+		regions := keyFrameStrips(bounds, strips)
+		lossless := e.useLossless()
+		tiles := e.encodeTiles(frame, sc, regions, e.quality)
 
-	// Encode as JPEG
-	opts := jpeg.Options{Quality: e.quality}
-	if err := jpeg.Encode(buf, img, &opts); err != nil {
-		return nil, err
+		if lossless {
+			buf.WriteByte(protocol.FrameKeyStripsCodec)
+		} else {
+			buf.WriteByte(protocol.FrameKeyStrips)
+		}
+		binary.Write(buf, binary.BigEndian, uint16(bounds.Dx()))
+		binary.Write(buf, binary.BigEndian, uint16(bounds.Dy()))
+		countPos := buf.Len()
+		binary.Write(buf, binary.BigEndian, uint16(0))
+
+		count := writeTiles(buf, regions, tiles, lossless)
+		if int(count) != len(regions) {
+			return nil, ErrEncodeFailed
+		}
+		binary.BigEndian.PutUint16(buf.Bytes()[countPos:], count)
+	} else {
+		// Create image from frame, scaled if needed
+		var img image.Image = e.frameToImage(frame)
+		if sc != nil {
+			img = sc.Scale(e.frameToImage(frame), bounds)
+		}
+
+		// Write frame type header
+		buf.WriteByte(protocol.FrameKey)
+
+		// Encode as JPEG
+		opts := jpeg.Options{Quality: e.quality}
+		if err := jpeg.Encode(buf, img, &opts); err != nil {
+			return nil, err
+		}
 	}
 
 	// Copy result
@@ -133,69 +214,40 @@ func (e *Encoder) encodeKeyFrame(frame *capture.Frame, buf *bytes.Buffer) (*Enco
 // encodeDirtyRects encodes only changed regions.
 // Format: [type:1][count:2][rect1_header:8][rect1_data]...[rectN_header:8][rectN_data]
 // Rect header: [x:2][y:2][w:2][h:2]
+// With lossless tiles the type is FrameDirtyCodec and each rect header
+// is followed by a codec byte.
 func (e *Encoder) encodeDirtyRects(frame *capture.Frame, rects []capture.DirtyRect, buf *bytes.Buffer) (*EncodedFrame, error) {
-	// Calculate scale factor
-	scaleX := 1.0
-	scaleY := 1.0
-	if e.maxWidth > 0 && frame.W > e.maxWidth {
-		scale := float64(e.maxWidth) / float64(frame.W)
-		scaleX = scale
-		scaleY = scale
-	}
-
-	// Write header
-	buf.WriteByte(FrameTypeDirty)
-
-	// Write rect count (will update later if some are skipped)
-	countPos := buf.Len()
-	binary.Write(buf, binary.BigEndian, uint16(0))
-
-	jpegBuf := e.jpegBufPool.Get().(*bytes.Buffer)
-	defer e.jpegBufPool.Put(jpegBuf)
-
-	actualCount := uint16(0)
+	// Tiles are rendered from the full frame with the keyframe's mapping
+	sc := e.scalerFor(frame)
 
+	regions := make([]image.Rectangle, 0, len(rects))
 	for _, rect := range rects {
-		// Extract rect pixels from frame
-		rectImg := e.extractRect(frame, rect)
-		if rectImg == nil {
-			continue
+		var out image.Rectangle
+		if sc != nil {
+			out = sc.mapRect(rect)
+		} else {
+			out = image.Rect(rect.X, rect.Y, rect.X+rect.W, rect.Y+rect.H)
 		}
-
-		// Scale rect coordinates and size
-		scaledX := int(float64(rect.X) * scaleX)
-		scaledY := int(float64(rect.Y) * scaleY)
-		scaledW := int(float64(rect.W) * scaleX)
-		scaledH := int(float64(rect.H) * scaleY)
-
-		// Scale rect image if needed
-		if scaleX != 1.0 {
-			rectImg = e.scaleImage(rectImg, scaledW)
-		}
-
-		// Encode rect as JPEG
-		jpegBuf.Reset()
-		opts := jpeg.Options{Quality: e.quality + 5} // Slightly higher quality for small regions
-		if err := jpeg.Encode(jpegBuf, rectImg, &opts); err != nil {
-			continue
+		if !out.Empty() {
+			regions = append(regions, out)
 		}
+	}
 
-		// Write rect header: x, y, w, h (scaled coordinates)
-		binary.Write(buf, binary.BigEndian, uint16(scaledX))
-		binary.Write(buf, binary.BigEndian, uint16(scaledY))
-		binary.Write(buf, binary.BigEndian, uint16(scaledW))
-		binary.Write(buf, binary.BigEndian, uint16(scaledH))
-
-		// Write JPEG data length and data
-		binary.Write(buf, binary.BigEndian, uint32(jpegBuf.Len()))
-		buf.Write(jpegBuf.Bytes())
+	// Slightly higher quality for small regions
+	lossless := e.useLossless()
+	tiles := e.encodeTiles(frame, sc, regions, e.quality+5)
 
-		actualCount++
+	// Write header
+	if lossless {
+		buf.WriteByte(protocol.FrameDirtyCodec)
+	} else {
+		buf.WriteByte(protocol.FrameDirty)
 	}
+	countPos := buf.Len()
+	binary.Write(buf, binary.BigEndian, uint16(0))
 
-	// Update rect count
-	data := buf.Bytes()
-	binary.BigEndian.PutUint16(data[countPos:], actualCount)
+	actualCount := writeTiles(buf, regions, tiles, lossless)
+	binary.BigEndian.PutUint16(buf.Bytes()[countPos:], actualCount)
 
 	// If no rects were encoded, return nil
 	if actualCount == 0 {
@@ -214,8 +266,25 @@ func (e *Encoder) encodeDirtyRects(frame *capture.Frame, rects []capture.DirtyRe
 	}, nil
 }
 
-// frameToImage converts a capture.Frame to image.Image.
-func (e *Encoder) frameToImage(frame *capture.Frame) image.Image {
+// useLossless reports whether tiles may use a lossless codec.
+func (e *Encoder) useLossless() bool {
+	return e.lossless && e.version >= protocol.Version2
+}
+
+// scalerFor returns the scaler for frames of this size, or nil if the frame
+// fits within maxWidth. The scaler is rebuilt only when the size changes.
+func (e *Encoder) scalerFor(frame *capture.Frame) *scaler {
+	if e.maxWidth <= 0 || frame.W <= e.maxWidth {
+		return nil
+	}
+	if e.scaler == nil || !e.scaler.matches(frame.W, frame.H, e.maxWidth, e.scaleQuality) {
+		e.scaler = newScaler(frame.W, frame.H, e.maxWidth, e.scaleQuality)
+	}
+	return e.scaler
+}
+
+// frameToImage wraps a capture.Frame's pixels without copying.
+func (e *Encoder) frameToImage(frame *capture.Frame) *image.RGBA {
 	return &image.RGBA{
 		Pix:    frame.Pix,
 		Stride: frame.Stride,
@@ -224,7 +293,7 @@ func (e *Encoder) frameToImage(frame *capture.Frame) image.Image {
 }
 
 // extractRect extracts a rectangular region from a frame.
-func (e *Encoder) extractRect(frame *capture.Frame, rect capture.DirtyRect) image.Image {
+func (e *Encoder) extractRect(frame *capture.Frame, rect capture.DirtyRect) *image.RGBA {
 	// Bounds check
 	if rect.X < 0 || rect.Y < 0 || rect.X+rect.W > frame.W || rect.Y+rect.H > frame.H {
 		return nil
@@ -255,42 +324,6 @@ func (e *Encoder) extractRect(frame *capture.Frame, rect capture.DirtyRect) imag
 	}
 }
 
-// scaleImage scales an image to the specified width, maintaining aspect ratio.
-// Uses simple nearest-neighbor scaling for speed.
-func (e *Encoder) scaleImage(src image.Image, targetWidth int) image.Image {
-	bounds := src.Bounds()
-	srcW := bounds.Dx()
-	srcH := bounds.Dy()
-
-	if srcW <= targetWidth {
-		return src
-	}
-
-	scale := float64(targetWidth) / float64(srcW)
-	targetHeight := int(float64(srcH) * scale)
-
-	dst := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
-
-	// Nearest-neighbor scaling
-	for y := 0; y < targetHeight; y++ {
-		srcY := int(float64(y) / scale)
-		if srcY >= srcH {
-			srcY = srcH - 1
-		}
-		for x := 0; x < targetWidth; x++ {
-			srcX := int(float64(x) / scale)
-			if srcX >= srcW {
-				srcX = srcW - 1
-			}
-
-			c := src.At(bounds.Min.X+srcX, bounds.Min.Y+srcY)
-			dst.Set(x, y, c)
-		}
-	}
-
-	return dst
-}
-
 // Stats returns encoder statistics.
 func (e *Encoder) Stats() (keyFrames, dirtyFrames int64) {
 	return e.keyFramesSent, e.dirtyFramesSent
@@ -0,0 +1,251 @@
+package encoder
+
+import (
+	"image"
+
+	"github.com/exam-gaurd/client/capture"
+)
+
+// ScaleQuality selects the resampling filter used when downscaling frames.
+type ScaleQuality int
+
+const (
+	// ScaleArea averages every source pixel covered by an output pixel.
+	// It keeps text legible at large reduction factors and is the default.
+	ScaleArea ScaleQuality = iota
+
+	// ScaleBilinear interpolates the four nearest source pixels. Cheaper
+	// than ScaleArea but aliases when reducing by more than 2x.
+	ScaleBilinear
+
+	// ScaleNearest picks the closest source pixel. Fastest, lowest quality.
+	ScaleNearest
+)
+
+// scaler maps a source frame onto a smaller output grid. The mapping is
+// global: any region of the output is computed from the full source frame,
+// so a dirty tile produces exactly the pixels the keyframe would have at
+// that position. A scaler is immutable once built and safe for concurrent use.
+type scaler struct {
+	srcW, srcH int
+	dstW, dstH int
+	quality    ScaleQuality
+
+	// Per output column/row:
+	//   area:     output i covers source [idx[i], idx[i+1])
+	//   bilinear: left/top source pixel and 8-bit weight of the next one
+	//   nearest:  source pixel
+	xIdx, yIdx []int
+	xWt, yWt   []uint32
+}
+
+// newScaler builds the lookup tables for scaling srcW×srcH to dstW wide,
+// keeping the aspect ratio.
+func newScaler(srcW, srcH, dstW int, quality ScaleQuality) *scaler {
+	dstH := srcH * dstW / srcW
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	s := &scaler{
+		srcW:    srcW,
+		srcH:    srcH,
+		dstW:    dstW,
+		dstH:    dstH,
+		quality: quality,
+	}
+	s.xIdx, s.xWt = buildAxis(srcW, dstW, quality)
+	s.yIdx, s.yWt = buildAxis(srcH, dstH, quality)
+	return s
+}
+
+// matches reports whether the scaler can be reused for this configuration.
+func (s *scaler) matches(srcW, srcH, dstW int, quality ScaleQuality) bool {
+	return s.srcW == srcW && s.srcH == srcH && s.dstW == dstW && s.quality == quality
+}
+
+func buildAxis(src, dst int, quality ScaleQuality) ([]int, []uint32) {
+	switch quality {
+	case ScaleBilinear:
+		idx := make([]int, dst)
+		wt := make([]uint32, dst)
+		for i := 0; i < dst; i++ {
+			// Pixel centre in 1/256 source pixels
+			c := ((2*i+1)*src*256)/(2*dst) - 128
+			if c < 0 {
+				c = 0
+			}
+			idx[i] = c >> 8
+			wt[i] = uint32(c & 0xFF)
+			if idx[i] >= src-1 {
+				idx[i] = src - 1
+				wt[i] = 0
+			}
+		}
+		return idx, wt
+
+	case ScaleNearest:
+		idx := make([]int, dst)
+		for i := 0; i < dst; i++ {
+			idx[i] = (2*i + 1) * src / (2 * dst)
+		}
+		return idx, nil
+
+	default:
+		idx := make([]int, dst+1)
+		for i := 0; i <= dst; i++ {
+			idx[i] = i * src / dst
+		}
+		// Every output pixel must cover at least one source pixel
+		for i := 1; i <= dst; i++ {
+			if idx[i] <= idx[i-1] {
+				idx[i] = idx[i-1] + 1
+			}
+		}
+		if idx[dst] > src {
+			idx[dst] = src
+		}
+		return idx, nil
+	}
+}
+
+// Bounds returns the output grid.
+func (s *scaler) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.dstW, s.dstH)
+}
+
+// tileAlign is the output grid that tile edges are snapped to. It matches
+// the JPEG block size so tile edges fall on block boundaries of the keyframe.
+const tileAlign = 8
+
+// mapRect returns the output region affected by a change in source rect r.
+// The region is snapped outward: it covers every output pixel whose
+// footprint touches r, rounded out to tileAlign and clamped to the grid, so
+// tiles rendered with Scale land exactly on the keyframe's pixels.
+func (s *scaler) mapRect(r capture.DirtyRect) image.Rectangle {
+	x0 := r.X * s.dstW / s.srcW
+	y0 := r.Y * s.dstH / s.srcH
+	x1 := ceilDiv((r.X+r.W)*s.dstW, s.srcW)
+	y1 := ceilDiv((r.Y+r.H)*s.dstH, s.srcH)
+
+	// Bilinear samples reach one source pixel past the footprint
+	if s.quality == ScaleBilinear {
+		x0, y0, x1, y1 = x0-1, y0-1, x1+1, y1+1
+	}
+
+	x0 = x0 / tileAlign * tileAlign
+	y0 = y0 / tileAlign * tileAlign
+	x1 = ceilDiv(x1, tileAlign) * tileAlign
+	y1 = ceilDiv(y1, tileAlign) * tileAlign
+
+	return image.Rect(x0, y0, x1, y1).Intersect(s.Bounds())
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// Scale renders region r of the output grid from src, which must be the
+// full srcW×srcH frame. The result has its origin at (0, 0).
+func (s *scaler) Scale(src *image.RGBA, r image.Rectangle) *image.RGBA {
+	r = r.Intersect(s.Bounds())
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	if r.Empty() {
+		return dst
+	}
+
+	switch s.quality {
+	case ScaleBilinear:
+		s.scaleBilinear(src, dst, r)
+	case ScaleNearest:
+		s.scaleNearest(src, dst, r)
+	default:
+		s.scaleArea(src, dst, r)
+	}
+	return dst
+}
+
+// scaleArea sums the covered source rows into per-column totals, then
+// averages the covered columns for each output pixel.
+func (s *scaler) scaleArea(src, dst *image.RGBA, r image.Rectangle) {
+	sx0, sx1 := s.xIdx[r.Min.X], s.xIdx[r.Max.X]
+	sums := make([]uint32, (sx1-sx0)*4)
+
+	for dy := r.Min.Y; dy < r.Max.Y; dy++ {
+		y0, y1 := s.yIdx[dy], s.yIdx[dy+1]
+
+		clear(sums)
+		for y := y0; y < y1; y++ {
+			row := src.Pix[src.PixOffset(sx0, y):]
+			row = row[:len(sums)]
+			for i, v := range row {
+				sums[i] += uint32(v)
+			}
+		}
+
+		rows := uint32(y1 - y0)
+		out := dst.Pix[(dy-r.Min.Y)*dst.Stride:]
+		for dx := r.Min.X; dx < r.Max.X; dx++ {
+			x0, x1 := (s.xIdx[dx]-sx0)*4, (s.xIdx[dx+1]-sx0)*4
+
+			var cr, cg, cb, ca uint32
+			for i := x0; i < x1; i += 4 {
+				cr += sums[i]
+				cg += sums[i+1]
+				cb += sums[i+2]
+				ca += sums[i+3]
+			}
+
+			n := rows * uint32((x1-x0)/4)
+			o := (dx - r.Min.X) * 4
+			out[o] = uint8((cr + n/2) / n)
+			out[o+1] = uint8((cg + n/2) / n)
+			out[o+2] = uint8((cb + n/2) / n)
+			out[o+3] = uint8((ca + n/2) / n)
+		}
+	}
+}
+
+func (s *scaler) scaleBilinear(src, dst *image.RGBA, r image.Rectangle) {
+	for dy := r.Min.Y; dy < r.Max.Y; dy++ {
+		y0 := s.yIdx[dy]
+		y1 := y0 + 1
+		if y1 >= s.srcH {
+			y1 = s.srcH - 1
+		}
+		wy := s.yWt[dy]
+
+		row0 := src.Pix[src.PixOffset(0, y0):]
+		row1 := src.Pix[src.PixOffset(0, y1):]
+		out := dst.Pix[(dy-r.Min.Y)*dst.Stride:]
+
+		for dx := r.Min.X; dx < r.Max.X; dx++ {
+			x0 := s.xIdx[dx]
+			x1 := x0 + 1
+			if x1 >= s.srcW {
+				x1 = s.srcW - 1
+			}
+			wx := s.xWt[dx]
+
+			p0, p1 := x0*4, x1*4
+			o := (dx - r.Min.X) * 4
+			for c := 0; c < 4; c++ {
+				top := uint32(row0[p0+c])*(256-wx) + uint32(row0[p1+c])*wx
+				bottom := uint32(row1[p0+c])*(256-wx) + uint32(row1[p1+c])*wx
+				out[o+c] = uint8((top*(256-wy) + bottom*wy + 1<<15) >> 16)
+			}
+		}
+	}
+}
+
+func (s *scaler) scaleNearest(src, dst *image.RGBA, r image.Rectangle) {
+	for dy := r.Min.Y; dy < r.Max.Y; dy++ {
+		row := src.Pix[src.PixOffset(0, s.yIdx[dy]):]
+		out := dst.Pix[(dy-r.Min.Y)*dst.Stride:]
+		for dx := r.Min.X; dx < r.Max.X; dx++ {
+			p := s.xIdx[dx] * 4
+			o := (dx - r.Min.X) * 4
+			copy(out[o:o+4], row[p:p+4])
+		}
+	}
+}
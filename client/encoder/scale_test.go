@@ -0,0 +1,140 @@
+package encoder
+
+import (
+	"image"
+	"testing"
+)
+
+// testFrame returns a w×h frame with a pattern that varies per pixel and
+// per channel, so any misplaced sample shows.
+func testFrame(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			o := img.PixOffset(x, y)
+			img.Pix[o] = uint8(x * 7)
+			img.Pix[o+1] = uint8(y * 5)
+			img.Pix[o+2] = uint8((x ^ y) * 3)
+			img.Pix[o+3] = 255
+		}
+	}
+	return img
+}
+
+func TestScaleAreaHalvesCheckerboard(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(0)
+			if (x+y)%2 == 1 {
+				v = 200
+			}
+			o := src.PixOffset(x, y)
+			src.Pix[o], src.Pix[o+1], src.Pix[o+2], src.Pix[o+3] = v, v, v, 255
+		}
+	}
+
+	sc := newScaler(64, 32, 32, ScaleArea)
+	dst := sc.Scale(src, sc.Bounds())
+	if got := dst.Bounds().Size(); got != image.Pt(32, 16) {
+		t.Fatalf("size = %v, want 32x16", got)
+	}
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if p := dst.Pix[i : i+4]; p[0] != 100 || p[1] != 100 || p[2] != 100 || p[3] != 255 {
+			t.Fatalf("pixel %d = %v, want the 2x2 average [100 100 100 255]", i/4, p)
+		}
+	}
+}
+
+func TestScaleAreaAveragesFootprint(t *testing.T) {
+	// 3 to 2 columns: output 0 covers source column 0, output 1 covers 1-2
+	src := image.NewRGBA(image.Rect(0, 0, 3, 3))
+	for y := 0; y < 3; y++ {
+		for x, v := range []uint8{10, 20, 41} {
+			o := src.PixOffset(x, y)
+			src.Pix[o], src.Pix[o+1], src.Pix[o+2], src.Pix[o+3] = v, v, v, 255
+		}
+	}
+	sc := newScaler(3, 3, 2, ScaleArea)
+	dst := sc.Scale(src, sc.Bounds())
+	for y := 0; y < dst.Bounds().Dy(); y++ {
+		if got := dst.Pix[dst.PixOffset(0, y)]; got != 10 {
+			t.Errorf("(0,%d) = %d, want 10", y, got)
+		}
+		// (20+41)/2 rounds to 31
+		if got := dst.Pix[dst.PixOffset(1, y)]; got != 31 {
+			t.Errorf("(1,%d) = %d, want 31", y, got)
+		}
+	}
+
+	// A non-integer ratio on a varied frame matches a plain average over
+	// each output pixel's footprint
+	src = testFrame(1000, 563)
+	sc = newScaler(1000, 563, 360, ScaleArea)
+	dst = sc.Scale(src, sc.Bounds())
+	for dy := 0; dy < sc.dstH; dy++ {
+		for dx := 0; dx < sc.dstW; dx++ {
+			for c := 0; c < 4; c++ {
+				var sum, n int
+				for y := sc.yIdx[dy]; y < sc.yIdx[dy+1]; y++ {
+					for x := sc.xIdx[dx]; x < sc.xIdx[dx+1]; x++ {
+						sum += int(src.Pix[src.PixOffset(x, y)+c])
+						n++
+					}
+				}
+				want := uint8((sum + n/2) / n)
+				if got := dst.Pix[dst.PixOffset(dx, dy)+c]; got != want {
+					t.Fatalf("(%d,%d) channel %d = %d, want %d", dx, dy, c, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestScaleRegionMatchesFullFrame(t *testing.T) {
+	src := testFrame(1366, 768)
+	for _, quality := range []ScaleQuality{ScaleArea, ScaleBilinear, ScaleNearest} {
+		sc := newScaler(1366, 768, 720, quality)
+		full := sc.Scale(src, sc.Bounds())
+
+		r := image.Rect(104, 40, 352, 200)
+		tile := sc.Scale(src, r)
+		for y := 0; y < r.Dy(); y++ {
+			got := tile.Pix[y*tile.Stride : y*tile.Stride+r.Dx()*4]
+			want := full.Pix[full.PixOffset(r.Min.X, r.Min.Y+y):][:r.Dx()*4]
+			if string(got) != string(want) {
+				t.Fatalf("quality %d: row %d of the region differs from the full frame", quality, y)
+			}
+		}
+	}
+}
+
+var benchSizes = []struct {
+	name string
+	w, h int
+}{
+	{"1080p", 1920, 1080},
+	{"4K", 3840, 2160},
+}
+
+func benchmarkScale(b *testing.B, quality ScaleQuality) {
+	for _, size := range benchSizes {
+		b.Run(size.name, func(b *testing.B) {
+			src := testFrame(size.w, size.h)
+			sc := newScaler(size.w, size.h, 720, quality)
+			b.SetBytes(int64(len(src.Pix)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sc.Scale(src, sc.Bounds())
+			}
+		})
+	}
+}
+
+func BenchmarkScaleArea(b *testing.B) {
+	benchmarkScale(b, ScaleArea)
+}
+
+func BenchmarkScaleBilinear(b *testing.B) {
+	benchmarkScale(b, ScaleBilinear)
+}
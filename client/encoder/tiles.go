@@ -0,0 +1,121 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"sync"
+	"sync/atomic"
+
+	"github.com/exam-gaurd/client/capture"
+	"github.com/exam-gaurd/protocol"
+)
+
+// stripAlign keeps keyframe strip boundaries on JPEG MCU rows (16 lines
+// with 4:2:0 chroma subsampling) so strips join without visible seams.
+const stripAlign = 16
+
+// encodedTile is one compressed tile. data is nil if encoding failed.
+type encodedTile struct {
+	codec byte
+	data  []byte
+}
+
+// encodeTiles renders and encodes each region of the output frame on a
+// bounded pool of workers. Results are returned in input order so the
+// output is identical to serial encoding.
+func (e *Encoder) encodeTiles(frame *capture.Frame, sc *scaler, regions []image.Rectangle, quality int) []encodedTile {
+	results := make([]encodedTile, len(regions))
+
+	workers := e.workers
+	if workers > len(regions) {
+		workers = len(regions)
+	}
+	if workers <= 1 {
+		for i, r := range regions {
+			results[i] = e.encodeTile(frame, sc, r, quality)
+		}
+		return results
+	}
+
+	var next atomic.Int32
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(regions) {
+					return
+				}
+				results[i] = e.encodeTile(frame, sc, regions[i], quality)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// encodeTile renders one output region and encodes it as JPEG, or with
+// the codec its colour count suggests when lossless tiles are enabled.
+func (e *Encoder) encodeTile(frame *capture.Frame, sc *scaler, r image.Rectangle, quality int) encodedTile {
+	var img *image.RGBA
+	if sc != nil {
+		img = sc.Scale(e.frameToImage(frame), r)
+	} else {
+		img = e.extractRect(frame, capture.DirtyRect{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy()})
+		if img == nil {
+			return encodedTile{}
+		}
+	}
+
+	var codec Codec = jpegCodec{quality: quality}
+	if e.useLossless() {
+		codec = chooseCodec(img, quality)
+	}
+
+	tileBuf := e.jpegBufPool.Get().(*bytes.Buffer)
+	defer e.jpegBufPool.Put(tileBuf)
+	tileBuf.Reset()
+
+	if err := codec.Encode(tileBuf, img); err != nil {
+		return encodedTile{}
+	}
+
+	data := make([]byte, tileBuf.Len())
+	copy(data, tileBuf.Bytes())
+	return encodedTile{codec: codec.ID(), data: data}
+}
+
+// writeTiles appends tile records and returns how many were written.
+// Tile: [x:2][y:2][w:2][h:2][len:4][JPEG], or with withCodec
+// [x:2][y:2][w:2][h:2][codec:1][len:4][data]
+func writeTiles(buf *bytes.Buffer, regions []image.Rectangle, tiles []encodedTile, withCodec bool) uint16 {
+	count := uint16(0)
+	for i, tile := range tiles {
+		if tile.data == nil {
+			continue
+		}
+		r := regions[i]
+		protocol.WriteTile(buf, protocol.Tile{
+			X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy(),
+			Codec: tile.codec,
+			Data:  tile.data,
+		}, withCodec)
+		count++
+	}
+	return count
+}
+
+// keyFrameStrips splits an output frame into horizontal strips.
+func keyFrameStrips(bounds image.Rectangle, n int) []image.Rectangle {
+	h := (bounds.Dy() + n - 1) / n
+	h = (h + stripAlign - 1) / stripAlign * stripAlign
+
+	var strips []image.Rectangle
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += h {
+		strips = append(strips, image.Rect(bounds.Min.X, y, bounds.Max.X, min(y+h, bounds.Max.Y)))
+	}
+	return strips
+}
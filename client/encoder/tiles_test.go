@@ -0,0 +1,144 @@
+package encoder
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/exam-gaurd/client/capture"
+	"github.com/exam-gaurd/protocol"
+)
+
+// captureFrame wraps an image as a captured frame.
+func captureFrame(img *image.RGBA) *capture.Frame {
+	return &capture.Frame{Pix: img.Pix, W: img.Rect.Dx(), H: img.Rect.Dy(), Stride: img.Stride}
+}
+
+// scatteredRects returns n dirty rects spread over a w×h frame.
+func scatteredRects(w, h, n int) []capture.DirtyRect {
+	rects := make([]capture.DirtyRect, n)
+	for i := range rects {
+		rects[i] = capture.DirtyRect{X: i * 211 % (w - 120), Y: i * 127 % (h - 80), W: 120, H: 80}
+	}
+	return rects
+}
+
+func TestEncodeTilesDeterministic(t *testing.T) {
+	frame := captureFrame(testFrame(1920, 1080))
+	rects := scatteredRects(1920, 1080, 24)
+
+	encode := func(workers, strips int, f *capture.FrameWithDirty) []byte {
+		config := DefaultConfig()
+		config.Workers = workers
+		config.KeyFrameStrips = strips
+		enc := NewEncoder(config)
+		out, err := enc.Encode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.Data
+	}
+
+	for _, f := range []struct {
+		name  string
+		frame *capture.FrameWithDirty
+	}{
+		{"keyframe", &capture.FrameWithDirty{Frame: frame, IsKeyFrame: true}},
+		{"dirty", &capture.FrameWithDirty{Frame: frame, DirtyRects: rects}},
+	} {
+		t.Run(f.name, func(t *testing.T) {
+			serial := encode(1, 8, f.frame)
+			for run := 0; run < 10; run++ {
+				if pooled := encode(4, 8, f.frame); !bytes.Equal(pooled, serial) {
+					t.Fatalf("run %d: pooled output differs from serial", run)
+				}
+			}
+
+			decoded, err := protocol.DecodeFrame(serial)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i < len(decoded.Tiles); i++ {
+				a, b := decoded.Tiles[i-1], decoded.Tiles[i]
+				if f.frame.IsKeyFrame && b.Y <= a.Y {
+					t.Fatalf("strip %d at y=%d follows y=%d", i, b.Y, a.Y)
+				}
+			}
+		})
+	}
+
+	// Tiles are written in the order of the rects they map to
+	config := DefaultConfig()
+	config.Workers = 4
+	enc := NewEncoder(config)
+	sc := enc.scalerFor(frame)
+	regions := make([]image.Rectangle, len(rects))
+	for i, r := range rects {
+		regions[i] = sc.mapRect(r)
+	}
+	out, err := enc.Encode(&capture.FrameWithDirty{Frame: frame, DirtyRects: rects})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := protocol.DecodeFrame(out.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Tiles) != len(regions) {
+		t.Fatalf("got %d tiles, want %d", len(decoded.Tiles), len(regions))
+	}
+	for i, tile := range decoded.Tiles {
+		if got := image.Rect(tile.X, tile.Y, tile.X+tile.W, tile.Y+tile.H); got != regions[i] {
+			t.Errorf("tile %d covers %v, want %v", i, got, regions[i])
+		}
+	}
+}
+
+func BenchmarkEncodeTiles(b *testing.B) {
+	frame := captureFrame(testFrame(1920, 1080))
+	dirty := &capture.FrameWithDirty{Frame: frame, DirtyRects: scatteredRects(1920, 1080, 16)}
+
+	for _, workers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			config := DefaultConfig()
+			config.Workers = workers
+			enc := NewEncoder(config)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := enc.Encode(dirty); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeKeyFrame(b *testing.B) {
+	frame := captureFrame(testFrame(1920, 1080))
+	key := &capture.FrameWithDirty{Frame: frame, IsKeyFrame: true}
+
+	for _, c := range []struct {
+		name    string
+		workers int
+		strips  int
+	}{
+		{"single", 1, 0},
+		{"strips=4/serial", 1, 4},
+		{"strips=4/workers=4", 4, 4},
+		{"strips=8/workers=4", 4, 8},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			config := DefaultConfig()
+			config.Workers = c.workers
+			config.KeyFrameStrips = c.strips
+			enc := NewEncoder(config)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := enc.Encode(key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
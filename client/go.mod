@@ -2,12 +2,15 @@ module github.com/exam-gaurd/client
 
 go 1.24.0
 
-require gioui.org v0.8.0
+require (
+	gioui.org v0.8.0
+	github.com/exam-gaurd/protocol v0.0.0
+	github.com/godbus/dbus/v5 v5.2.2
+	github.com/jezek/xgb v1.1.1
+)
 
 require (
 	github.com/gen2brain/shm v0.1.0 // indirect
-	github.com/godbus/dbus/v5 v5.2.2 // indirect
-	github.com/jezek/xgb v1.1.1 // indirect
 	github.com/lxn/win v0.0.0-20210218163916-a377121e959e // indirect
 )
 
@@ -22,3 +25,5 @@ require (
 	golang.org/x/sys v0.27.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 )
+
+replace github.com/exam-gaurd/protocol => ../protocol
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// Resource budgets for streaming, so the client doesn't compete with the
+// exam software on slow machines. Both can be set through the environment.
+const (
+	CPU_BUDGET_ENV    = "EXAM_GUARD_CPU_BUDGET" // Percent of one core
+	MEMORY_BUDGET_ENV = "EXAM_GUARD_MEM_BUDGET" // Megabytes of Go heap
+
+	DEFAULT_CPU_BUDGET    = 20
+	DEFAULT_MEMORY_BUDGET = 256
+
+	GOVERNOR_WINDOW       = 5 * time.Second // How long usage is measured before the level changes
+	GOVERNOR_CALM_WINDOWS = 3               // Windows well under budget before throttling eases
+)
+
+// throttleLevel is one step of self-throttling.
+type throttleLevel struct {
+	interval  time.Duration // Time between frames
+	maxWidth  int           // Encoder output width
+	dirtyOnly bool          // No periodic keyframes; only changes are sent
+}
+
+// throttleLevels go from full speed to the least the teacher can still
+// follow a screen with.
+var throttleLevels = []throttleLevel{
+	{interval: UPDATE_INTERVAL, maxWidth: 720},
+	{interval: UPDATE_INTERVAL * 2, maxWidth: 720},
+	{interval: UPDATE_INTERVAL * 2, maxWidth: 480},
+	{interval: UPDATE_INTERVAL * 3, maxWidth: 480, dirtyOnly: true},
+}
+
+// governor measures the time the client spends capturing, encoding and
+// sending frames, and its heap, over GOVERNOR_WINDOW. It moves one throttle
+// level up when either is over budget, and one level down after
+// GOVERNOR_CALM_WINDOWS windows at less than half the budget. Time blocked
+// on a slow network counts too; sending less relieves it as well.
+type governor struct {
+	cpuBudget float64 // Share of one core
+	memBudget uint64  // Heap bytes
+
+	level int
+	calm  int
+
+	windowStart time.Time
+	busy        atomic.Int64 // Nanoseconds spent on frames this window
+	frames      int
+
+	heap func() uint64 // Heap in use, replaced in tests
+
+	last protocol.ThrottleReport
+}
+
+func newGovernor() *governor {
+	g := &governor{
+		cpuBudget:   float64(envInt(CPU_BUDGET_ENV, DEFAULT_CPU_BUDGET)) / 100,
+		memBudget:   uint64(envInt(MEMORY_BUDGET_ENV, DEFAULT_MEMORY_BUDGET)) << 20,
+		windowStart: time.Now(),
+		heap:        heapAlloc,
+	}
+	g.describe(0, g.heap(), 0)
+	return g
+}
+
+// startGovernor creates the governor of a stream and reports the level it
+// starts at, so the server doesn't keep the one of an earlier connection.
+func (client *Client) startGovernor() *governor {
+	g := newGovernor()
+	client.SendReport(protocol.MsgThrottle, g.report())
+	return g
+}
+
+// envInt reads a positive integer from the environment.
+func envInt(name string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+func heapAlloc() uint64 {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return mem.HeapAlloc
+}
+
+// spend counts time spent capturing, encoding or sending a frame. Unlike
+// the other methods it may be called from any goroutine.
+func (g *governor) spend(d time.Duration) {
+	g.busy.Add(int64(d))
+}
+
+// frame counts a frame sent.
+func (g *governor) frame() {
+	g.frames++
+}
+
+// current returns the throttle level in effect.
+func (g *governor) current() throttleLevel {
+	return throttleLevels[g.level]
+}
+
+// update measures usage once a window has passed by now and returns true
+// if the level changed, in which case report describes the new one.
+func (g *governor) update(now time.Time) bool {
+	wall := now.Sub(g.windowStart)
+	if wall < GOVERNOR_WINDOW {
+		return false
+	}
+	busy := time.Duration(g.busy.Swap(0))
+	frames := g.frames
+	g.windowStart = now
+	g.frames = 0
+	heap := g.heap()
+
+	share := busy.Seconds() / wall.Seconds()
+	over := share > g.cpuBudget || heap > g.memBudget
+	calm := share < g.cpuBudget/2 && heap < g.memBudget/2
+
+	level := g.level
+	switch {
+	case over:
+		g.calm = 0
+		if level < len(throttleLevels)-1 {
+			level++
+		}
+	case calm:
+		g.calm++
+		if g.calm >= GOVERNOR_CALM_WINDOWS && level > 0 {
+			g.calm = 0
+			level--
+		}
+	default:
+		g.calm = 0
+	}
+	if level == g.level {
+		return false
+	}
+	g.level = level
+
+	frameTime := time.Duration(0)
+	if frames > 0 {
+		frameTime = busy / time.Duration(frames)
+	}
+	g.describe(share, heap, frameTime)
+	return true
+}
+
+// describe sets the report of the current level and the usage that led to
+// it.
+func (g *governor) describe(share float64, heap uint64, frameTime time.Duration) {
+	t := throttleLevels[g.level]
+	g.last = protocol.ThrottleReport{
+		Level:      g.level,
+		MaxLevel:   len(throttleLevels) - 1,
+		FPS:        math.Round(float64(time.Second)/float64(t.interval)*10) / 10,
+		MaxWidth:   t.maxWidth,
+		DirtyOnly:  t.dirtyOnly,
+		CPUPercent: share * 100,
+		FrameCPUMs: float64(frameTime.Microseconds()) / 1000,
+		HeapMB:     int(heap >> 20),
+	}
+}
+
+// report describes the level in effect: the first, or the one set by the
+// last update that changed it.
+func (g *governor) report() protocol.ThrottleReport {
+	return g.last
+}
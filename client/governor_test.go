@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+func TestGovernorLevels(t *testing.T) {
+	// A window measures a share of one core, against a budget of 20%, and
+	// a heap, against 256 MB
+	type window struct {
+		cpu   float64
+		heap  uint64
+		level int
+	}
+	const mb = 1 << 20
+	tests := []struct {
+		name    string
+		windows []window
+	}{
+		{"idle stays at full speed", []window{
+			{0.01, 10 * mb, 0},
+			{0.05, 10 * mb, 0},
+		}},
+		{"over CPU steps up to the last level", []window{
+			{0.25, 10 * mb, 1},
+			{0.30, 10 * mb, 2},
+			{0.50, 10 * mb, 3},
+			{0.50, 10 * mb, 3},
+		}},
+		{"over memory steps up", []window{
+			{0.01, 300 * mb, 1},
+			{0.01, 300 * mb, 2},
+		}},
+		{"under budget but not calm holds", []window{
+			{0.25, 10 * mb, 1},
+			{0.15, 10 * mb, 1},
+			{0.01, 200 * mb, 1},
+			{0.15, 10 * mb, 1},
+		}},
+		{"calm windows step down one at a time", []window{
+			{0.25, 10 * mb, 1},
+			{0.25, 10 * mb, 2},
+			{0.05, 10 * mb, 2},
+			{0.05, 10 * mb, 2},
+			{0.05, 10 * mb, 1},
+			{0.05, 10 * mb, 1},
+			{0.05, 10 * mb, 1},
+			{0.05, 10 * mb, 0},
+		}},
+		{"a busy window restarts the calm count", []window{
+			{0.25, 10 * mb, 1},
+			{0.05, 10 * mb, 1},
+			{0.05, 10 * mb, 1},
+			{0.15, 10 * mb, 1},
+			{0.05, 10 * mb, 1},
+			{0.05, 10 * mb, 1},
+			{0.05, 10 * mb, 0},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(CPU_BUDGET_ENV, "20")
+			t.Setenv(MEMORY_BUDGET_ENV, "256")
+			g := newGovernor()
+			var heap uint64
+			g.heap = func() uint64 { return heap }
+			now := g.windowStart
+
+			for i, w := range tt.windows {
+				before := g.level
+				g.spend(time.Duration(w.cpu * float64(GOVERNOR_WINDOW)))
+				heap = w.heap
+				now = now.Add(GOVERNOR_WINDOW)
+				if changed := g.update(now); changed != (w.level != before) || g.level != w.level {
+					t.Fatalf("window %d: level %d, changed %v; want %d", i, g.level, changed, w.level)
+				}
+				if report := g.report(); report.Level != g.level || report.MaxWidth != g.current().maxWidth {
+					t.Errorf("window %d: report %+v at level %d", i, report, g.level)
+				}
+			}
+		})
+	}
+}
+
+func TestGovernorWindow(t *testing.T) {
+	t.Setenv(CPU_BUDGET_ENV, "20")
+	g := newGovernor()
+	g.heap = func() uint64 { return 0 }
+	start := g.windowStart
+
+	// Time spent is kept until the window is over
+	g.spend(600 * time.Millisecond)
+	if g.update(start.Add(GOVERNOR_WINDOW - time.Millisecond)) {
+		t.Fatal("level changed before the window was over")
+	}
+	go g.spend(600 * time.Millisecond) // The send worker spends from its goroutine
+	for g.busy.Load() < int64(1200*time.Millisecond) {
+		time.Sleep(time.Millisecond)
+	}
+	g.frame()
+	g.frame()
+	g.frame()
+	if !g.update(start.Add(GOVERNOR_WINDOW)) {
+		t.Fatal("24% of a core did not throttle")
+	}
+
+	want := protocol.ThrottleReport{Level: 1, MaxLevel: 3, FPS: 3, MaxWidth: 720, CPUPercent: 24, FrameCPUMs: 400}
+	if report := g.report(); report != want {
+		t.Errorf("report %+v, want %+v", report, want)
+	}
+	if g.busy.Load() != 0 || g.frames != 0 {
+		t.Error("the next window does not start empty")
+	}
+}
+
+func TestStartGovernor(t *testing.T) {
+	client, server := connectedClient(t)
+	g := client.startGovernor()
+
+	var report protocol.ThrottleReport
+	readMessage(t, server, protocol.MsgThrottle, &report)
+	if report.Level != 0 || report.MaxLevel != len(throttleLevels)-1 || report.FPS != 6 || report.MaxWidth != 720 || report.DirtyOnly {
+		t.Errorf("initial report %+v", report)
+	}
+	if g.current() != throttleLevels[0] {
+		t.Errorf("starts at %+v", g.current())
+	}
+}
@@ -0,0 +1,20 @@
+// Package xtrap catches Xlib errors around requests that may fail, for
+// the packages that talk to X11 through cgo.
+//
+// Xlib has one error handler per process, and the default one exits on
+// any error. A package that swaps it for a request of its own races every
+// other package doing the same, so they all trap through this one, which
+// serialises the traps and hands errors on other connections to the
+// handler that was installed before. From C:
+//
+//	#cgo CFLAGS: -I${SRCDIR}/../internal/xtrap
+//	#include "xtrap.h"
+//
+//	xtrap_begin(display);
+//	XGetWindowProperty(display, ...);
+//	if (xtrap_end(display)) { ... the request failed ... }
+//
+// and import the package for its C code:
+//
+//	import _ "github.com/exam-gaurd/client/internal/xtrap"
+package xtrap
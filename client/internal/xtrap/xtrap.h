@@ -0,0 +1,14 @@
+#ifndef XTRAP_H
+#define XTRAP_H
+
+#include <X11/Xlib.h>
+
+// Start catching errors on display. Traps don't nest, and other threads'
+// traps wait until this one ends.
+void xtrap_begin(Display *display);
+
+// End the trap started on display. The server reports errors
+// asynchronously, so this syncs first. Returns 1 if an error was caught.
+int xtrap_end(Display *display);
+
+#endif
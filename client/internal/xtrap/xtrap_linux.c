@@ -0,0 +1,34 @@
+//go:build linux && cgo
+
+#include <pthread.h>
+#include "xtrap.h"
+
+static pthread_mutex_t xtrap_lock = PTHREAD_MUTEX_INITIALIZER;
+static Display *xtrap_display;
+static int xtrap_caught;
+static int (*xtrap_saved_handler)(Display*, XErrorEvent*);
+
+static int xtrap_handler(Display *display, XErrorEvent *event) {
+    if (display == xtrap_display) {
+        xtrap_caught = 1;
+        return 0;
+    }
+    // Another connection's error, e.g. the UI's, is left to its handler
+    return xtrap_saved_handler ? xtrap_saved_handler(display, event) : 0;
+}
+
+void xtrap_begin(Display *display) {
+    pthread_mutex_lock(&xtrap_lock);
+    xtrap_display = display;
+    xtrap_caught = 0;
+    xtrap_saved_handler = XSetErrorHandler(xtrap_handler);
+}
+
+int xtrap_end(Display *display) {
+    XSync(display, False);
+    XSetErrorHandler(xtrap_saved_handler);
+    int caught = xtrap_caught;
+    xtrap_display = NULL;
+    pthread_mutex_unlock(&xtrap_lock);
+    return caught;
+}
@@ -0,0 +1,8 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package xtrap
+
+// #cgo LDFLAGS: -lX11
+// #include "xtrap.h"
+import "C"
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"runtime"
+
+	"github.com/exam-gaurd/protocol"
+	"github.com/kbinani/screenshot"
+)
+
+// CLIENT_VERSION is reported to the server when joining.
+const CLIENT_VERSION = "1.0.0"
+
+// collectMachineReport gathers the machine report for the given capture
+// backend, empty while it is not known. The server uses it to spot a
+// student switching machines mid-exam.
+func collectMachineReport(backend string) protocol.MachineReport {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	report := protocol.MachineReport{
+		Hostname:  hostname,
+		OS:        runtime.GOOS + "/" + runtime.GOARCH,
+		MachineID: machineID(),
+		Backend:   backend,
+		Version:   CLIENT_VERSION,
+	}
+
+	for i := 0; i < screenshot.NumActiveDisplays(); i++ {
+		bounds := screenshot.GetDisplayBounds(i)
+		report.Displays = append(report.Displays, protocol.DisplayInfo{W: bounds.Dx(), H: bounds.Dy()})
+	}
+
+	return report
+}
+
+// machineID identifies the machine apart from its hostname, which cloned
+// lab machines share. The OS identifier is hashed so the server never sees
+// it, only whether it changed.
+func machineID() string {
+	raw := rawMachineID()
+	if raw == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte("exam-guard:" + raw))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,23 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"os/exec"
+	"regexp"
+)
+
+var platformUUID = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// rawMachineID returns the hardware UUID of the Mac.
+func rawMachineID() string {
+	out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return ""
+	}
+	if m := platformUUID.FindSubmatch(out); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
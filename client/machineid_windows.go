@@ -0,0 +1,29 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// rawMachineID returns the MachineGuid Windows generates at install time.
+func rawMachineID() string {
+	var key syscall.Handle
+	path, _ := syscall.UTF16PtrFromString(`SOFTWARE\Microsoft\Cryptography`)
+	// KEY_WOW64_64KEY: 32-bit builds would otherwise see a redirected key
+	if err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, path, 0, syscall.KEY_READ|0x0100, &key); err != nil {
+		return ""
+	}
+	defer syscall.RegCloseKey(key)
+
+	name, _ := syscall.UTF16PtrFromString("MachineGuid")
+	buf := make([]uint16, 64)
+	size := uint32(len(buf) * 2)
+	var typ uint32
+	if err := syscall.RegQueryValueEx(key, name, nil, &typ, (*byte)(unsafe.Pointer(&buf[0])), &size); err != nil || typ != syscall.REG_SZ {
+		return ""
+	}
+	return syscall.UTF16ToString(buf)
+}
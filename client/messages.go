@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/exam-gaurd/client/activity"
+	"github.com/exam-gaurd/client/capture"
+	"github.com/exam-gaurd/protocol"
+)
+
+// SendReport sends a typed JSON message over the MESSAGE channel.
+func (client *Client) SendReport(msgType string, data interface{}) error {
+	payload, err := protocol.EncodeMessage(msgType, data)
+	if err != nil {
+		return err
+	}
+	if client.socket != nil {
+		return client.sendData(protocol.PacketMessage, payload)
+	}
+	return nil
+}
+
+// sendHello offers the newest protocol version this client speaks. Until
+// the server answers, the client sticks to Version1 so old servers that
+// ignore the hello keep working.
+func (client *Client) sendHello() error {
+	client.protocolVersion.Store(protocol.Version1)
+	return client.SendReport(protocol.MsgHello, protocol.Hello{Version: protocol.Version})
+}
+
+// eventReport converts a desktop event for the wire.
+func eventReport(ev activity.Event) protocol.EventReport {
+	return protocol.EventReport{
+		Kind:   ev.Kind,
+		Format: ev.Format,
+		Size:   ev.Size,
+		Detail: ev.Detail,
+		Time:   time.Now(),
+	}
+}
+
+// runReader handles messages pushed by the server until the connection closes.
+func (client *Client) runReader(socket *net.TCPConn) {
+	var buf []byte
+	for {
+		dataType, payload, err := protocol.ReadPacket(socket, buf, protocol.MaxServerMessage)
+		if err != nil {
+			return
+		}
+		buf = payload
+
+		if dataType == protocol.PacketMessage {
+			client.handleServerMessage(payload)
+		}
+	}
+}
+
+func (client *Client) handleServerMessage(data []byte) {
+	env, err := protocol.DecodeMessage(data)
+	if err != nil {
+		return
+	}
+
+	switch env.Type {
+	case protocol.MsgHello:
+		var hello protocol.Hello
+		if err := env.Decode(&hello); err != nil {
+			return
+		}
+		client.protocolVersion.Store(int32(protocol.Negotiate(protocol.Version, hello.Version)))
+	case protocol.MsgKeyFrame:
+		client.forceKeyFrame.Store(true)
+	case protocol.MsgCapture:
+		var target protocol.CaptureTarget
+		if err := env.Decode(&target); err != nil {
+			return
+		}
+		// Applied by the streaming loop, which owns the capturer
+		client.pendingTarget.Store(&target)
+	case protocol.MsgPolicy:
+		var policy protocol.Policy
+		if err := env.Decode(&policy); err != nil {
+			return
+		}
+		p := activity.Policy(policy)
+		client.policy.Store(&p)
+	}
+}
+
+// applyCaptureTarget switches the capturer to the target the server asked
+// for and tells the server which target is in use afterwards.
+func (client *Client) applyCaptureTarget(req protocol.CaptureTarget) {
+	reply := req
+	reply.Error = ""
+
+	target, err := captureTarget(req)
+	if err == nil {
+		err = client.capturer.SetTarget(target)
+	}
+	if err == nil {
+		client.target = target
+	} else {
+		reply = targetReport(client.target)
+		reply.Error = err.Error()
+	}
+	client.SendReport(protocol.MsgCapture, reply)
+}
+
+// captureTarget converts a capture target from the wire.
+func captureTarget(t protocol.CaptureTarget) (capture.Target, error) {
+	switch t.Mode {
+	case protocol.CaptureDisplay, "":
+		return capture.Target{}, nil
+	case protocol.CaptureWindow:
+		return capture.Target{Kind: capture.TargetWindow, Title: t.Title, Class: t.Class}, nil
+	case protocol.CaptureRegion:
+		region := capture.DirtyRect{X: t.X, Y: t.Y, W: t.W, H: t.H}
+		return capture.Target{Kind: capture.TargetRegion, Region: region}, nil
+	}
+	return capture.Target{}, fmt.Errorf("unknown capture mode %q", t.Mode)
+}
+
+// targetReport converts a capture target for the wire.
+func targetReport(t capture.Target) protocol.CaptureTarget {
+	switch t.Kind {
+	case capture.TargetWindow:
+		return protocol.CaptureTarget{Mode: protocol.CaptureWindow, Title: t.Title, Class: t.Class}
+	case capture.TargetRegion:
+		r := t.Region
+		return protocol.CaptureTarget{Mode: protocol.CaptureRegion, X: r.X, Y: r.Y, W: r.W, H: r.H}
+	}
+	return protocol.CaptureTarget{Mode: protocol.CaptureDisplay}
+}
+
+// captureStatusReporter tells the server which capture backend runs and
+// how healthy it is, whenever that changes.
+type captureStatusReporter struct {
+	last protocol.CaptureStatus
+	sent bool
+}
+
+// update reports the supervisor's status if it changed. It also tells the
+// server when a backend that took over can't capture the target in use.
+func (r *captureStatusReporter) update(client *Client) {
+	if target := client.capturer.Target(); target != client.target {
+		reply := targetReport(target)
+		reply.Error = fmt.Sprintf("%s can't capture %s", client.capturer.Name(), client.target)
+		client.target = target
+		client.SendReport(protocol.MsgCapture, reply)
+	}
+
+	st := client.capturer.Status()
+	status := protocol.CaptureStatus{
+		Backend:  st.Backend,
+		State:    st.State,
+		Error:    st.LastError,
+		Failures: st.Failures,
+	}
+	// Failures alone change on every retry; only send it along with news
+	if r.sent && status.Backend == r.last.Backend && status.State == r.last.State && status.Error == r.last.Error {
+		return
+	}
+	if err := client.SendReport(protocol.MsgCaptureStatus, status); err != nil {
+		return
+	}
+	r.last = status
+	r.sent = true
+}
@@ -0,0 +1,200 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Frame types: the first byte of a PacketPicture payload.
+const (
+	FrameKey            byte = 0x01 // [0x01][JPEG]
+	FrameDirty          byte = 0x02 // [0x02][count:2] then tiles
+	FrameKeyStrips      byte = 0x03 // [0x03][w:2][h:2][count:2] then tiles
+	FrameDirtyCodec     byte = 0x04 // As FrameDirty with a codec byte per tile (Version2)
+	FrameKeyStripsCodec byte = 0x05 // As FrameKeyStrips with a codec byte per tile (Version2)
+
+	// FrameLegacy marks an untyped image payload from old clients.
+	// It is never sent on the wire.
+	FrameLegacy byte = 0x00
+)
+
+// Tile codecs for FrameDirtyCodec and FrameKeyStripsCodec.
+const (
+	CodecJPEG byte = 0x00 // Baseline JPEG
+	CodecPNG  byte = 0x01 // PNG, usually paletted
+	CodecRLE  byte = 0x02 // [ncolors:1][R,G,B]*ncolors then [index:1][run-1:1]...; ncolors 0 means 256
+)
+
+// Tile header sizes.
+const (
+	TileHeaderSize      = 12 // [x:2][y:2][w:2][h:2][len:4]
+	TileCodecHeaderSize = 13 // [x:2][y:2][w:2][h:2][codec:1][len:4]
+)
+
+// Tile is one encoded rectangle of a frame, in output canvas pixels.
+type Tile struct {
+	X, Y, W, H int
+	Codec      byte
+	Data       []byte
+}
+
+// Frame is a decoded PacketPicture payload. Data slices alias the payload.
+type Frame struct {
+	Type byte
+
+	// Width and Height are the canvas size of strip keyframes.
+	Width, Height int
+
+	// Image is the encoded image of a FrameKey or FrameLegacy frame.
+	Image []byte
+
+	// Tiles of dirty and strip frames, in wire order.
+	Tiles []Tile
+}
+
+// IsKeyFrame reports whether the frame replaces the whole canvas.
+func (f *Frame) IsKeyFrame() bool {
+	return f.Type != FrameDirty && f.Type != FrameDirtyCodec
+}
+
+// HasCodec reports whether tiles of frame type t carry a codec byte.
+func HasCodec(t byte) bool {
+	return t == FrameDirtyCodec || t == FrameKeyStripsCodec
+}
+
+// WriteTile appends a tile record to buf, with a codec byte if withCodec.
+func WriteTile(buf *bytes.Buffer, t Tile, withCodec bool) {
+	var header [TileCodecHeaderSize]byte
+	binary.BigEndian.PutUint16(header[0:], uint16(t.X))
+	binary.BigEndian.PutUint16(header[2:], uint16(t.Y))
+	binary.BigEndian.PutUint16(header[4:], uint16(t.W))
+	binary.BigEndian.PutUint16(header[6:], uint16(t.H))
+
+	n := TileHeaderSize
+	if withCodec {
+		header[8] = t.Codec
+		n = TileCodecHeaderSize
+	}
+	binary.BigEndian.PutUint32(header[n-4:], uint32(len(t.Data)))
+
+	buf.Write(header[:n])
+	buf.Write(t.Data)
+}
+
+// AppendFrame appends f as a PacketPicture payload to dst, the reverse of
+// DecodeFrame. Keyframes and legacy frames carry Image, the others Tiles.
+func AppendFrame(dst []byte, f *Frame) []byte {
+	buf := bytes.NewBuffer(dst)
+	switch f.Type {
+	case FrameLegacy:
+		buf.Write(f.Image)
+	case FrameKey:
+		buf.WriteByte(FrameKey)
+		buf.Write(f.Image)
+	default:
+		var header [6]byte
+		n := 0
+		if f.Type == FrameKeyStrips || f.Type == FrameKeyStripsCodec {
+			binary.BigEndian.PutUint16(header[0:], uint16(f.Width))
+			binary.BigEndian.PutUint16(header[2:], uint16(f.Height))
+			n = 4
+		}
+		binary.BigEndian.PutUint16(header[n:], uint16(len(f.Tiles)))
+		buf.WriteByte(f.Type)
+		buf.Write(header[:n+2])
+		for _, t := range f.Tiles {
+			WriteTile(buf, t, HasCodec(f.Type))
+		}
+	}
+	return buf.Bytes()
+}
+
+// DecodeFrame parses a PacketPicture payload. Payloads that do not start
+// with a known frame type are returned as FrameLegacy.
+func DecodeFrame(data []byte) (*Frame, error) {
+	if len(data) == 0 {
+		return nil, ErrTruncated
+	}
+
+	f := &Frame{Type: data[0]}
+	body := data[1:]
+
+	switch f.Type {
+	case FrameKey:
+		f.Image = body
+		return f, nil
+
+	case FrameDirty, FrameDirtyCodec:
+		if len(body) < 2 {
+			return nil, ErrTruncated
+		}
+		count := int(binary.BigEndian.Uint16(body))
+		tiles, err := decodeTiles(body[2:], count, HasCodec(f.Type))
+		if err != nil {
+			return nil, err
+		}
+		f.Tiles = tiles
+		return f, nil
+
+	case FrameKeyStrips, FrameKeyStripsCodec:
+		if len(body) < 6 {
+			return nil, ErrTruncated
+		}
+		f.Width = int(binary.BigEndian.Uint16(body[0:]))
+		f.Height = int(binary.BigEndian.Uint16(body[2:]))
+		count := int(binary.BigEndian.Uint16(body[4:]))
+		tiles, err := decodeTiles(body[6:], count, HasCodec(f.Type))
+		if err != nil {
+			return nil, err
+		}
+		f.Tiles = tiles
+		return f, nil
+
+	default:
+		return &Frame{Type: FrameLegacy, Image: data}, nil
+	}
+}
+
+func decodeTiles(data []byte, count int, withCodec bool) ([]Tile, error) {
+	headerSize := TileHeaderSize
+	if withCodec {
+		headerSize = TileCodecHeaderSize
+	}
+	// Each tile needs at least its header; reject counts the data cannot hold
+	if count*headerSize > len(data) {
+		return nil, ErrTruncated
+	}
+
+	tiles := make([]Tile, 0, count)
+	offset := 0
+	for i := 0; i < count; i++ {
+		if offset+headerSize > len(data) {
+			return nil, ErrTruncated
+		}
+
+		t := Tile{
+			X:     int(binary.BigEndian.Uint16(data[offset:])),
+			Y:     int(binary.BigEndian.Uint16(data[offset+2:])),
+			W:     int(binary.BigEndian.Uint16(data[offset+4:])),
+			H:     int(binary.BigEndian.Uint16(data[offset+6:])),
+			Codec: CodecJPEG,
+		}
+		if withCodec {
+			t.Codec = data[offset+8]
+		}
+		length := int(binary.BigEndian.Uint32(data[offset+headerSize-4:]))
+		offset += headerSize
+
+		if length > len(data)-offset {
+			return nil, ErrTruncated
+		}
+		t.Data = data[offset : offset+length]
+		offset += length
+
+		tiles = append(tiles, t)
+	}
+	if offset != len(data) {
+		return nil, ErrMalformed
+	}
+	return tiles, nil
+}
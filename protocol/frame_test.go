@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+// tiledFrame builds a dirty or strip frame payload of frame type t.
+func tiledFrame(t byte, w, h int, tiles []Tile) []byte {
+	return AppendFrame(nil, &Frame{Type: t, Width: w, Height: h, Tiles: tiles})
+}
+
+// seedFrames are valid payloads of every frame type.
+func seedFrames() [][]byte {
+	tiles := []Tile{
+		{X: 0, Y: 0, W: 64, H: 32, Codec: CodecJPEG, Data: []byte{0xFF, 0xD8, 0xFF, 0xD9}},
+		{X: 64, Y: 16, W: 8, H: 8, Codec: CodecRLE, Data: []byte{1, 10, 20, 30, 0, 63}},
+		{X: 8, Y: 40, W: 16, H: 16, Codec: CodecPNG, Data: []byte("\x89PNG")},
+	}
+	strips := []Tile{
+		{X: 0, Y: 0, W: 720, H: 208, Codec: CodecJPEG, Data: []byte{0xFF, 0xD8}},
+		{X: 0, Y: 208, W: 720, H: 197, Codec: CodecPNG, Data: []byte{0x89}},
+	}
+	return [][]byte{
+		append([]byte{FrameKey}, 0xFF, 0xD8, 0xFF, 0xD9),
+		tiledFrame(FrameDirty, 0, 0, tiles[:1]),
+		tiledFrame(FrameDirtyCodec, 0, 0, tiles),
+		tiledFrame(FrameKeyStrips, 720, 405, strips),
+		tiledFrame(FrameKeyStripsCodec, 720, 405, strips),
+		{0xFF, 0xD8, 0xFF, 0xE0}, // Legacy bare JPEG
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	for _, data := range seedFrames() {
+		f, err := DecodeFrame(data)
+		if err != nil {
+			t.Fatalf("frame type %#x: %v", data[0], err)
+		}
+		if again := AppendFrame(nil, f); !bytes.Equal(again, data) {
+			t.Errorf("frame type %#x does not re-encode to its payload", f.Type)
+		}
+	}
+
+	truncated := tiledFrame(FrameDirty, 0, 0, []Tile{{W: 8, H: 8, Data: []byte{1, 2, 3}}})
+	if _, err := DecodeFrame(truncated[:len(truncated)-1]); err != ErrTruncated {
+		t.Errorf("truncated tile: got %v, want ErrTruncated", err)
+	}
+	if _, err := DecodeFrame(append(truncated, 0)); err != ErrMalformed {
+		t.Errorf("trailing byte: got %v, want ErrMalformed", err)
+	}
+	// A count the payload cannot hold is rejected before allocating
+	if _, err := DecodeFrame([]byte{FrameDirty, 0xFF, 0xFF}); err != ErrTruncated {
+		t.Errorf("huge count: got %v, want ErrTruncated", err)
+	}
+
+	// Appending keeps what dst holds
+	prefix := []byte("packet header")
+	key := &Frame{Type: FrameKey, Image: []byte{0xFF, 0xD8, 0xFF, 0xD9}}
+	if got := AppendFrame(prefix, key); !bytes.Equal(got, append([]byte("packet header"), FrameKey, 0xFF, 0xD8, 0xFF, 0xD9)) {
+		t.Errorf("AppendFrame = %x", got)
+	}
+}
+
+func FuzzDecodeFrame(f *testing.F) {
+	for _, data := range seedFrames() {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := DecodeFrame(data)
+		if err != nil {
+			return
+		}
+		switch frame.Type {
+		case FrameKey:
+			if !bytes.Equal(frame.Image, data[1:]) {
+				t.Fatal("keyframe image is not the payload body")
+			}
+		case FrameLegacy:
+			if !bytes.Equal(frame.Image, data) {
+				t.Fatal("legacy image is not the payload")
+			}
+		default:
+			// A tiled frame that decodes is exactly its tiles
+			if again := AppendFrame(nil, frame); !bytes.Equal(again, data) {
+				t.Fatalf("re-encoded frame differs:\n got %x\nwant %x", again, data)
+			}
+		}
+	})
+}
@@ -0,0 +1,3 @@
+module github.com/exam-gaurd/protocol
+
+go 1.21
@@ -0,0 +1,357 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message types carried in an Envelope over PacketMessage.
+const (
+	// Both directions
+	MsgHello   = "hello"
+	MsgCapture = "capture" // Server sets the capture target; client answers with the one in use
+
+	// Client to server
+	MsgMachine       = "machine"
+	MsgActivity      = "activity"
+	MsgViolation     = "violation"
+	MsgIdle          = "idle"
+	MsgEvent         = "event"
+	MsgCursor        = "cursor"
+	MsgCaptureStatus = "capture_status"
+	MsgBlank         = "blank"
+	MsgThrottle      = "throttle"
+
+	// Server to client
+	MsgPolicy   = "policy"
+	MsgKeyFrame = "keyframe" // Send a keyframe now; no data
+)
+
+// MaxServerMessage bounds the messages a client accepts from the server.
+const MaxServerMessage = 1024 * 1024
+
+// Envelope wraps every structured message so the receiver can dispatch on Type.
+type Envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// EncodeMessage marshals data into an envelope of the given type.
+func EncodeMessage(msgType string, data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{Type: msgType, Data: raw})
+}
+
+// DecodeMessage parses an envelope. Payloads that are not an envelope,
+// such as plain text from old clients, return ErrMalformed.
+func DecodeMessage(payload []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(payload, &env); err != nil || env.Type == "" {
+		return Envelope{}, ErrMalformed
+	}
+	return env, nil
+}
+
+// Decode unmarshals the envelope data into v.
+func (e Envelope) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Hello negotiates the protocol version. The client sends its newest
+// version after joining and the server answers with the version to use.
+type Hello struct {
+	Version int `json:"version"`
+}
+
+// DisplayInfo describes one display attached to a student's machine.
+type DisplayInfo struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// MachineReport is the environment report a client sends when joining,
+// and again once its capture backend is known.
+type MachineReport struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	// MachineID is a hash of the OS's machine identifier, which survives
+	// reboots and renames; empty if the client can't read it.
+	MachineID string        `json:"machine_id,omitempty"`
+	Backend   string        `json:"backend"`
+	Displays  []DisplayInfo `json:"displays"`
+	Version   string        `json:"version"`
+}
+
+// SameMachine reports whether two reports come from the same machine.
+// Hostnames repeat across cloned lab machines, so the machine IDs must
+// match as well; a report without one only matches another without one.
+func (m MachineReport) SameMachine(other MachineReport) bool {
+	return m.Hostname == other.Hostname && m.OS == other.OS && m.MachineID == other.MachineID
+}
+
+// Summary returns a one-line description, e.g. for the viewer header.
+func (m MachineReport) Summary() string {
+	parts := []string{m.Hostname, m.OS}
+	if m.Backend != "" {
+		parts = append(parts, m.Backend)
+	}
+
+	displays := make([]string, 0, len(m.Displays))
+	for _, d := range m.Displays {
+		displays = append(displays, fmt.Sprintf("%d×%d", d.W, d.H))
+	}
+	switch len(displays) {
+	case 0:
+	case 1:
+		parts = append(parts, "1 display "+displays[0])
+	default:
+		parts = append(parts, fmt.Sprintf("%d displays %s", len(displays), strings.Join(displays, ", ")))
+	}
+
+	if m.Version != "" {
+		parts = append(parts, "v"+m.Version)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// Window describes a student's focused top-level window.
+type Window struct {
+	Title   string `json:"title"`
+	Class   string `json:"class"`
+	PID     int    `json:"pid"`
+	Process string `json:"process"`
+}
+
+// App returns the best available application name for the window.
+func (w Window) App() string {
+	if w.Class != "" {
+		return w.Class
+	}
+	return w.Process
+}
+
+// Label returns "App — Title" for display.
+func (w Window) Label() string {
+	app := w.App()
+	switch {
+	case app == "":
+		return w.Title
+	case w.Title == "":
+		return app
+	default:
+		return app + " — " + w.Title
+	}
+}
+
+// Process is one running user process on a student's machine.
+type Process struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+}
+
+// ActivityReport is the periodic foreground window and process report.
+type ActivityReport struct {
+	Foreground Window    `json:"foreground"`
+	Processes  []Process `json:"processes"`
+}
+
+// Policy holds the exam rules pushed by the server after join.
+// All matching is case-insensitive substring matching.
+type Policy struct {
+	BlockedProcesses   []string `json:"blocked_processes"`
+	BlockedTitles      []string `json:"blocked_titles"`
+	AllowedURLKeywords []string `json:"allowed_url_keywords"`
+}
+
+// Violation rule names.
+const (
+	RuleProcess = "process"
+	RuleTitle   = "title"
+	RuleURL     = "url"
+)
+
+// ViolationReport is a policy breach found by the client. The client
+// follows it with a keyframe so the server can attach a screenshot.
+type ViolationReport struct {
+	Rule   string    `json:"rule"`
+	Detail string    `json:"detail"`
+	Time   time.Time `json:"time"`
+}
+
+// Description returns a human readable summary of the violation.
+func (v ViolationReport) Description() string {
+	switch v.Rule {
+	case RuleProcess:
+		return "Blocked process: " + v.Detail
+	case RuleTitle:
+		return "Blocked window: " + v.Detail
+	case RuleURL:
+		return "Site not allowed: " + v.Detail
+	default:
+		return v.Rule + ": " + v.Detail
+	}
+}
+
+// IdleReport marks the start or end of an idle period. While idle, IdleMs is
+// the time since the last input; when input resumes it is the total duration.
+type IdleReport struct {
+	Idle   bool  `json:"idle"`
+	IdleMs int64 `json:"idle_ms"`
+}
+
+// BlankReport marks the start or end of a blank capture: frames of a single
+// colour, as a broken compositor or DRM-protected content gives, as opposed
+// to a screen that is merely not changing. While blank, BlankMs is how long
+// the frames have been blank and Color their colour as #rrggbb; when a
+// frame with content arrives it is the total duration.
+type BlankReport struct {
+	Blank   bool   `json:"blank"`
+	BlankMs int64  `json:"blank_ms"`
+	Color   string `json:"color,omitempty"`
+}
+
+// ThrottleReport is the client's self-throttling level, sent when streaming
+// starts and whenever it changes. Level 0 is full speed and MaxLevel the
+// most throttled. CPUPercent is the time the client spent capturing,
+// encoding and sending frames, as a percentage of one core, over the period
+// that led to the change, FrameCPUMs that time per frame sent and HeapMB
+// its memory.
+type ThrottleReport struct {
+	Level      int     `json:"level"`
+	MaxLevel   int     `json:"max_level"`
+	FPS        float64 `json:"fps"`
+	MaxWidth   int     `json:"max_width"`
+	DirtyOnly  bool    `json:"dirty_only,omitempty"`
+	CPUPercent float64 `json:"cpu_percent"`
+	FrameCPUMs float64 `json:"frame_cpu_ms,omitempty"`
+	HeapMB     int     `json:"heap_mb"`
+}
+
+// MaxCursorSize bounds cursor shapes in either dimension.
+const MaxCursorSize = 256
+
+// CursorShape is a pointer image, PNG encoded, with its hotspot.
+type CursorShape struct {
+	ID   uint32 `json:"id"`
+	HotX int    `json:"hot_x"`
+	HotY int    `json:"hot_y"`
+	PNG  []byte `json:"png"`
+}
+
+// CursorReport is the student's mouse pointer, sent when it moves or
+// changes shape. X and Y are the hotspot in the student's screen pixels,
+// ScreenW×ScreenH, however frames are scaled for sending. Shape is only
+// included when ShapeID changes; ShapeID 0 means the shape is unknown.
+type CursorReport struct {
+	X       int          `json:"x"`
+	Y       int          `json:"y"`
+	ScreenW int          `json:"screen_w"`
+	ScreenH int          `json:"screen_h"`
+	Visible bool         `json:"visible"`
+	ShapeID uint32       `json:"shape_id,omitempty"`
+	Shape   *CursorShape `json:"shape,omitempty"`
+}
+
+// Capture modes.
+const (
+	CaptureDisplay = "display" // The whole screen; also used for an empty mode
+	CaptureWindow  = "window"  // The window matching Title and Class
+	CaptureRegion  = "region"  // The rectangle X, Y, W, H of the screen
+)
+
+// CaptureTarget selects what a client streams. The server sends it to
+// change a student's mode; the client answers with the target it captures
+// afterwards, with Error set if the requested one could not be used.
+// Title matches part of a window title and Class a window class, both
+// ignoring case.
+type CaptureTarget struct {
+	Mode  string `json:"mode"`
+	Title string `json:"title,omitempty"`
+	Class string `json:"class,omitempty"`
+	X     int    `json:"x,omitempty"`
+	Y     int    `json:"y,omitempty"`
+	W     int    `json:"w,omitempty"`
+	H     int    `json:"h,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Capture states.
+const (
+	CaptureRunning  = "running"  // Frames are being captured
+	CaptureRetrying = "retrying" // The backend failed and is being retried or replaced
+	CaptureFailed   = "failed"   // No backend works; the client keeps trying
+)
+
+// CaptureStatus reports the capture backend a client uses and its health,
+// sent when either changes. Error is the last failure, empty while
+// running; Failures counts failures since the client started streaming.
+type CaptureStatus struct {
+	Backend  string `json:"backend"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+	Failures int    `json:"failures,omitempty"`
+}
+
+// Event kinds. Events are coarse and privacy-preserving: clipboard events
+// carry only the content type and size, never the content.
+const (
+	EventClipboard       = "clipboard"
+	EventScreenLocked    = "screen_locked"
+	EventScreenUnlocked  = "screen_unlocked"
+	EventSessionSwitched = "session_switched"
+)
+
+// EventReport is a coarse desktop event.
+type EventReport struct {
+	Kind string `json:"kind"`
+	// Format is the clipboard content type, e.g. "text" or "image/png".
+	Format string `json:"format,omitempty"`
+	// Size is the clipboard content size in bytes, -1 if unknown.
+	Size int64 `json:"size,omitempty"`
+	// Detail is "away" or "back" for session switches.
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Description returns a human readable summary of the event.
+func (e EventReport) Description() string {
+	switch e.Kind {
+	case EventClipboard:
+		text := "Clipboard changed"
+		switch {
+		case e.Format != "" && e.Size > 0:
+			text += fmt.Sprintf(" (%s, %s)", e.Format, formatBytes(e.Size))
+		case e.Format != "":
+			text += " (" + e.Format + ")"
+		}
+		return text
+	case EventScreenLocked:
+		return "Screen locked"
+	case EventScreenUnlocked:
+		return "Screen unlocked"
+	case EventSessionSwitched:
+		if e.Detail == "back" {
+			return "Switched back to session"
+		}
+		return "Switched to another session"
+	default:
+		return e.Kind
+	}
+}
+
+// formatBytes renders a byte count compactly, e.g. "512 B", "1.2 KB".
+func formatBytes(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+}
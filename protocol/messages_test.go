@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	payload, err := EncodeMessage(MsgIdle, IdleReport{Idle: true, IdleMs: 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := DecodeMessage(payload)
+	if err != nil || env.Type != MsgIdle {
+		t.Fatalf("DecodeMessage = %+v, %v", env, err)
+	}
+	var report IdleReport
+	if err := env.Decode(&report); err != nil || !report.Idle || report.IdleMs != 1500 {
+		t.Errorf("Decode = %+v, %v", report, err)
+	}
+
+	for _, bad := range []string{"hello teacher", `{"data":{}}`, `{"type":""}`, `[1,2]`} {
+		if _, err := DecodeMessage([]byte(bad)); err != ErrMalformed {
+			t.Errorf("%q: got %v, want ErrMalformed", bad, err)
+		}
+	}
+}
+
+func TestSameMachine(t *testing.T) {
+	lab := MachineReport{Hostname: "lab-pc", OS: "linux/amd64", MachineID: "a1", Backend: "X11"}
+	tests := []struct {
+		name  string
+		other MachineReport
+		same  bool
+	}{
+		{"identical", lab, true},
+		{"backend and displays", MachineReport{Hostname: "lab-pc", OS: "linux/amd64", MachineID: "a1", Backend: "Screenshot", Displays: []DisplayInfo{{W: 1920, H: 1080}}}, true},
+		{"cloned machine", MachineReport{Hostname: "lab-pc", OS: "linux/amd64", MachineID: "b2"}, false},
+		{"no machine ID", MachineReport{Hostname: "lab-pc", OS: "linux/amd64"}, false},
+		{"renamed", MachineReport{Hostname: "home-pc", OS: "linux/amd64", MachineID: "a1"}, false},
+		{"other OS", MachineReport{Hostname: "lab-pc", OS: "windows/amd64", MachineID: "a1"}, false},
+	}
+	for _, tt := range tests {
+		if got := lab.SameMachine(tt.other); got != tt.same {
+			t.Errorf("%s: SameMachine = %v, want %v", tt.name, got, tt.same)
+		}
+		if got := tt.other.SameMachine(lab); got != tt.same {
+			t.Errorf("%s, reversed: SameMachine = %v, want %v", tt.name, got, tt.same)
+		}
+	}
+
+	old := MachineReport{Hostname: "lab-pc", OS: "linux/amd64"}
+	if !old.SameMachine(old) {
+		t.Error("reports without machine IDs from the same host differ")
+	}
+	if got := old.Summary(); got != "lab-pc · linux/amd64" {
+		t.Errorf("Summary without a backend = %q", got)
+	}
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	seeds := []struct {
+		typ  string
+		data interface{}
+	}{
+		{MsgHello, Hello{Version: Version}},
+		{MsgIdle, IdleReport{Idle: true, IdleMs: 60000}},
+		{MsgBlank, BlankReport{Blank: true, BlankMs: 12000, Color: "#000000"}},
+		{MsgCapture, CaptureTarget{Mode: CaptureRegion, X: 10, Y: 20, W: 640, H: 480}},
+		{MsgThrottle, ThrottleReport{Level: 2, MaxLevel: 3, FPS: 1.5, MaxWidth: 480}},
+		{MsgKeyFrame, nil},
+	}
+	for _, s := range seeds {
+		payload, err := EncodeMessage(s.typ, s.data)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(payload)
+	}
+	f.Add([]byte("plain text from an old client"))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		env, err := DecodeMessage(payload)
+		if err != nil {
+			return
+		}
+		if env.Type == "" {
+			t.Fatal("accepted an envelope without a type")
+		}
+
+		// An envelope survives a round trip through EncodeMessage
+		again, err := EncodeMessage(env.Type, env.Data)
+		if err != nil {
+			t.Fatalf("re-encoding: %v", err)
+		}
+		env2, err := DecodeMessage(again)
+		if err != nil || env2.Type != env.Type {
+			t.Fatalf("round trip: %+v, %v", env2, err)
+		}
+		var a, b interface{}
+		if json.Unmarshal(env.Data, &a) == nil && json.Unmarshal(env2.Data, &b) == nil && !reflect.DeepEqual(a, b) {
+			t.Fatalf("data changed in the round trip: %s became %s", env.Data, env2.Data)
+		}
+
+		// Decoding into any report must fail cleanly, never panic
+		var report ViolationReport
+		env.Decode(&report)
+	})
+}
@@ -0,0 +1,144 @@
+// Package protocol defines the wire protocol shared by the exam client and
+// server: packet framing, frame and tile encodings, and the JSON messages
+// exchanged over the MESSAGE channel.
+//
+// Every packet is an 8-byte header followed by the payload:
+//
+//	[magic "HE":2][type:2][length:4][payload]
+//
+// All integers are big-endian.
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Protocol versions. Peers exchange a Hello message after joining and use
+// the lower of the two versions.
+const (
+	// Version1 is HE framing, FrameKey/FrameDirty frames and JSON messages.
+	Version1 = 1
+	// Version2 adds strip keyframes and per-tile codecs (0x03-0x05).
+	Version2 = 2
+
+	// Version is the newest version this package speaks.
+	Version = Version2
+)
+
+// Negotiate returns the version two peers should use.
+func Negotiate(local, remote int) int {
+	if remote < Version1 {
+		return Version1
+	}
+	if remote < local {
+		return remote
+	}
+	return local
+}
+
+// PacketType identifies the payload of a packet.
+type PacketType uint16
+
+// Packet types.
+const (
+	PacketName    PacketType = 0 // Join: "id###name"
+	PacketMessage PacketType = 1 // JSON envelope, or plain text from old clients
+	PacketPicture PacketType = 2 // Encoded frame
+)
+
+const (
+	// HeaderSize is the size of the packet header.
+	HeaderSize = 8
+
+	// MaxPacketSize bounds the payload a peer will accept.
+	MaxPacketSize = 5 * 1024 * 1024
+)
+
+var magic = [2]byte{'H', 'E'}
+
+// Errors returned by the decoders.
+var (
+	ErrBadHeader = errors.New("protocol: invalid packet header")
+	ErrTooLarge  = errors.New("protocol: packet too large")
+	ErrTruncated = errors.New("protocol: truncated data")
+	ErrMalformed = errors.New("protocol: malformed data")
+)
+
+// PutHeader writes a packet header into b, which must be HeaderSize long.
+func PutHeader(b []byte, t PacketType, length int) {
+	b[0], b[1] = magic[0], magic[1]
+	binary.BigEndian.PutUint16(b[2:], uint16(t))
+	binary.BigEndian.PutUint32(b[4:], uint32(length))
+}
+
+// ParseHeader decodes a packet header.
+func ParseHeader(b []byte) (PacketType, int, error) {
+	if len(b) < HeaderSize || b[0] != magic[0] || b[1] != magic[1] {
+		return 0, 0, ErrBadHeader
+	}
+	t := PacketType(binary.BigEndian.Uint16(b[2:4]))
+	length := int(binary.BigEndian.Uint32(b[4:8]))
+	return t, length, nil
+}
+
+// AppendPacket appends a framed packet to dst.
+func AppendPacket(dst []byte, t PacketType, payload []byte) []byte {
+	var header [HeaderSize]byte
+	PutHeader(header[:], t, len(payload))
+	dst = append(dst, header[:]...)
+	return append(dst, payload...)
+}
+
+// WritePacket writes a framed packet with a single Write call so packets
+// from concurrent writers sharing a lock are never interleaved.
+func WritePacket(w io.Writer, t PacketType, payload []byte) error {
+	_, err := w.Write(AppendPacket(make([]byte, 0, HeaderSize+len(payload)), t, payload))
+	return err
+}
+
+// ReadPacket reads one packet. The payload is read into buf when it has
+// enough capacity, so the result is only valid until the next call.
+// Payloads of zero bytes or more than max bytes are rejected.
+func ReadPacket(r io.Reader, buf []byte, max int) (PacketType, []byte, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	t, length, err := ParseHeader(header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if length <= 0 || length > max {
+		return 0, nil, ErrTooLarge
+	}
+
+	if cap(buf) < length {
+		buf = make([]byte, length)
+	}
+	buf = buf[:length]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	return t, buf, nil
+}
+
+// joinSeparator separates the student id and name in a PacketName payload.
+const joinSeparator = "###"
+
+// JoinPayload builds the PacketName payload.
+func JoinPayload(id, name string) []byte {
+	return []byte(id + joinSeparator + name)
+}
+
+// ParseJoin splits a PacketName payload into the trimmed id and name.
+func ParseJoin(payload []byte) (id, name string, err error) {
+	parts := strings.SplitN(string(payload), joinSeparator, 2)
+	if len(parts) != 2 {
+		return "", "", ErrMalformed
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadPacket(t *testing.T) {
+	var stream []byte
+	stream = AppendPacket(stream, PacketName, JoinPayload("id-1", "Ada"))
+	stream = AppendPacket(stream, PacketPicture, seedFrames()[0])
+
+	r := bytes.NewReader(stream)
+	typ, payload, err := ReadPacket(r, nil, MaxPacketSize)
+	if err != nil || typ != PacketName {
+		t.Fatalf("first packet: %v, %v", typ, err)
+	}
+	if id, name, err := ParseJoin(payload); err != nil || id != "id-1" || name != "Ada" {
+		t.Errorf("join = %q, %q, %v", id, name, err)
+	}
+	if typ, _, err := ReadPacket(r, nil, MaxPacketSize); err != nil || typ != PacketPicture {
+		t.Errorf("second packet: %v, %v", typ, err)
+	}
+	if _, _, err := ReadPacket(r, nil, MaxPacketSize); err != io.EOF {
+		t.Errorf("end of stream: got %v, want io.EOF", err)
+	}
+
+	big := AppendPacket(nil, PacketPicture, make([]byte, 100))
+	if _, _, err := ReadPacket(bytes.NewReader(big), nil, 99); err != ErrTooLarge {
+		t.Errorf("oversized packet: got %v, want ErrTooLarge", err)
+	}
+	bad := append([]byte("XX"), big[2:]...)
+	if _, _, err := ReadPacket(bytes.NewReader(bad), nil, MaxPacketSize); err != ErrBadHeader {
+		t.Errorf("bad magic: got %v, want ErrBadHeader", err)
+	}
+}
+
+func FuzzReadPacket(f *testing.F) {
+	f.Add(AppendPacket(nil, PacketName, JoinPayload("id-1", "Ada")))
+	for _, frame := range seedFrames() {
+		f.Add(AppendPacket(nil, PacketPicture, frame))
+	}
+	msg, _ := EncodeMessage(MsgHello, Hello{Version: Version})
+	f.Add(AppendPacket(AppendPacket(nil, PacketMessage, msg), PacketMessage, msg))
+
+	const max = 4096
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		buf := make([]byte, 0, 64)
+		consumed := 0
+		for {
+			typ, payload, err := ReadPacket(r, buf, max)
+			if err != nil {
+				return
+			}
+			if len(payload) == 0 || len(payload) > max {
+				t.Fatalf("accepted a payload of %d bytes", len(payload))
+			}
+			// The packet reads back as the bytes it was framed from
+			packet := AppendPacket(nil, typ, payload)
+			if !bytes.Equal(packet, data[consumed:consumed+len(packet)]) {
+				t.Fatal("re-framed packet differs from the input")
+			}
+			consumed += len(packet)
+			buf = payload
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"image"
+	"sync"
+)
+
+// Canvas memory limits. Students not open in the viewer are kept at
+// THUMB_WIDTH, so a class of 100 needs about 30 MB of canvases.
+const (
+	THUMB_WIDTH   = 360
+	CANVAS_BUDGET = 256 * 1024 * 1024 // Bytes held by canvases, in use and pooled
+)
+
+// canvasPool recycles student canvases by size. Canvases in use count
+// against the budget; released canvases are kept for reuse only while the
+// total stays within it. Full-resolution canvases are only handed out
+// while the canvases in use fit the budget; thumbnails always are, so every
+// student stays visible. A nil pool allocates every canvas.
+type canvasPool struct {
+	mu     sync.Mutex
+	free   map[image.Point][]*image.RGBA
+	inUse  int
+	pooled int
+	budget int
+}
+
+func newCanvasPool(budget int) *canvasPool {
+	return &canvasPool{
+		free:   make(map[image.Point][]*image.RGBA),
+		budget: budget,
+	}
+}
+
+// Get returns a w×h canvas. Its contents are undefined.
+func (p *canvasPool) Get(w, h int) *image.RGBA {
+	if p == nil {
+		return image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.get(image.Pt(w, h))
+}
+
+// TryGet returns a w×h canvas, or nil if it and spare more canvases of the
+// same size would take the canvases in use over the budget.
+func (p *canvasPool) TryGet(w, h, spare int) *image.RGBA {
+	if p == nil {
+		return image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	size := image.Pt(w, h)
+	if p.inUse+(1+spare)*canvasBytes(size) > p.budget {
+		return nil
+	}
+	return p.get(size)
+}
+
+// get takes a canvas from the pool or allocates one. Must be called with
+// p.mu held.
+func (p *canvasPool) get(size image.Point) *image.RGBA {
+	p.inUse += canvasBytes(size)
+
+	if list := p.free[size]; len(list) > 0 {
+		canvas := list[len(list)-1]
+		p.free[size] = list[:len(list)-1]
+		p.pooled -= canvasBytes(size)
+		return canvas
+	}
+	return image.NewRGBA(image.Rectangle{Max: size})
+}
+
+// Put releases a canvas obtained from Get.
+func (p *canvasPool) Put(canvas *image.RGBA) {
+	if p == nil || canvas == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	size := canvas.Bounds().Size()
+	n := canvasBytes(size)
+	p.inUse -= n
+
+	if p.inUse+p.pooled+n > p.budget {
+		return
+	}
+	p.free[size] = append(p.free[size], canvas)
+	p.pooled += n
+}
+
+// InUse returns the bytes held by canvases handed out and not released.
+func (p *canvasPool) InUse() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inUse
+}
+
+func canvasBytes(size image.Point) int {
+	return size.X * size.Y * 4
+}
+
+// thumbSize returns the canvas size used for a w×h frame that is not
+// shown at full resolution.
+func thumbSize(w, h int) image.Point {
+	if w <= THUMB_WIDTH {
+		return image.Pt(w, h)
+	}
+	th := h * THUMB_WIDTH / w
+	if th < 1 {
+		th = 1
+	}
+	return image.Pt(THUMB_WIDTH, th)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCanvasPoolBudget(t *testing.T) {
+	const w, h = 1280, 720
+	// Each student holds a canvas and two screen buffers
+	full := 3 * canvasBytes(image.Pt(w, h))
+	thumb := 3 * canvasBytes(thumbSize(w, h))
+	pool := newCanvasPool(2*full + 2*thumb)
+
+	// Three students open at full resolution: the budget holds two
+	decoders := make([]*StudentDecoder, 3)
+	for i := range decoders {
+		decoders[i] = newStudentDecoder(pool)
+		decoders[i].SetFullResolution(true)
+		screen, fullKey, err := decoders[i].Decode(solidKeyFrame(w, h))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantFull := i < 2
+		if fullKey != wantFull {
+			t.Errorf("student %d: full resolution %v, want %v", i, fullKey, wantFull)
+		}
+		if size := screen.Size(); !wantFull && size != thumbSize(w, h) {
+			t.Errorf("student %d: got %v, want a thumbnail", i, size)
+		}
+		if pool.InUse() > pool.budget {
+			t.Fatalf("student %d: %d bytes in use, budget %d", i, pool.InUse(), pool.budget)
+		}
+	}
+
+	// Once a student is closed, the next keyframe gets full resolution
+	decoders[0].Release()
+	if decoders[0].screen.Read(func(*image.RGBA, uint64) {}) {
+		t.Error("a released screen still has a frame")
+	}
+	if _, fullKey, err := decoders[2].Decode(solidKeyFrame(w, h)); err != nil || !fullKey {
+		t.Errorf("after a release: full resolution %v, %v", fullKey, err)
+	}
+	if pool.InUse() > pool.budget {
+		t.Errorf("%d bytes in use, budget %d", pool.InUse(), pool.budget)
+	}
+
+	for _, d := range decoders {
+		d.Release()
+	}
+	if pool.InUse() != 0 {
+		t.Errorf("%d bytes in use after releasing every canvas", pool.InUse())
+	}
+}
+
+func TestCanvasPoolReuse(t *testing.T) {
+	pool := newCanvasPool(1 << 20)
+
+	a := pool.Get(100, 100)
+	pool.Put(a)
+	if b := pool.Get(100, 100); b != a {
+		t.Error("a released canvas of the same size was not reused")
+	}
+	if c := pool.Get(50, 50); c == a {
+		t.Error("a canvas in use was handed out again")
+	}
+	if got := pool.TryGet(1000, 1000, 0); got != nil {
+		t.Error("TryGet went over the budget")
+	}
+}
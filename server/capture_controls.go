@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// CaptureControls let the teacher choose what a student's client streams:
+// the whole screen, one window or a region. The input holds the window
+// title, "class:NAME" for a window class, or a region as WxH+X+Y.
+type CaptureControls struct {
+	BtnDisplay *widget.Clickable
+	BtnWindow  *widget.Clickable
+	BtnRegion  *widget.Clickable
+	Input      *widget.Editor
+	// InputError explains why the input was rejected
+	InputError string
+}
+
+func NewCaptureControls() *CaptureControls {
+	cc := &CaptureControls{
+		BtnDisplay: new(widget.Clickable),
+		BtnWindow:  new(widget.Clickable),
+		BtnRegion:  new(widget.Clickable),
+		Input:      new(widget.Editor),
+	}
+	cc.Input.SingleLine = true
+	return cc
+}
+
+// Requested returns the target the teacher picked, if a button was clicked.
+func (cc *CaptureControls) Requested(gtx layout.Context) (CaptureTarget, bool) {
+	input := strings.TrimSpace(cc.Input.Text())
+
+	switch {
+	case cc.BtnDisplay.Clicked(gtx):
+		cc.InputError = ""
+		return CaptureTarget{Mode: protocol.CaptureDisplay}, true
+	case cc.BtnWindow.Clicked(gtx):
+		target := CaptureTarget{Mode: protocol.CaptureWindow, Title: input}
+		if class, ok := strings.CutPrefix(input, "class:"); ok {
+			target = CaptureTarget{Mode: protocol.CaptureWindow, Class: strings.TrimSpace(class)}
+		}
+		if target.Title == "" && target.Class == "" {
+			cc.InputError = "Enter a window title or class:NAME"
+			return CaptureTarget{}, false
+		}
+		cc.InputError = ""
+		return target, true
+	case cc.BtnRegion.Clicked(gtx):
+		target, ok := parseRegion(input)
+		if !ok {
+			cc.InputError = "Enter a region as WxH+X+Y, e.g. 1280x720+0+0"
+			return CaptureTarget{}, false
+		}
+		cc.InputError = ""
+		return target, true
+	}
+	return CaptureTarget{}, false
+}
+
+// parseRegion parses a region as X11 geometry, WxH+X+Y.
+func parseRegion(s string) (CaptureTarget, bool) {
+	target := CaptureTarget{Mode: protocol.CaptureRegion}
+	_, err := fmt.Sscanf(s, "%dx%d+%d+%d", &target.W, &target.H, &target.X, &target.Y)
+	if err != nil || target.W <= 0 || target.H <= 0 || target.X < 0 || target.Y < 0 {
+		return CaptureTarget{}, false
+	}
+	return target, true
+}
+
+// describeCapture says what a student's client captures.
+func describeCapture(target CaptureTarget) string {
+	switch target.Mode {
+	case protocol.CaptureWindow:
+		if target.Class != "" {
+			return fmt.Sprintf("Window of class %q", target.Class)
+		}
+		return fmt.Sprintf("Window %q", target.Title)
+	case protocol.CaptureRegion:
+		return fmt.Sprintf("Region %dx%d+%d+%d", target.W, target.H, target.X, target.Y)
+	}
+	return "Whole screen"
+}
+
+// describeCaptureStatus says how a student's capture backend is doing.
+func describeCaptureStatus(status CaptureStatus) string {
+	switch status.State {
+	case protocol.CaptureRetrying:
+		return fmt.Sprintf("Capture retrying (%s): %s", status.Backend, status.Error)
+	case protocol.CaptureFailed:
+		return "Capture failed: " + status.Error
+	}
+	return "Capturing with " + status.Backend
+}
+
+// captureFailing reports whether a student's capture backend is failing.
+func captureFailing(status CaptureStatus) bool {
+	return status.State == protocol.CaptureRetrying || status.State == protocol.CaptureFailed
+}
+
+func layoutCaptureControls(gtx layout.Context, th *material.Theme, student *Student, cc *CaptureControls) layout.Dimensions {
+	button := func(btn *widget.Clickable, text string) layout.FlexChild {
+		return layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Left: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				b := material.Button(th, btn, text)
+				b.TextSize = unit.Sp(13)
+				b.Inset = layout.UniformInset(unit.Dp(8))
+				return b.Layout(gtx)
+			})
+		})
+	}
+
+	return layout.Inset{Bottom: unit.Dp(16)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						text := "Capturing: " + describeCapture(student.Capture)
+						if student.CaptureStatus.Backend != "" {
+							text += " via " + student.CaptureStatus.Backend
+						}
+						label := material.Body2(th, text)
+						label.Color = textDark
+						label.MaxLines = 1
+						return label.Layout(gtx)
+					}),
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(16)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+							return TextEditor(th, cc.Input, "Window title, class:NAME or WxH+X+Y")(gtx)
+						})
+					}),
+					button(cc.BtnWindow, "Window"),
+					button(cc.BtnRegion, "Region"),
+					button(cc.BtnDisplay, "Whole screen"),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				message := cc.InputError
+				if message == "" && student.Capture.Error != "" {
+					message = "⚠  " + student.Capture.Error
+				}
+				if message == "" && captureFailing(student.CaptureStatus) {
+					message = "⚠  " + describeCaptureStatus(student.CaptureStatus)
+				}
+				if message == "" {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					label := material.Body2(th, message)
+					label.Color = dangerColor
+					label.MaxLines = 1
+					return label.Layout(gtx)
+				})
+			}),
+		)
+	})
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"image"
 	"image/color"
+	"strings"
+	"time"
 
 	"gioui.org/layout"
+	"gioui.org/op"
 	"gioui.org/op/clip"
 	"gioui.org/op/paint"
 	"gioui.org/unit"
@@ -22,9 +26,13 @@ var (
 	textSecondary   = color.NRGBA{R: 100, G: 116, B: 139, A: 255} // Slate-500
 	placeholderBg   = color.NRGBA{R: 241, G: 245, B: 249, A: 255} // Slate-100
 	placeholderText = color.NRGBA{R: 148, G: 163, B: 184, A: 255} // Slate-400
+	idleBadgeBg     = color.NRGBA{R: 254, G: 243, B: 199, A: 230} // Amber-100
+	idleBadgeText   = color.NRGBA{R: 146, G: 64, B: 14, A: 255}   // Amber-800
+	blankBadgeBg    = color.NRGBA{R: 30, G: 41, B: 59, A: 230}    // Slate-800
+	blankBadgeText  = color.NRGBA{R: 241, G: 245, B: 249, A: 255} // Slate-100
 )
 
-func StudentCard(gtx layout.Context, th *material.Theme, student *Student, width int, imgCache *ImageCacheManager) layout.Dimensions {
+func StudentCard(gtx layout.Context, th *material.Theme, student *Student, width int, thumbs *thumbnailer) layout.Dimensions {
 	return layout.UniformInset(unit.Dp(6)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return material.Clickable(gtx, student.Clickable, func(gtx layout.Context) layout.Dimensions {
 			// Draw shadow
@@ -41,17 +49,22 @@ func StudentCard(gtx layout.Context, th *material.Theme, student *Student, width
 			}
 			paint.FillShape(gtx.Ops, cardBackground, cardRect.Op(gtx.Ops))
 
-			// Draw border
-			return widget.Border{
+			// Draw border, highlighted when the student has policy violations
+			border := widget.Border{
 				Color:        cardBorder,
 				Width:        unit.Dp(1),
 				CornerRadius: unit.Dp(8),
-			}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			}
+			if len(student.Violations) > 0 {
+				border.Color = dangerColor
+				border.Width = unit.Dp(2)
+			}
+			return border.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 				return layout.UniformInset(unit.Dp(10)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 					return layout.Flex{Axis: layout.Vertical}.Layout(
 						gtx,
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							return layoutStudentImage(gtx, th, student, width, imgCache)
+							return layoutStudentImage(gtx, th, student, width, thumbs)
 						}),
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 							return layoutStudentInfo(gtx, th, student)
@@ -63,11 +76,48 @@ func StudentCard(gtx layout.Context, th *material.Theme, student *Student, width
 	})
 }
 
-func layoutStudentImage(gtx layout.Context, th *material.Theme, student *Student, width int, imgCache *ImageCacheManager) layout.Dimensions {
+func layoutStudentImage(gtx layout.Context, th *material.Theme, student *Student, width int, thumbs *thumbnailer) layout.Dimensions {
+	dims := layoutStudentImageContent(gtx, th, student, width, thumbs)
+
+	// Badges are drawn over the top-left corner of the image, one under
+	// the other
+	var badges []layout.FlexChild
+	badge := func(text string, bg, fg color.NRGBA) {
+		badges = append(badges, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layoutBadge(gtx, th, text, bg, fg)
+			})
+		}))
+	}
+	if !student.IdleSince.IsZero() {
+		badge("💤 Idle "+formatDuration(time.Since(student.IdleSince)), idleBadgeBg, idleBadgeText)
+	}
+	if !student.BlankSince.IsZero() {
+		// Distinct from idle: the screen may be in use, but the capture
+		// shows nothing
+		text := "⬛ Blank capture " + formatDuration(time.Since(student.BlankSince))
+		if student.BlankColor != "#000000" {
+			text += " " + student.BlankColor
+		}
+		badge(text, blankBadgeBg, blankBadgeText)
+	}
+	if len(badges) > 0 {
+		layout.UniformInset(unit.Dp(6)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min = image.Point{}
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx, badges...)
+		})
+	}
+
+	return dims
+}
+
+func layoutStudentImageContent(gtx layout.Context, th *material.Theme, student *Student, width int, thumbs *thumbnailer) layout.Dimensions {
 	// Calculate image container dimensions (16:9 aspect ratio)
 	imgHeight := width * 9 / 16
 
-	if student.Image == nil {
+	// Cards show the latest thumbnail from the background worker
+	thumb, ok := thumbs.Get(student.Id)
+	if !ok {
 		// Placeholder when no image
 		rect := clip.RRect{
 			Rect: image.Rect(0, 0, width, imgHeight),
@@ -79,8 +129,8 @@ func layoutStudentImage(gtx layout.Context, th *material.Theme, student *Student
 	}
 
 	// Draw rounded image container
-	imgOp := imgCache.GetImageOp(student)
-	imgSize := student.Image.Bounds().Size()
+	imgOp := thumb.Op
+	imgSize := thumb.Size
 	scale := float32(width) / float32(imgSize.X)
 
 	// Clip to rounded rectangle
@@ -95,6 +145,50 @@ func layoutStudentImage(gtx layout.Context, th *material.Theme, student *Student
 	}.Layout(gtx)
 }
 
+// describeThrottle summarizes how a client throttles itself.
+func describeThrottle(t ThrottleReport) string {
+	text := fmt.Sprintf("Throttled %d/%d: %g FPS, %dpx", t.Level, t.MaxLevel, t.FPS, t.MaxWidth)
+	if t.DirtyOnly {
+		text += ", changes only"
+	}
+	return fmt.Sprintf("%s (CPU %.0f%%)", text, t.CPUPercent)
+}
+
+// layoutBadge draws a small rounded label.
+func layoutBadge(gtx layout.Context, th *material.Theme, text string, bg, fg color.NRGBA) layout.Dimensions {
+	gtx.Constraints.Min = image.Point{}
+
+	macro := op.Record(gtx.Ops)
+	dims := layout.Inset{
+		Top: unit.Dp(2), Bottom: unit.Dp(2), Left: unit.Dp(6), Right: unit.Dp(6),
+	}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		label := material.Body2(th, text)
+		label.Color = fg
+		label.MaxLines = 1
+		label.TextSize = unit.Sp(11)
+		return label.Layout(gtx)
+	})
+	call := macro.Stop()
+
+	rect := clip.UniformRRect(image.Rectangle{Max: dims.Size}, gtx.Dp(unit.Dp(4)))
+	paint.FillShape(gtx.Ops, bg, rect.Op(gtx.Ops))
+	call.Add(gtx.Ops)
+
+	return dims
+}
+
+// formatDuration renders durations compactly, e.g. "45s", "3m", "1h05m".
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
 func layoutStudentInfo(gtx layout.Context, th *material.Theme, student *Student) layout.Dimensions {
 	return layout.Inset{Top: unit.Dp(10)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return layout.Flex{Axis: layout.Vertical}.Layout(
@@ -115,11 +209,86 @@ func layoutStudentInfo(gtx layout.Context, th *material.Theme, student *Student)
 					return label.Layout(gtx)
 				})
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				foreground := student.Foreground.Label()
+				if foreground == "" {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: unit.Dp(2)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					label := material.Body2(th, "▶ "+foreground)
+					label.Color = textSecondary
+					label.MaxLines = 1
+					label.TextSize = unit.Sp(12)
+					return label.Layout(gtx)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				count := len(student.Violations)
+				if count == 0 {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: unit.Dp(2)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					last := student.Violations[count-1]
+					label := material.Body2(th, fmt.Sprintf("⛔  %d violations · %s", count, last.Description()))
+					label.Color = dangerColor
+					label.MaxLines = 1
+					label.TextSize = unit.Sp(12)
+					return label.Layout(gtx)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if len(student.BlockedProcesses) == 0 {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: unit.Dp(2)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					label := material.Body2(th, "⛔  Blocked: "+strings.Join(student.BlockedProcesses, ", "))
+					label.Color = dangerColor
+					label.MaxLines = 1
+					label.TextSize = unit.Sp(12)
+					return label.Layout(gtx)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if student.PrevMachine == nil {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: unit.Dp(2)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					label := material.Body2(th, "⚠  Machine changed")
+					label.Color = dangerColor
+					label.MaxLines = 1
+					label.TextSize = unit.Sp(12)
+					return label.Layout(gtx)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if student.Throttle.Level == 0 {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: unit.Dp(2)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					label := material.Body2(th, "🐢  "+describeThrottle(student.Throttle))
+					label.Color = textSecondary
+					label.MaxLines = 1
+					label.TextSize = unit.Sp(12)
+					return label.Layout(gtx)
+				})
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if !captureFailing(student.CaptureStatus) {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: unit.Dp(2)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					label := material.Body2(th, "⚠  "+describeCaptureStatus(student.CaptureStatus))
+					label.Color = dangerColor
+					label.MaxLines = 1
+					label.TextSize = unit.Sp(12)
+					return label.Layout(gtx)
+				})
+			}),
 		)
 	})
 }
 
-func CreateStudentGrid(gtx layout.Context, th *material.Theme, students []*Student, rowIndex int, col int, imgCache *ImageCacheManager) []layout.FlexChild {
+func CreateStudentGrid(gtx layout.Context, th *material.Theme, students []*Student, rowIndex int, col int, thumbs *thumbnailer) []layout.FlexChild {
 	var row []layout.FlexChild
 	start := rowIndex * col
 	end := start + col
@@ -131,7 +300,7 @@ func CreateStudentGrid(gtx layout.Context, th *material.Theme, students []*Stude
 	for i := start; i < end; i++ {
 		item := students[i]
 		row = append(row, layout.Flexed(1.0/float32(col), func(gtx layout.Context) layout.Dimensions {
-			return StudentCard(gtx, th, item, width, imgCache)
+			return StudentCard(gtx, th, item, width, thumbs)
 		}))
 	}
 
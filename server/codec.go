@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+var errBadTile = errors.New("malformed tile")
+
+// decodeTile decodes one w×h tile with the given codec. The encoded image
+// must be exactly w×h.
+func decodeTile(codec byte, w, h int, data []byte) (image.Image, error) {
+	switch codec {
+	case protocol.CodecJPEG:
+		return decodeJPEG(data, w, h)
+	case protocol.CodecPNG:
+		return decodePNG(data, w, h)
+	case protocol.CodecRLE:
+		return decodeRLE(w, h, data)
+	default:
+		return nil, errBadTile
+	}
+}
+
+// decodePNG decodes a w×h PNG after checking its header dimensions.
+func decodePNG(data []byte, w, h int) (image.Image, error) {
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width != w || config.Height != h {
+		return nil, errBadDimensions
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// decodeRLE decodes a palette + run-length tile.
+// Format: [ncolors:1][R,G,B]*ncolors then [index:1][run-1:1]...
+// ncolors of 0 means 256.
+func decodeRLE(w, h int, data []byte) (image.Image, error) {
+	if w <= 0 || h <= 0 || len(data) < 1 {
+		return nil, errBadTile
+	}
+
+	colors := int(data[0])
+	if colors == 0 {
+		colors = 256
+	}
+	if len(data) < 1+colors*3 {
+		return nil, errBadTile
+	}
+	palette := data[1 : 1+colors*3]
+	runs := data[1+colors*3:]
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	pix := img.Pix
+	pos := 0
+
+	for i := 0; i+1 < len(runs); i += 2 {
+		index := int(runs[i])
+		run := int(runs[i+1]) + 1
+		if index >= colors || pos+run*4 > len(pix) {
+			return nil, errBadTile
+		}
+
+		c := palette[index*3 : index*3+3]
+		for j := 0; j < run; j++ {
+			pix[pos] = c[0]
+			pix[pos+1] = c[1]
+			pix[pos+2] = c[2]
+			pix[pos+3] = 255
+			pos += 4
+		}
+	}
+
+	if pos != len(pix) {
+		return nil, errBadTile
+	}
+	return img, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// minCursorScale keeps the pointer recognisable when a large screen is
+// shown small.
+const minCursorScale = 0.75
+
+var errCursorTooLarge = errors.New("cursor shape too large")
+
+var (
+	cursorFill    = color.NRGBA{A: 255}
+	cursorOutline = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+)
+
+// defaultArrow is the pointer drawn when the client sent no shape, in
+// student screen pixels with the hotspot at the origin.
+var defaultArrow = []f32.Point{
+	{X: 0, Y: 0}, {X: 0, Y: 17}, {X: 4, Y: 13}, {X: 7, Y: 20},
+	{X: 9, Y: 19}, {X: 6, Y: 12}, {X: 12, Y: 12},
+}
+
+// StudentCursor is the student's mouse pointer. It is replaced, never
+// modified, on every report, so snapshots can share it.
+type StudentCursor struct {
+	CursorReport
+	// Shape is the pointer image, nil to draw the default arrow.
+	Shape   image.Image
+	ShapeOp paint.ImageOp
+	HotX    int
+	HotY    int
+}
+
+// newStudentCursor applies report on top of prev. The shape is kept while
+// the shape ID stays the same; an unknown ID without a shape falls back to
+// the default arrow.
+func newStudentCursor(prev *StudentCursor, report CursorReport) *StudentCursor {
+	cur := &StudentCursor{CursorReport: report}
+
+	if report.Shape != nil && report.Shape.ID == report.ShapeID {
+		if img, err := decodeCursorShape(report.Shape.PNG); err == nil {
+			cur.Shape = img
+			cur.ShapeOp = paint.NewImageOp(img)
+			cur.HotX, cur.HotY = report.Shape.HotX, report.Shape.HotY
+		}
+	} else if prev != nil && prev.Shape != nil && report.ShapeID != 0 && prev.ShapeID == report.ShapeID {
+		cur.Shape, cur.ShapeOp = prev.Shape, prev.ShapeOp
+		cur.HotX, cur.HotY = prev.HotX, prev.HotY
+	}
+	// Only the decoded image is kept
+	cur.CursorReport.Shape = nil
+	return cur
+}
+
+// decodeCursorShape decodes a cursor PNG, refusing shapes larger than
+// protocol.MaxCursorSize before allocating them.
+func decodeCursorShape(data []byte) (image.Image, error) {
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > protocol.MaxCursorSize || cfg.Height > protocol.MaxCursorSize {
+		return nil, errCursorTooLarge
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// layoutCursor draws the pointer over a screen image shown at size.
+func layoutCursor(gtx layout.Context, cur *StudentCursor, size image.Point) {
+	if cur == nil || !cur.Visible || cur.ScreenW <= 0 || cur.ScreenH <= 0 {
+		return
+	}
+	if cur.X < 0 || cur.Y < 0 || cur.X >= cur.ScreenW || cur.Y >= cur.ScreenH {
+		return
+	}
+
+	defer clip.Rect{Max: size}.Push(gtx.Ops).Pop()
+
+	scale := max(float32(size.X)/float32(cur.ScreenW), minCursorScale)
+	pos := image.Pt(cur.X*size.X/cur.ScreenW, cur.Y*size.Y/cur.ScreenH)
+
+	if cur.Shape == nil {
+		defer op.Offset(pos).Push(gtx.Ops).Pop()
+		drawArrow(gtx, scale)
+		return
+	}
+
+	hot := image.Pt(int(float32(cur.HotX)*scale), int(float32(cur.HotY)*scale))
+	defer op.Offset(pos.Sub(hot)).Push(gtx.Ops).Pop()
+	widget.Image{
+		Src:   cur.ShapeOp,
+		Scale: scale / gtx.Metric.PxPerDp,
+	}.Layout(gtx)
+}
+
+// drawArrow draws defaultArrow at scale with a light outline, so it shows
+// on dark and light screens alike.
+func drawArrow(gtx layout.Context, scale float32) {
+	paint.FillShape(gtx.Ops, cursorFill, clip.Outline{Path: arrowPath(gtx.Ops, scale)}.Op())
+	paint.FillShape(gtx.Ops, cursorOutline, clip.Stroke{
+		Path:  arrowPath(gtx.Ops, scale),
+		Width: float32(gtx.Dp(unit.Dp(1))),
+	}.Op())
+}
+
+func arrowPath(ops *op.Ops, scale float32) clip.PathSpec {
+	var path clip.Path
+	path.Begin(ops)
+	path.MoveTo(defaultArrow[0].Mul(scale))
+	for _, p := range defaultArrow[1:] {
+		path.LineTo(p.Mul(scale))
+	}
+	path.Close()
+	return path.End()
+}
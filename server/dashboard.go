@@ -1,8 +1,6 @@
 package main
 
 import (
-	"image"
-
 	"gioui.org/layout"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
@@ -11,28 +9,37 @@ import (
 type DashboardState struct {
 	studentManager  *StudentManager
 	imgCache        *ImageCacheManager
+	thumbs          *thumbnailer
 	BtnStop         *widget.Clickable
 	BtnColMinus     *widget.Clickable
 	BtnColPlus      *widget.Clickable
 	BtnSortToggle   *widget.Clickable
 	BtnSortField    *widget.Clickable
 	BtnViewerClose  *widget.Clickable
+	Capture         *CaptureControls
 	Stop            func()
+	OnView          func(id string, viewed bool)          // Called when the viewer opens or closes
+	OnCapture       func(id string, target CaptureTarget) // Called when the teacher changes a capture target
 	columnsCount    int
 	viewerOpen      bool
 	viewerStudentID string
+	// shownViolation is the Seq of the violation whose screenshot the
+	// viewer shows instead of the live image, 0 for live.
+	shownViolation int
 }
 
 func NewDashboardState(stop func()) *DashboardState {
 	return &DashboardState{
 		studentManager:  NewStudentManager(),
 		imgCache:        NewImageCacheManager(),
+		thumbs:          newThumbnailer(),
 		BtnStop:         new(widget.Clickable),
 		BtnColMinus:     new(widget.Clickable),
 		BtnColPlus:      new(widget.Clickable),
 		BtnSortToggle:   new(widget.Clickable),
 		BtnSortField:    new(widget.Clickable),
 		BtnViewerClose:  new(widget.Clickable),
+		Capture:         NewCaptureControls(),
 		Stop:            stop,
 		columnsCount:    3,
 		viewerOpen:      false,
@@ -52,16 +59,64 @@ func (ds *DashboardState) isExists(id string) bool {
 func (ds *DashboardState) RemoveStudent(id string) {
 	ds.studentManager.Remove(id)
 	ds.imgCache.Remove(id)
+	ds.thumbs.Remove(id)
 }
 
-func (ds *DashboardState) UpdateImage(id string, img image.Image) {
-	ds.studentManager.UpdateImage(id, img)
+func (ds *DashboardState) UpdateImage(id string, screen *Screen, fullKey bool) {
+	ds.studentManager.UpdateImage(id, screen, fullKey)
+	ds.thumbs.Submit(id, screen)
 }
 
 func (ds *DashboardState) UpdateName(id string, name string) {
 	ds.studentManager.UpdateName(id, name)
 }
 
+func (ds *DashboardState) UpdateMachine(id string, report MachineReport) {
+	ds.studentManager.UpdateMachine(id, report)
+}
+
+func (ds *DashboardState) UpdateActivity(id string, report ActivityReport) {
+	ds.studentManager.UpdateActivity(id, report)
+}
+
+func (ds *DashboardState) AddViolation(id string, report ViolationReport) {
+	ds.studentManager.AddViolation(id, report)
+}
+
+func (ds *DashboardState) UpdateIdle(id string, report IdleReport) {
+	ds.studentManager.UpdateIdle(id, report)
+}
+
+func (ds *DashboardState) UpdateBlank(id string, report BlankReport) {
+	ds.studentManager.UpdateBlank(id, report)
+}
+
+func (ds *DashboardState) UpdateThrottle(id string, report ThrottleReport) {
+	ds.studentManager.UpdateThrottle(id, report)
+}
+
+func (ds *DashboardState) AddEvent(id string, report EventReport) {
+	ds.studentManager.AddEvent(id, report)
+}
+
+func (ds *DashboardState) UpdateCursor(id string, report CursorReport) {
+	ds.studentManager.UpdateCursor(id, report)
+}
+
+func (ds *DashboardState) UpdateCapture(id string, target CaptureTarget) {
+	ds.studentManager.UpdateCapture(id, target)
+}
+
+func (ds *DashboardState) UpdateCaptureStatus(id string, status CaptureStatus) {
+	ds.studentManager.UpdateCaptureStatus(id, status)
+}
+
+// SetPolicy applies the exam policy locally: blocked process names are
+// also flagged on the cards from the reported process lists.
+func (ds *DashboardState) SetPolicy(policy Policy) {
+	ds.studentManager.SetBlocklist(policy.BlockedProcesses)
+}
+
 func (ds *DashboardState) Layout(gtx layout.Context, th *material.Theme, list *widget.List) layout.Dimensions {
 	ds.handleButtonClicks(gtx)
 
@@ -69,17 +124,16 @@ func (ds *DashboardState) Layout(gtx layout.Context, th *material.Theme, list *w
 
 	for _, student := range students {
 		if student.Clickable.Clicked(gtx) {
-			ds.viewerOpen = true
-			ds.viewerStudentID = student.Id
+			ds.openViewer(student.Id)
 		}
 	}
 
 	if ds.viewerOpen {
 		viewerStudent := ds.studentManager.GetByID(ds.viewerStudentID)
 		if viewerStudent == nil {
-			ds.viewerOpen = false
+			ds.closeViewer()
 		} else {
-			return LayoutViewer(gtx, th, viewerStudent, ds.imgCache, ds.BtnViewerClose)
+			return LayoutViewer(gtx, th, viewerStudent, ds.imgCache, ds.BtnViewerClose, ds.Capture, &ds.shownViolation)
 		}
 	}
 
@@ -91,7 +145,8 @@ func (ds *DashboardState) handleButtonClicks(gtx layout.Context) {
 		ds.Stop()
 		ds.studentManager.Clear()
 		ds.imgCache.Clear()
-		ds.viewerOpen = false
+		ds.thumbs.Clear()
+		ds.closeViewer()
 	}
 
 	if ds.BtnColMinus.Clicked(gtx) && ds.columnsCount > 1 {
@@ -115,8 +170,32 @@ func (ds *DashboardState) handleButtonClicks(gtx layout.Context) {
 	}
 
 	if ds.BtnViewerClose.Clicked(gtx) {
-		ds.viewerOpen = false
+		ds.closeViewer()
+	}
+
+	if target, ok := ds.Capture.Requested(gtx); ok && ds.viewerOpen && ds.OnCapture != nil {
+		ds.OnCapture(ds.viewerStudentID, target)
+	}
+}
+
+func (ds *DashboardState) openViewer(id string) {
+	if ds.viewerOpen && ds.viewerStudentID != id {
+		ds.closeViewer()
+	}
+	ds.viewerOpen = true
+	ds.viewerStudentID = id
+	ds.shownViolation = 0
+	ds.Capture.InputError = ""
+	if ds.OnView != nil {
+		ds.OnView(id, true)
+	}
+}
+
+func (ds *DashboardState) closeViewer() {
+	if ds.viewerOpen && ds.OnView != nil {
+		ds.OnView(ds.viewerStudentID, false)
 	}
+	ds.viewerOpen = false
 }
 
 func (ds *DashboardState) layoutDashboard(gtx layout.Context, th *material.Theme, list *widget.List, students []*Student) layout.Dimensions {
@@ -143,7 +222,7 @@ func (ds *DashboardState) layoutDashboard(gtx layout.Context, th *material.Theme
 			return list.Layout(gtx, itemCount, func(gtx layout.Context, index int) layout.Dimensions {
 				return layout.Flex{Axis: layout.Horizontal}.Layout(
 					gtx,
-					CreateStudentGrid(gtx, th, students, index, col, ds.imgCache)...,
+					CreateStudentGrid(gtx, th, students, index, col, ds.thumbs)...,
 				)
 			})
 		}),
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"sync"
+
+	"github.com/exam-gaurd/protocol"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Decoder limits. Frames come from untrusted peers, so every size is checked
+// before anything is allocated for it.
+const (
+	MAX_FRAME_WIDTH   = 4096
+	MAX_FRAME_HEIGHT  = 4096
+	MAX_TILE_AREA     = 2 // Tile area a frame may declare, in canvases; tiles overlap and overhang a little
+	MAX_DECODE_ERRORS = 8 // Bad frames tolerated per connection before disconnecting
+)
+
+var (
+	errNoCanvas      = errors.New("dirty frame before keyframe")
+	errBadDimensions = errors.New("image dimensions out of range")
+	errBadTileRect   = errors.New("tile outside canvas")
+	errNoTiles       = errors.New("frame has no tiles")
+	errTileArea      = errors.New("tiles cover too much area")
+	errStripLayout   = errors.New("strips do not cover the frame")
+)
+
+// StudentDecoder rebuilds one student's screen from keyframes and dirty
+// tiles. A frame is validated and decoded in full before the canvas is
+// touched, so a bad frame never leaves a half-drawn screen.
+//
+// The canvas is private to the decoder. Each frame is published to the
+// student's Screen, which the UI reads while the next frame draws.
+//
+// The canvas is kept at thumbnail size unless the student is open in the
+// viewer. The resolution is chosen at each keyframe; dirty tiles are scaled
+// onto whatever canvas the last keyframe set up.
+type StudentDecoder struct {
+	canvas *image.RGBA
+	screen *Screen
+	mu     sync.Mutex
+
+	pool *canvasPool
+	// frame is the full-resolution size of the student's screen
+	frame image.Point
+	// full keeps the canvas at full resolution
+	full bool
+	// fullOnce decodes the next keyframe at full resolution
+	fullOnce bool
+}
+
+func newStudentDecoder(pool *canvasPool) *StudentDecoder {
+	return &StudentDecoder{pool: pool, screen: new(Screen)}
+}
+
+// SetFullResolution switches between full resolution and thumbnails from
+// the next keyframe on.
+func (d *StudentDecoder) SetFullResolution(full bool) {
+	d.mu.Lock()
+	d.full = full
+	d.mu.Unlock()
+}
+
+// FullResolutionOnce decodes the next keyframe at full resolution, so the
+// screenshot attached to a violation is legible.
+func (d *StudentDecoder) FullResolutionOnce() {
+	d.mu.Lock()
+	d.fullOnce = true
+	d.mu.Unlock()
+}
+
+// Release returns the canvas and the screen's buffers to the pool. The
+// screen reads as empty afterwards.
+func (d *StudentDecoder) Release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pool.Put(d.canvas)
+	d.canvas = nil
+
+	s := d.screen
+	s.mu.Lock()
+	bufs := s.bufs
+	s.bufs = [2]*image.RGBA{}
+	s.mu.Unlock()
+	for _, buf := range bufs {
+		d.pool.Put(buf)
+	}
+}
+
+// keyCanvas returns the canvas for a new w×h keyframe, reusing the current
+// one when it already has the right size. A full-resolution canvas the
+// pool's budget can't afford, together with the screen buffers it is
+// published to, falls back to a thumbnail. Must be called with d.mu held.
+func (d *StudentDecoder) keyCanvas(w, h int) *image.RGBA {
+	full := d.full || d.fullOnce
+	d.fullOnce = false
+	d.frame = image.Pt(w, h)
+
+	size := thumbSize(w, h)
+	if full {
+		size = d.frame
+	}
+	if d.canvas != nil && d.canvas.Bounds().Size() == size {
+		return d.canvas
+	}
+	d.pool.Put(d.canvas)
+	d.canvas = nil
+
+	if full {
+		d.canvas = d.pool.TryGet(size.X, size.Y, len(d.screen.bufs))
+	}
+	if d.canvas == nil {
+		size = thumbSize(w, h)
+		d.canvas = d.pool.Get(size.X, size.Y)
+	}
+	return d.canvas
+}
+
+// decodedTile is a tile decoded and clipped to the canvas.
+type decodedTile struct {
+	img  image.Image
+	rect image.Rectangle
+}
+
+// Decode applies a PICTURE payload and returns the student's screen, the
+// same for every frame. fullKey reports whether the frame was a keyframe
+// decoded at full resolution, the only kind a violation screenshot is taken
+// from.
+func (d *StudentDecoder) Decode(data []byte) (screen *Screen, fullKey bool, err error) {
+	frame, err := protocol.DecodeFrame(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch frame.Type {
+	case protocol.FrameKey, protocol.FrameLegacy:
+		// Legacy clients sent a bare JPEG; nothing else is accepted
+		return d.decodeKeyFrame(frame.Image)
+	case protocol.FrameDirty, protocol.FrameDirtyCodec:
+		screen, err := d.decodeDirtyRects(frame.Tiles)
+		return screen, false, err
+	default:
+		return d.decodeKeyStrips(frame)
+	}
+}
+
+// isFull reports whether the canvas is at full resolution. Must be called
+// with d.mu held.
+func (d *StudentDecoder) isFull() bool {
+	return d.canvas.Bounds().Size() == d.frame
+}
+
+func (d *StudentDecoder) decodeKeyFrame(data []byte) (*Screen, bool, error) {
+	img, err := decodeJPEG(data, 0, 0)
+	if err != nil {
+		return nil, false, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	bounds := img.Bounds()
+	canvas := d.keyCanvas(bounds.Dx(), bounds.Dy())
+	drawScaled(canvas, canvas.Bounds(), img, bounds)
+
+	return d.publish(), d.isFull(), nil
+}
+
+// decodeDirtyRects draws changed tiles onto the current canvas.
+func (d *StudentDecoder) decodeDirtyRects(tiles []protocol.Tile) (*Screen, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.canvas == nil {
+		return nil, errNoCanvas
+	}
+
+	decoded, err := decodeTiles(image.Rectangle{Max: d.frame}, tiles)
+	if err != nil {
+		return nil, err
+	}
+	d.drawTiles(decoded)
+
+	return d.publish(), nil
+}
+
+// decodeKeyStrips decodes a keyframe sent as horizontal strips. The strips
+// must cover the frame exactly: a pooled canvas holds whatever was drawn on
+// it before, possibly another student's screen, and every pixel of it must
+// be replaced.
+func (d *StudentDecoder) decodeKeyStrips(frame *protocol.Frame) (*Screen, bool, error) {
+	if !validDimensions(frame.Width, frame.Height) {
+		return nil, false, errBadDimensions
+	}
+	if !coversFrame(frame) {
+		return nil, false, errStripLayout
+	}
+
+	bounds := image.Rect(0, 0, frame.Width, frame.Height)
+	decoded, err := decodeTiles(bounds, frame.Tiles)
+	if err != nil {
+		return nil, false, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.keyCanvas(frame.Width, frame.Height)
+	d.drawTiles(decoded)
+
+	return d.publish(), d.isFull(), nil
+}
+
+// coversFrame reports whether the tiles of a strip keyframe are full-width
+// strips that follow each other from the top to the bottom of the frame.
+// The last may overhang the bottom edge, as strips are aligned to the
+// JPEG block grid.
+func coversFrame(frame *protocol.Frame) bool {
+	y := 0
+	for _, tile := range frame.Tiles {
+		if y >= frame.Height || tile.X != 0 || tile.W != frame.Width || tile.Y != y {
+			return false
+		}
+		y += tile.H
+	}
+	return y >= frame.Height
+}
+
+// publish copies the canvas to the screen's back buffer and swaps it to the
+// front. A back buffer of the wrong size is exchanged for one from the
+// pool; readers never see the back buffer, so it can be returned at once.
+// Must be called with d.mu held.
+func (d *StudentDecoder) publish() *Screen {
+	s := d.screen
+	size := d.canvas.Bounds().Size()
+
+	back := s.bufs[1-s.front]
+	if back == nil || back.Bounds().Size() != size {
+		d.pool.Put(back)
+		back = d.pool.Get(size.X, size.Y)
+		s.bufs[1-s.front] = back
+	}
+	copy(back.Pix, d.canvas.Pix)
+
+	s.mu.Lock()
+	s.front = 1 - s.front
+	s.seq++
+	s.mu.Unlock()
+	return s
+}
+
+// decodeTiles validates and decodes every tile against the canvas bounds.
+// Tiles may overhang the right and bottom edges, as the client aligns them
+// to its scaled grid, but must start inside the canvas.
+//
+// Every tile is checked before any is decoded, and together they may
+// declare at most MAX_TILE_AREA canvases of pixels, so a frame of many
+// small payloads claiming large tiles can't make the decoder allocate more
+// than a few canvases.
+func decodeTiles(canvas image.Rectangle, tiles []protocol.Tile) ([]decodedTile, error) {
+	if len(tiles) == 0 {
+		return nil, errNoTiles
+	}
+
+	area := 0
+	for _, tile := range tiles {
+		if tile.W <= 0 || tile.H <= 0 || tile.W > canvas.Dx() || tile.H > canvas.Dy() {
+			return nil, errBadTileRect
+		}
+		if !image.Pt(tile.X, tile.Y).In(canvas) {
+			return nil, errBadTileRect
+		}
+		area += tile.W * tile.H
+		if area > MAX_TILE_AREA*canvas.Dx()*canvas.Dy() {
+			return nil, errTileArea
+		}
+	}
+
+	decoded := make([]decodedTile, 0, len(tiles))
+	for _, tile := range tiles {
+		img, err := decodeTile(tile.Codec, tile.W, tile.H, tile.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		rect := image.Rect(tile.X, tile.Y, tile.X+tile.W, tile.Y+tile.H).Intersect(canvas)
+		decoded = append(decoded, decodedTile{img: img, rect: rect})
+	}
+
+	return decoded, nil
+}
+
+// drawTiles draws decoded tiles onto the canvas, scaling them when the
+// canvas is a thumbnail. Must be called with d.mu held.
+func (d *StudentDecoder) drawTiles(tiles []decodedTile) {
+	canvasSize := d.canvas.Bounds().Size()
+	for _, tile := range tiles {
+		src := tile.rect.Sub(tile.rect.Min).Add(tile.img.Bounds().Min)
+		if d.isFull() {
+			draw.Draw(d.canvas, tile.rect, tile.img, src.Min, draw.Src)
+			continue
+		}
+
+		// Round outward so neighbouring tiles leave no gaps
+		dst := image.Rect(
+			tile.rect.Min.X*canvasSize.X/d.frame.X,
+			tile.rect.Min.Y*canvasSize.Y/d.frame.Y,
+			ceilDiv(tile.rect.Max.X*canvasSize.X, d.frame.X),
+			ceilDiv(tile.rect.Max.Y*canvasSize.Y, d.frame.Y),
+		)
+		drawScaled(d.canvas, dst, tile.img, src)
+	}
+}
+
+// drawScaled draws the src region of img into the dst region of canvas,
+// scaling if the sizes differ.
+func drawScaled(canvas *image.RGBA, dst image.Rectangle, img image.Image, src image.Rectangle) {
+	if dst.Size() == src.Size() {
+		draw.Draw(canvas, dst, img, src.Min, draw.Src)
+		return
+	}
+	xdraw.ApproxBiLinear.Scale(canvas, dst, img, src, xdraw.Src, nil)
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// validDimensions reports whether a w×h image is within the decoder limits.
+func validDimensions(w, h int) bool {
+	return w > 0 && h > 0 && w <= MAX_FRAME_WIDTH && h <= MAX_FRAME_HEIGHT
+}
+
+// decodeJPEG decodes a JPEG after checking its header dimensions. If w and
+// h are non-zero the image must be exactly w×h.
+func decodeJPEG(data []byte, w, h int) (image.Image, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if !validDimensions(config.Width, config.Height) {
+		return nil, errBadDimensions
+	}
+	if w != 0 && (config.Width != w || config.Height != h) {
+		return nil, errBadDimensions
+	}
+	return jpeg.Decode(bytes.NewReader(data))
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// screen is a client screen the tests draw on between frames.
+type screen struct {
+	img  *image.RGBA
+	gray bool
+}
+
+func newScreen(w, h int, gray bool) *screen {
+	s := &screen{img: image.NewRGBA(image.Rect(0, 0, w, h)), gray: gray}
+	s.draw(s.img.Bounds(), 0)
+	return s
+}
+
+// draw fills r with a pattern that changes with salt. Neighbouring pixels
+// differ, so a tile scaled or placed wrongly shows. The colour pattern is
+// smooth, so JPEG loss stays well below what a misplaced tile costs.
+func (s *screen) draw(r image.Rectangle, salt int) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := s.img.Pix[s.img.PixOffset(x, y):]
+			if s.gray {
+				v := uint8(x*3 + y*5 + salt*40)
+				p[0], p[1], p[2] = v, v, v
+			} else {
+				p[0] = triangle(x + y + salt*90)
+				p[1] = triangle(2*x + salt*70)
+				p[2] = triangle(2*y + 256 - salt*50)
+			}
+			p[3] = 255
+		}
+	}
+}
+
+// triangle is a triangle wave of period 512 over 0-255.
+func triangle(n int) uint8 {
+	n &= 511
+	if n > 255 {
+		n = 511 - n
+	}
+	return uint8(n)
+}
+
+// encodeTile encodes the r part of img as a tile, with CodecPNG or
+// CodecJPEG. Where r overhangs img the tile is black, as the decoder clips
+// that part.
+func encodeTile(t testing.TB, img *image.RGBA, r image.Rectangle, codec byte) protocol.Tile {
+	t.Helper()
+	tile := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(tile, tile.Bounds(), img, r.Min, draw.Src)
+
+	var buf bytes.Buffer
+	var err error
+	if codec == protocol.CodecJPEG {
+		err = jpeg.Encode(&buf, tile, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, tile)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return protocol.Tile{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy(), Codec: codec, Data: buf.Bytes()}
+}
+
+// keyFrame returns a keyframe of img in strips of 8px rows, as clients send
+// them; the last strip may overhang the bottom edge.
+func keyFrame(t testing.TB, img *image.RGBA, strips int, codec byte) []byte {
+	t.Helper()
+	size := img.Bounds().Size()
+	f := &protocol.Frame{Type: protocol.FrameKeyStripsCodec, Width: size.X, Height: size.Y}
+	h := ceilDiv(ceilDiv(size.Y, strips), 8) * 8
+	for y := 0; y < size.Y; y += h {
+		f.Tiles = append(f.Tiles, encodeTile(t, img, image.Rect(0, y, size.X, y+h), codec))
+	}
+	return protocol.AppendFrame(nil, f)
+}
+
+// dirtyFrame returns a dirty frame of the rects of img. Like a client's,
+// the tiles are snapped outward to the 8px grid, so those at the right and
+// bottom edges overhang the screen.
+func dirtyFrame(t testing.TB, img *image.RGBA, rects []image.Rectangle, codec byte) []byte {
+	t.Helper()
+	f := &protocol.Frame{Type: protocol.FrameDirtyCodec}
+	for _, r := range rects {
+		r = image.Rect(r.Min.X&^7, r.Min.Y&^7, ceilDiv(r.Max.X, 8)*8, ceilDiv(r.Max.Y, 8)*8)
+		f.Tiles = append(f.Tiles, encodeTile(t, img, r, codec))
+	}
+	return protocol.AppendFrame(nil, f)
+}
+
+// decodeFull decodes an encoded frame at full resolution.
+func decodeFull(t testing.TB, d *StudentDecoder, data []byte) *image.RGBA {
+	t.Helper()
+	screen, _, err := d.Decode(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return screen.Copy()
+}
+
+// imageError returns the mean absolute difference per channel over the
+// whole image and over its worst 8×8 block, where a seam or a stale tile
+// shows.
+func imageError(a, b *image.RGBA) (mean, worst float64) {
+	total := 0
+	bounds := a.Bounds()
+	for by := 0; by < bounds.Dy(); by += 8 {
+		for bx := 0; bx < bounds.Dx(); bx += 8 {
+			sum, n := 0, 0
+			for y := by; y < min(by+8, bounds.Dy()); y++ {
+				for x := bx; x < min(bx+8, bounds.Dx()); x++ {
+					pa, pb := a.Pix[a.PixOffset(x, y):], b.Pix[b.PixOffset(x, y):]
+					for c := 0; c < 3; c++ {
+						d := int(pa[c]) - int(pb[c])
+						if d < 0 {
+							d = -d
+						}
+						sum += d
+						n++
+					}
+				}
+			}
+			total += sum
+			if e := float64(sum) / float64(n); e > worst {
+				worst = e
+			}
+		}
+	}
+	return float64(total) / float64(bounds.Dx()*bounds.Dy()*3), worst
+}
+
+// TestDirtyFramesMatchKeyFrame streams a screen as one keyframe and many
+// dirty frames with edge tiles overhanging the screen, and checks that the
+// decoded result matches the final screen. At full resolution the lossless
+// tiles must rebuild it exactly; on a thumbnail, scaled by a non-integer
+// factor, the tiles must leave no seams or stale pixels compared with a
+// keyframe of it. The client's half, tiles snapped to its scaled grid, is
+// tested with its encoder.
+func TestDirtyFramesMatchKeyFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		full  bool
+		mean  float64
+		block float64
+	}{
+		{"full resolution", true, 0, 0},
+		// Tiles scaled apart sample the screen at other points than the
+		// keyframe scaled whole; a stale tile costs over 100
+		{"thumbnail", false, 6, 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const w, h = 1366, 768 // A 360 wide thumbnail is 3.79x smaller
+			dec := newStudentDecoder(nil)
+			dec.SetFullResolution(tt.full)
+			scr := newScreen(w, h, false)
+			decodeFull(t, dec, keyFrame(t, scr.img, 4, protocol.CodecPNG))
+
+			rng := rand.New(rand.NewSource(1))
+			var got *image.RGBA
+			for i := 1; i <= 40; i++ {
+				rects := make([]image.Rectangle, 1+rng.Intn(4))
+				for j := range rects {
+					x, y := rng.Intn(w-1), rng.Intn(h-1)
+					r := image.Rect(x, y, x+1+rng.Intn(min(300, w-x)), y+1+rng.Intn(min(200, h-y)))
+					scr.draw(r, i)
+					rects[j] = r
+				}
+				got = decodeFull(t, dec, dirtyFrame(t, scr.img, rects, protocol.CodecPNG))
+			}
+
+			want := scr.img
+			if !tt.full {
+				want = decodeFull(t, newStudentDecoder(nil), keyFrame(t, scr.img, 4, protocol.CodecPNG))
+			}
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("bounds %v, want %v", got.Bounds(), want.Bounds())
+			}
+			mean, worst := imageError(got, want)
+			if mean > tt.mean || worst > tt.block {
+				t.Errorf("differs by %.2f on average and %.1f in the worst 8x8 block, want at most %.2f and %.1f",
+					mean, worst, tt.mean, tt.block)
+			}
+		})
+	}
+}
+
+// tiledFrame builds a dirty or strip frame payload of frame type t.
+func tiledFrame(t byte, w, h int, tiles []protocol.Tile) []byte {
+	return protocol.AppendFrame(nil, &protocol.Frame{Type: t, Width: w, Height: h, Tiles: tiles})
+}
+
+// solidKeyFrame returns a black w×h strip keyframe, w*h a multiple of 256.
+func solidKeyFrame(w, h int) []byte {
+	tile := protocol.Tile{W: w, H: h, Codec: protocol.CodecRLE, Data: []byte{1, 0, 0, 0}}
+	for n := w * h; n > 0; n -= 256 {
+		tile.Data = append(tile.Data, 0, 255)
+	}
+	return tiledFrame(protocol.FrameKeyStripsCodec, w, h, []protocol.Tile{tile})
+}
+
+func TestDecodeLimitsTileArea(t *testing.T) {
+	d := newStudentDecoder(nil)
+	d.SetFullResolution(true)
+	if _, _, err := d.Decode(solidKeyFrame(64, 64)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Thousands of 6-byte RLE tiles each claiming the whole canvas would
+	// need a canvas apiece
+	tiles := make([]protocol.Tile, 1000)
+	for i := range tiles {
+		tiles[i] = protocol.Tile{W: 64, H: 64, Codec: protocol.CodecRLE, Data: []byte{1, 0, 0, 0, 0, 255}}
+	}
+	if _, _, err := d.Decode(tiledFrame(protocol.FrameDirtyCodec, 0, 0, tiles)); err != errTileArea {
+		t.Errorf("got %v, want errTileArea", err)
+	}
+}
+
+// seedFrames returns frames of every type for a small screen: a JPEG
+// keyframe, strip keyframes with and without codecs, and dirty frames of
+// each codec.
+func seedFrames(t testing.TB) [][]byte {
+	scr := newScreen(200, 120, true)
+	var jpegKey bytes.Buffer
+	if err := jpeg.Encode(&jpegKey, scr.img, nil); err != nil {
+		t.Fatal(err)
+	}
+	frames := [][]byte{
+		protocol.AppendFrame(nil, &protocol.Frame{Type: protocol.FrameKey, Image: jpegKey.Bytes()}),
+		keyFrame(t, scr.img, 2, protocol.CodecPNG),
+	}
+
+	// Version1 strips carry JPEG tiles without a codec byte
+	strips, err := protocol.DecodeFrame(keyFrame(t, scr.img, 2, protocol.CodecJPEG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strips.Type = protocol.FrameKeyStrips
+	frames = append(frames, protocol.AppendFrame(nil, strips))
+
+	r := image.Rect(30, 20, 80, 60)
+	scr.draw(r, 1)
+	jpegTile := encodeTile(t, scr.img, r, protocol.CodecJPEG)
+	rle := protocol.Tile{X: 96, Y: 64, W: 16, H: 16, Codec: protocol.CodecRLE, Data: []byte{1, 200, 0, 0, 0, 255}}
+	return append(frames,
+		tiledFrame(protocol.FrameDirty, 0, 0, []protocol.Tile{jpegTile}),
+		dirtyFrame(t, scr.img, []image.Rectangle{r}, protocol.CodecPNG),
+		tiledFrame(protocol.FrameDirtyCodec, 0, 0, []protocol.Tile{jpegTile, rle}),
+	)
+}
+
+func FuzzStudentDecoderDecode(f *testing.F) {
+	seeds := seedFrames(f)
+	for _, data := range seeds {
+		f.Add(data)
+	}
+	key := seeds[0]
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Start from a decoded keyframe so dirty frames reach the canvas
+		d := newStudentDecoder(newCanvasPool(CANVAS_BUDGET))
+		if _, _, err := d.Decode(key); err != nil {
+			t.Fatal(err)
+		}
+
+		screen, _, err := d.Decode(data)
+		if err != nil {
+			return
+		}
+		size := screen.Size()
+		if size.X <= 0 || size.Y <= 0 || size.X > MAX_FRAME_WIDTH || size.Y > MAX_FRAME_HEIGHT {
+			t.Fatalf("decoded a %v image", size)
+		}
+		d.Release()
+	})
+}
+
+func TestDecodeKeyStripsMustCoverFrame(t *testing.T) {
+	// 8x4 strips of one colour
+	strip := func(y int) protocol.Tile {
+		return protocol.Tile{Y: y, W: 8, H: 4, Codec: protocol.CodecRLE, Data: []byte{1, 200, 0, 0, 0, 31}}
+	}
+	tests := []struct {
+		name   string
+		strips []protocol.Tile
+		ok     bool
+	}{
+		{"complete", []protocol.Tile{strip(0), strip(4)}, true},
+		{"missing top", []protocol.Tile{strip(4)}, false},
+		{"missing bottom", []protocol.Tile{strip(0)}, false},
+		{"out of order", []protocol.Tile{strip(4), strip(0)}, false},
+		{"repeated", []protocol.Tile{strip(0), strip(0)}, false},
+		{"narrow", []protocol.Tile{strip(0), {Y: 4, W: 4, H: 4, Codec: protocol.CodecRLE, Data: []byte{1, 0, 0, 0, 0, 15}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newStudentDecoder(nil)
+			d.SetFullResolution(true)
+			screen, _, err := d.Decode(tiledFrame(protocol.FrameKeyStripsCodec, 8, 8, tt.strips))
+			if !tt.ok {
+				if err != errStripLayout {
+					t.Errorf("got %v, want errStripLayout", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rgba := screen.Copy()
+			for i := 0; i < len(rgba.Pix); i += 4 {
+				if rgba.Pix[i] != 200 {
+					t.Fatalf("pixel %d not drawn", i/4)
+				}
+			}
+		})
+	}
+}
+
+// TestSyntheticStream streams a desktop like the synthetic capturer's, a
+// window dragged across it and then typing into it, as JPEG dirty tiles,
+// and checks the result against a keyframe of the last screen.
+func TestSyntheticStream(t *testing.T) {
+	const w, h, frames = 1280, 720, 130
+	scr := newScreen(w, h, false)
+	dec := newStudentDecoder(nil)
+	dec.SetFullResolution(true)
+	decodeFull(t, dec, keyFrame(t, scr.img, 4, protocol.CodecJPEG))
+
+	win := image.Rect(100, 100, 500, 400)
+	for i := 1; i <= frames; i++ {
+		var rects []image.Rectangle
+		if i <= frames/2 {
+			// Dragging: the old position shows the desktop again
+			old := win
+			win = win.Add(image.Pt(7, 3))
+			scr.draw(old, 0)
+			scr.draw(win, 1)
+			rects = []image.Rectangle{old, win}
+		} else {
+			// Typing: one character cell at a time
+			n := i - frames/2
+			cell := image.Rect(0, 0, 8, 14).Add(win.Min).Add(image.Pt(20+n%40*9, 30+n/40*18))
+			scr.draw(cell, i)
+			rects = []image.Rectangle{cell}
+		}
+		decodeFull(t, dec, dirtyFrame(t, scr.img, rects, protocol.CodecJPEG))
+	}
+	got := dec.screen.Copy()
+
+	fresh := newStudentDecoder(nil)
+	fresh.SetFullResolution(true)
+	want := decodeFull(t, fresh, keyFrame(t, scr.img, 4, protocol.CodecJPEG))
+
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("bounds %v, want %v", got.Bounds(), want.Bounds())
+	}
+	// JPEG alone stays under 10 in the worst block; a dropped frame
+	// leaves window edges that cost over 100
+	if mean, worst := imageError(got, want); mean > 2 || worst > 30 {
+		t.Errorf("differs by %.2f on average and %.1f in the worst 8x8 block, want at most 2 and 30", mean, worst)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"image/color"
 	"strconv"
+	"strings"
 
 	"gioui.org/layout"
 	"gioui.org/unit"
@@ -11,23 +12,52 @@ import (
 )
 
 type HomeState struct {
-	RoomEditor *widget.Editor
-	BtnConnect *widget.Clickable
-	OnClick    func(int)
-	ErrorText  string
+	RoomEditor    *widget.Editor
+	ProcessEditor *widget.Editor
+	TitleEditor   *widget.Editor
+	URLEditor     *widget.Editor
+	BtnConnect    *widget.Clickable
+	OnClick       func(int, Policy)
+	ErrorText     string
 }
 
-func NewHomeState(start func(int)) *HomeState {
+func NewHomeState(start func(int, Policy)) *HomeState {
 	home := HomeState{
-		RoomEditor: new(widget.Editor),
-		BtnConnect: new(widget.Clickable),
-		OnClick:    start,
-		ErrorText:  "",
+		RoomEditor:    new(widget.Editor),
+		ProcessEditor: new(widget.Editor),
+		TitleEditor:   new(widget.Editor),
+		URLEditor:     new(widget.Editor),
+		BtnConnect:    new(widget.Clickable),
+		OnClick:       start,
+		ErrorText:     "",
 	}
+	home.ProcessEditor.SingleLine = true
+	home.TitleEditor.SingleLine = true
+	home.URLEditor.SingleLine = true
 
 	return &home
 }
 
+// Policy returns the exam policy entered on the home screen.
+func (h *HomeState) Policy() Policy {
+	return Policy{
+		BlockedProcesses:   splitList(h.ProcessEditor.Text()),
+		BlockedTitles:      splitList(h.TitleEditor.Text()),
+		AllowedURLKeywords: splitList(h.URLEditor.Text()),
+	}
+}
+
+// splitList splits a comma-separated editor value, dropping empty entries.
+func splitList(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func (h *HomeState) Layout(gtx layout.Context, th *material.Theme) layout.Dimensions {
 	if h.BtnConnect.Clicked(gtx) {
 		roomText := h.RoomEditor.Text()
@@ -41,7 +71,7 @@ func (h *HomeState) Layout(gtx layout.Context, th *material.Theme) layout.Dimens
 				h.ErrorText = "Room number must be positive"
 			} else {
 				h.ErrorText = ""
-				h.OnClick(room)
+				h.OnClick(room, h.Policy())
 			}
 		}
 	}
@@ -70,6 +100,15 @@ func (h *HomeState) Layout(gtx layout.Context, th *material.Theme) layout.Dimens
 						gtx.Constraints.Max.X = gtx.Dp(300)
 						return TextEditor(th, h.RoomEditor, "Enter room number")(gtx)
 					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layoutPolicyField(gtx, th, "Blocked processes", h.ProcessEditor, "e.g. discord, telegram")
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layoutPolicyField(gtx, th, "Blocked window titles", h.TitleEditor, "e.g. chatgpt, whatsapp")
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layoutPolicyField(gtx, th, "Allowed URL keywords", h.URLEditor, "e.g. moodle, docs.python.org")
+					}),
 					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 						return layout.Spacer{Height: unit.Dp(16)}.Layout(gtx)
 					}),
@@ -97,3 +136,23 @@ func (h *HomeState) Layout(gtx layout.Context, th *material.Theme) layout.Dimens
 		}),
 	)
 }
+
+// layoutPolicyField lays out a labelled comma-separated policy editor.
+func layoutPolicyField(gtx layout.Context, th *material.Theme, label string, editor *widget.Editor, hint string) layout.Dimensions {
+	return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: unit.Dp(16)}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return material.Body1(th, label).Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Height: unit.Dp(8)}.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(300)
+			gtx.Constraints.Max.X = gtx.Dp(300)
+			return TextEditor(th, editor, hint)(gtx)
+		}),
+	)
+}
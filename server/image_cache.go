@@ -1,19 +1,27 @@
 package main
 
 import (
-	"time"
+	"image"
+	"sync"
 
 	"gioui.org/op/paint"
 )
 
 type ImageCache struct {
-	op        paint.ImageOp
-	imagePtr  uintptr
-	timestamp time.Time
+	op     paint.ImageOp
+	screen *Screen
+	seq    uint64
+	// img is the UI's copy of the screen. gio uploads it while the frame
+	// that uses op renders, so it is overwritten in place by the next
+	// layout that finds a newer frame.
+	img *image.RGBA
 }
 
+// ImageCacheManager is used by the UI and by RemoveStudent on network
+// goroutines, so the map is guarded.
 type ImageCacheManager struct {
 	cache map[string]ImageCache
+	mu    sync.Mutex
 }
 
 func NewImageCacheManager() *ImageCacheManager {
@@ -22,30 +30,42 @@ func NewImageCacheManager() *ImageCacheManager {
 	}
 }
 
-func (icm *ImageCacheManager) GetImageOp(student *Student) paint.ImageOp {
-	if student.Image == nil {
-		return paint.ImageOp{}
+// GetImageOp returns an op for the student's latest frame and its size, or
+// false if there is none yet.
+func (icm *ImageCacheManager) GetImageOp(student *Student) (paint.ImageOp, image.Point, bool) {
+	if student.Screen == nil {
+		return paint.ImageOp{}, image.Point{}, false
 	}
 
-	cached, ok := icm.cache[student.Id]
-	if ok && cached.imagePtr == student.ImagePtr && cached.timestamp == student.Timestamp {
-		return cached.op
-	}
+	icm.mu.Lock()
+	defer icm.mu.Unlock()
 
-	// Create new image op
-	imgOp := paint.NewImageOp(student.Image)
-	icm.cache[student.Id] = ImageCache{
-		op:        imgOp,
-		imagePtr:  student.ImagePtr,
-		timestamp: student.Timestamp,
+	cached := icm.cache[student.Id]
+	ok := student.Screen.Read(func(img *image.RGBA, seq uint64) {
+		// The sequence number changes with every frame
+		if cached.screen == student.Screen && cached.seq == seq && cached.img != nil {
+			return
+		}
+		cached.img = copyRGBA(cached.img, img)
+		cached.op = paint.NewImageOp(cached.img)
+		cached.screen = student.Screen
+		cached.seq = seq
+	})
+	if !ok {
+		return paint.ImageOp{}, image.Point{}, false
 	}
-	return imgOp
+	icm.cache[student.Id] = cached
+	return cached.op, cached.img.Bounds().Size(), true
 }
 
 func (icm *ImageCacheManager) Remove(id string) {
+	icm.mu.Lock()
+	defer icm.mu.Unlock()
 	delete(icm.cache, id)
 }
 
 func (icm *ImageCacheManager) Clear() {
+	icm.mu.Lock()
+	defer icm.mu.Unlock()
 	icm.cache = make(map[string]ImageCache)
 }
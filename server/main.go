@@ -53,17 +53,21 @@ func run(w *app.Window) error {
 	state := NewAppState()
 	server := NewServer()
 
-	home := NewHomeState(func(room int) {
-		state.swtichScreen("dashboard")
-		server.Start(room)
-	})
-
 	dashboard := NewDashboardState(func() {
 		state.swtichScreen("home")
 		server.Stop()
 	})
 
+	home := NewHomeState(func(room int, policy Policy) {
+		dashboard.SetPolicy(policy)
+		server.SetPolicy(policy)
+		state.swtichScreen("dashboard")
+		server.Start(room)
+	})
+
 	server.studentUtil = dashboard
+	dashboard.OnView = server.SetViewed
+	dashboard.OnCapture = server.SetCaptureTarget
 
 	var list widget.List
 	list.Axis = layout.Vertical
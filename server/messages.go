@@ -0,0 +1,180 @@
+package main
+
+import (
+	"github.com/exam-gaurd/protocol"
+)
+
+// Message payloads are defined by the shared protocol package.
+type (
+	DisplayInfo     = protocol.DisplayInfo
+	MachineReport   = protocol.MachineReport
+	WindowInfo      = protocol.Window
+	ProcessInfo     = protocol.Process
+	ActivityReport  = protocol.ActivityReport
+	Policy          = protocol.Policy
+	ViolationReport = protocol.ViolationReport
+	IdleReport      = protocol.IdleReport
+	EventReport     = protocol.EventReport
+	CursorReport    = protocol.CursorReport
+	CaptureTarget   = protocol.CaptureTarget
+	CaptureStatus   = protocol.CaptureStatus
+	BlankReport     = protocol.BlankReport
+	ThrottleReport  = protocol.ThrottleReport
+)
+
+// SetPolicy replaces the exam policy and pushes it to every connected client.
+func (s *Server) SetPolicy(policy Policy) {
+	s.policyMu.Lock()
+	s.policy = policy
+	s.policyMu.Unlock()
+
+	s.activeConnsMu.Lock()
+	conns := make([]*studentConn, 0, len(s.conns))
+	for _, conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.activeConnsMu.Unlock()
+
+	for _, conn := range conns {
+		s.sendMessage(conn, protocol.MsgPolicy, policy)
+	}
+}
+
+// GetPolicy returns the current exam policy.
+func (s *Server) GetPolicy() Policy {
+	s.policyMu.Lock()
+	defer s.policyMu.Unlock()
+	return s.policy
+}
+
+// SetCaptureTarget asks a student's client to capture target instead of
+// what it captures now. The client's answer is passed to UpdateCapture.
+func (s *Server) SetCaptureTarget(id string, target CaptureTarget) {
+	s.activeConnsMu.Lock()
+	s.targets[id] = target
+	conn := s.conns[id]
+	s.activeConnsMu.Unlock()
+
+	if conn != nil {
+		// Called from the UI; don't block it on the socket
+		go s.sendMessage(conn, protocol.MsgCapture, target)
+	}
+}
+
+// getTarget returns the capture target to restore when a student
+// reconnects, and false for the whole display.
+func (s *Server) getTarget(id string) (CaptureTarget, bool) {
+	s.activeConnsMu.Lock()
+	defer s.activeConnsMu.Unlock()
+	target, ok := s.targets[id]
+	if !ok || target.Mode == protocol.CaptureDisplay || target.Mode == "" {
+		return CaptureTarget{}, false
+	}
+	return target, true
+}
+
+// sendMessage sends a typed JSON message to a client over the MESSAGE channel.
+func (s *Server) sendMessage(conn *studentConn, msgType string, data interface{}) error {
+	payload, err := protocol.EncodeMessage(msgType, data)
+	if err != nil {
+		return err
+	}
+	return conn.send(protocol.PacketMessage, payload)
+}
+
+// handleMessage dispatches a MESSAGE payload. Payloads that are not a
+// structured envelope are plain text and only logged.
+func (s *Server) handleMessage(conn *studentConn, id string, data []byte) {
+	env, err := protocol.DecodeMessage(data)
+	if err != nil {
+		println(string(data))
+		return
+	}
+
+	if env.Type == protocol.MsgHello {
+		var hello protocol.Hello
+		if err := env.Decode(&hello); err != nil {
+			return
+		}
+		conn.version = protocol.Negotiate(protocol.Version, hello.Version)
+		s.sendMessage(conn, protocol.MsgHello, protocol.Hello{Version: conn.version})
+		return
+	}
+
+	if id == "" {
+		return
+	}
+
+	switch env.Type {
+	case protocol.MsgMachine:
+		var report MachineReport
+		if err := env.Decode(&report); err != nil {
+			return
+		}
+		s.studentUtil.UpdateMachine(id, report)
+	case protocol.MsgActivity:
+		var report ActivityReport
+		if err := env.Decode(&report); err != nil {
+			return
+		}
+		s.studentUtil.UpdateActivity(id, report)
+	case protocol.MsgViolation:
+		var report ViolationReport
+		if err := env.Decode(&report); err != nil {
+			return
+		}
+		// The client follows a violation with a keyframe; keep it at
+		// full resolution for the screenshot
+		s.getOrCreateDecoder(id).FullResolutionOnce()
+		s.studentUtil.AddViolation(id, report)
+	case protocol.MsgIdle:
+		var report IdleReport
+		if err := env.Decode(&report); err != nil {
+			return
+		}
+		s.studentUtil.UpdateIdle(id, report)
+	case protocol.MsgBlank:
+		var report BlankReport
+		if err := env.Decode(&report); err != nil {
+			return
+		}
+		s.studentUtil.UpdateBlank(id, report)
+	case protocol.MsgThrottle:
+		var report ThrottleReport
+		if err := env.Decode(&report); err != nil {
+			return
+		}
+		s.studentUtil.UpdateThrottle(id, report)
+	case protocol.MsgEvent:
+		var report EventReport
+		if err := env.Decode(&report); err != nil {
+			return
+		}
+		s.studentUtil.AddEvent(id, report)
+	case protocol.MsgCursor:
+		var report CursorReport
+		if err := env.Decode(&report); err != nil {
+			return
+		}
+		s.studentUtil.UpdateCursor(id, report)
+	case protocol.MsgCapture:
+		var target CaptureTarget
+		if err := env.Decode(&target); err != nil {
+			return
+		}
+		// Remember what the client settled on, so a target it rejected
+		// isn't retried on every reconnect
+		applied := target
+		applied.Error = ""
+		s.activeConnsMu.Lock()
+		s.targets[id] = applied
+		s.activeConnsMu.Unlock()
+		s.studentUtil.UpdateCapture(id, target)
+	case protocol.MsgCaptureStatus:
+		var status CaptureStatus
+		if err := env.Decode(&status); err != nil {
+			return
+		}
+		s.studentUtil.UpdateCaptureStatus(id, status)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"sync"
+)
+
+// Screen is a student's decoded screen, shared by the decoder and the UI.
+// It is double-buffered: the decoder copies each frame into the back buffer
+// and swaps it to the front under the lock, so publishing allocates nothing
+// and readers always see a whole frame. The buffers are reused, so readers
+// only touch the front buffer through Read and copy what they keep.
+type Screen struct {
+	mu    sync.RWMutex
+	bufs  [2]*image.RGBA
+	front int
+	seq   uint64 // Frames published so far
+}
+
+// Read calls fn with the latest frame and its sequence number, which
+// changes with every frame. img must not be modified, nor used after fn
+// returns; the decoder waits for fn before writing to it again. Read
+// returns false without calling fn when there is no frame yet, or after the
+// decoder was released.
+func (s *Screen) Read(fn func(img *image.RGBA, seq uint64)) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	img := s.bufs[s.front]
+	if img == nil {
+		return false
+	}
+	fn(img, s.seq)
+	return true
+}
+
+// Copy returns a copy of the latest frame, or nil if there is none.
+func (s *Screen) Copy() *image.RGBA {
+	var dst *image.RGBA
+	s.Read(func(img *image.RGBA, _ uint64) {
+		dst = copyRGBA(dst, img)
+	})
+	return dst
+}
+
+// Size returns the size of the latest frame.
+func (s *Screen) Size() image.Point {
+	var size image.Point
+	s.Read(func(img *image.RGBA, _ uint64) {
+		size = img.Bounds().Size()
+	})
+	return size
+}
+
+// copyRGBA copies src into dst, reusing dst when it has the same size.
+func copyRGBA(dst, src *image.RGBA) *image.RGBA {
+	if dst == nil || dst.Bounds() != src.Bounds() {
+		dst = image.NewRGBA(src.Bounds())
+	}
+	copy(dst.Pix, src.Pix)
+	return dst
+}
@@ -1,31 +1,36 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
-	"image"
-	"image/draw"
-	"image/jpeg"
-	"io"
 	"net"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/exam-gaurd/protocol"
 )
 
 const (
-	HEADER_SIZE          = 8
 	READ_TIMEOUT         = 10 * time.Second
 	REMOVAL_GRACE_PERIOD = 5 * time.Second // Keep student visible for a few seconds after disconnect
-	FrameTypeKey   byte = 0x01
-	FrameTypeDirty byte = 0x02
 )
 
-type StudentDecoder struct {
-	canvas *image.RGBA
+// studentConn serializes writes to a student's socket.
+type studentConn struct {
+	socket *net.TCPConn
 	mu     sync.Mutex
+
+	// version is the negotiated protocol version. Clients that never send
+	// a hello speak version 1.
+	version int
+}
+
+func (c *studentConn) send(dataType protocol.PacketType, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.socket.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return protocol.WritePacket(c.socket, dataType, payload)
 }
 
 type Server struct {
@@ -34,16 +39,32 @@ type Server struct {
 	studentUtil StudentUtil
 	activeConns   map[string]int64 // studentID -> connection timestamp
 	activeConnsMu sync.Mutex
+	conns         map[string]*studentConn  // studentID -> live connection, guarded by activeConnsMu
+	targets       map[string]CaptureTarget // studentID -> capture target in use, guarded by activeConnsMu
+
+	policy   Policy
+	policyMu sync.Mutex
 
 	decoders   map[string]*StudentDecoder
 	decodersMu sync.Mutex
+	canvases   *canvasPool
 }
 
 type StudentUtil interface {
 	AddStudent(id, name string)
 	RemoveStudent(id string)
-	UpdateImage(id string, img image.Image)
+	UpdateImage(id string, screen *Screen, fullKey bool)
 	UpdateName(id string, name string)
+	UpdateMachine(id string, report MachineReport)
+	UpdateActivity(id string, report ActivityReport)
+	AddViolation(id string, report ViolationReport)
+	UpdateIdle(id string, report IdleReport)
+	UpdateBlank(id string, report BlankReport)
+	UpdateThrottle(id string, report ThrottleReport)
+	AddEvent(id string, report EventReport)
+	UpdateCursor(id string, report CursorReport)
+	UpdateCapture(id string, target CaptureTarget)
+	UpdateCaptureStatus(id string, status CaptureStatus)
 	isExists(id string) bool
 }
 
@@ -51,7 +72,10 @@ func NewServer() *Server {
 	server := Server{
 		isRunning:   atomic.Bool{},
 		activeConns: make(map[string]int64),
+		conns:       make(map[string]*studentConn),
+		targets:     make(map[string]CaptureTarget),
 		decoders:    make(map[string]*StudentDecoder),
+		canvases:    newCanvasPool(CANVAS_BUDGET),
 	}
 	server.isRunning.Store(false)
 	return &server
@@ -61,18 +85,22 @@ func (s *Server) Start(port int) {
 	if s.studentUtil == nil {
 		return
 	}
+	// Listen before returning so Stop, called from the same goroutine,
+	// always sees the listener
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+	if err != nil {
+		return
+	}
+	s.listener = listener
 	s.isRunning.Store(true)
 	go s.broadcastHost(port)
 	go func() {
-		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
-		if err != nil {
-			s.isRunning.Store(false)
-			return
-		}
 		defer listener.Close()
-		s.listener = listener
 		for s.isRunning.Load() {
 			conn, err := listener.AcceptTCP()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			if err != nil {
 				continue
 			}
@@ -86,14 +114,31 @@ func (s *Server) Start(port int) {
 	}()
 }
 
-func (s *Server) registerConnection(id string) int64 {
+func (s *Server) registerConnection(id string, conn *studentConn) int64 {
 	s.activeConnsMu.Lock()
 	defer s.activeConnsMu.Unlock()
 	timestamp := time.Now().UnixNano()
 	s.activeConns[id] = timestamp
+	s.conns[id] = conn
 	return timestamp
 }
 
+// unregisterConnection forgets conn unless the student has already reconnected.
+func (s *Server) unregisterConnection(id string, conn *studentConn) {
+	s.activeConnsMu.Lock()
+	defer s.activeConnsMu.Unlock()
+	if s.conns[id] == conn {
+		delete(s.conns, id)
+	}
+}
+
+// getConn returns the live connection for a student, or nil.
+func (s *Server) getConn(id string) *studentConn {
+	s.activeConnsMu.Lock()
+	defer s.activeConnsMu.Unlock()
+	return s.conns[id]
+}
+
 func (s *Server) scheduleStudentRemoval(id string, connTimestamp int64) {
 	time.Sleep(REMOVAL_GRACE_PERIOD)
 
@@ -103,6 +148,7 @@ func (s *Server) scheduleStudentRemoval(id string, connTimestamp int64) {
 	if currentTimestamp, exists := s.activeConns[id]; exists {
 		if currentTimestamp == connTimestamp {
 			delete(s.activeConns, id)
+			delete(s.targets, id)
 			s.studentUtil.RemoveStudent(id)
 			s.removeDecoder(id)
 		}
@@ -117,89 +163,107 @@ func (s *Server) getOrCreateDecoder(id string) *StudentDecoder {
 		return dec
 	}
 
-	dec := &StudentDecoder{}
+	dec := newStudentDecoder(s.canvases)
 	s.decoders[id] = dec
 	return dec
 }
 
-// removeDecoder removes a student's decoder.
+// removeDecoder removes a student's decoder and recycles its canvas.
 func (s *Server) removeDecoder(id string) {
 	s.decodersMu.Lock()
-	defer s.decodersMu.Unlock()
+	dec := s.decoders[id]
 	delete(s.decoders, id)
+	s.decodersMu.Unlock()
+
+	if dec != nil {
+		dec.Release()
+	}
+}
+
+// SetViewed keeps a student's screen at full resolution while it is open
+// in the viewer. Opening asks the client for a keyframe so the full
+// resolution screen appears without waiting for the next periodic one.
+func (s *Server) SetViewed(id string, viewed bool) {
+	s.getOrCreateDecoder(id).SetFullResolution(viewed)
+
+	if !viewed {
+		return
+	}
+	if conn := s.getConn(id); conn != nil {
+		// Called from the UI; don't block it on the socket
+		go s.sendMessage(conn, protocol.MsgKeyFrame, nil)
+	}
 }
 
 func (s *Server) handleStudent(socket *net.TCPConn) {
 	defer socket.Close()
+	conn := &studentConn{socket: socket, version: protocol.Version1}
 	id := ""
 	var connTimestamp int64 = 0
-	header := make([]byte, HEADER_SIZE)
+	decodeErrors := 0
 
 	// Reusable data buffer with larger initial capacity
 	data := make([]byte, 64*1024)
 
+read:
 	for s.isRunning.Load() {
 		socket.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
 
-		_, err := io.ReadFull(socket, header)
-
-		if err != nil {
-			break
-		}
-
-		dataType, dataSize, err := unpackHeader(header)
-
-		if err != nil || dataSize <= 0 || dataSize > 5*1024*1024 {
-			break
-		}
-
-		if cap(data) < dataSize {
-			data = make([]byte, dataSize)
-		}
-		data = data[:dataSize]
-
-		socket.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
-		_, err = io.ReadFull(socket, data)
-
+		dataType, payload, err := protocol.ReadPacket(socket, data, protocol.MaxPacketSize)
 		if err != nil {
 			break
 		}
+		data = payload
 
 		switch dataType {
-		case 0: // NAME
-			info := string(data)
-			parts := strings.SplitN(info, "###", 2)
-			if len(parts) != 2 {
+		case protocol.PacketName:
+			studentID, name, err := protocol.ParseJoin(payload)
+			if err != nil {
 				break
 			}
-			id = strings.TrimSpace(parts[0])
-			name := strings.TrimSpace(parts[1])
+			id = studentID
 
-			connTimestamp = s.registerConnection(id)
+			connTimestamp = s.registerConnection(id, conn)
 
 			if !s.studentUtil.isExists(id) {
 				s.studentUtil.AddStudent(id, name)
 			} else {
 				s.studentUtil.UpdateName(id, name)
 			}
-		case 1: // MESSAGE
-			msg := string(data)
-			println(msg)
+
+			s.sendMessage(conn, protocol.MsgPolicy, s.GetPolicy())
+			// A reconnected client starts on the whole display
+			if target, ok := s.getTarget(id); ok {
+				s.sendMessage(conn, protocol.MsgCapture, target)
+			}
+		case protocol.PacketMessage:
+			s.handleMessage(conn, id, payload)
 		default: // PICTURE
 			if id == "" {
 				continue
 			}
 			// Decode frame with dirty rect support
-			img := s.decodeFrame(id, data)
-			if img != nil {
-				s.studentUtil.UpdateImage(id, img)
+			screen, fullKey, err := s.getOrCreateDecoder(id).Decode(payload)
+			if err != nil {
+				// Occasional bad frames are tolerated; a client that keeps
+				// sending them is broken or hostile and is disconnected
+				decodeErrors++
+				if decodeErrors > MAX_DECODE_ERRORS {
+					break read
+				}
+				continue
 			}
+			if decodeErrors > 0 {
+				decodeErrors--
+			}
+			s.studentUtil.UpdateImage(id, screen, fullKey)
 		}
 	}
 
 	// Schedule student removal with grace period
 	// If client reconnects within the grace period, they won't be removed
 	if id != "" && connTimestamp != 0 {
+		s.unregisterConnection(id, conn)
 		go s.scheduleStudentRemoval(id, connTimestamp)
 	}
 }
@@ -235,122 +299,3 @@ func (s *Server) Stop() {
 		s.listener.Close()
 	}
 }
-
-func (s *Server) decodeFrame(id string, data []byte) image.Image {
-	if len(data) < 1 {
-		return nil
-	}
-
-	frameType := data[0]
-
-	switch frameType {
-	case FrameTypeKey:
-		return s.decodeKeyFrame(id, data[1:])
-	case FrameTypeDirty:
-		return s.decodeDirtyRects(id, data[1:])
-	default:
-		return s.decodeLegacyFrame(id, data)
-	}
-}
-
-func (s *Server) decodeKeyFrame(id string, data []byte) image.Image {
-	img, err := jpeg.Decode(bytes.NewReader(data))
-	if err != nil {
-		return nil
-	}
-
-	dec := s.getOrCreateDecoder(id)
-	dec.mu.Lock()
-	defer dec.mu.Unlock()
-
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
-	dec.canvas = rgba
-
-	return img
-}
-
-func (s *Server) decodeDirtyRects(id string, data []byte) image.Image {
-	if len(data) < 2 {
-		return nil
-	}
-
-	dec := s.getOrCreateDecoder(id)
-	dec.mu.Lock()
-	defer dec.mu.Unlock()
-
-	if dec.canvas == nil {
-		return nil
-	}
-
-	rectCount := int(binary.BigEndian.Uint16(data[:2]))
-	offset := 2
-
-	for i := 0; i < rectCount; i++ {
-		if offset+12 > len(data) {
-			break
-		}
-
-		x := int(binary.BigEndian.Uint16(data[offset:]))
-		y := int(binary.BigEndian.Uint16(data[offset+2:]))
-		w := int(binary.BigEndian.Uint16(data[offset+4:]))
-		h := int(binary.BigEndian.Uint16(data[offset+6:]))
-		dataLen := int(binary.BigEndian.Uint32(data[offset+8:]))
-		offset += 12
-
-		if offset+dataLen > len(data) {
-			break
-		}
-
-		rectImg, err := jpeg.Decode(bytes.NewReader(data[offset : offset+dataLen]))
-		offset += dataLen
-
-		if err != nil {
-			continue
-		}
-
-		destRect := image.Rect(x, y, x+w, y+h)
-		canvasBounds := dec.canvas.Bounds()
-
-		if destRect.Max.X > canvasBounds.Max.X || destRect.Max.Y > canvasBounds.Max.Y {
-			continue
-		}
-
-		draw.Draw(dec.canvas, destRect, rectImg, image.Point{}, draw.Src)
-	}
-
-	return dec.canvas
-}
-
-func (s *Server) decodeLegacyFrame(id string, data []byte) image.Image {
-	img, err := jpeg.Decode(bytes.NewReader(data))
-	if err != nil {
-		img, _, err = image.Decode(bytes.NewReader(data))
-		if err != nil {
-			return nil
-		}
-	}
-
-	dec := s.getOrCreateDecoder(id)
-	dec.mu.Lock()
-	defer dec.mu.Unlock()
-
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
-	dec.canvas = rgba
-
-	return img
-}
-
-func unpackHeader(data []byte) (uint16, int, error) {
-	if len(data) < HEADER_SIZE || string(data[:2]) != "HE" {
-		return 0, 0, errors.New("invalid header")
-	}
-
-	status := uint16(binary.BigEndian.Uint16(data[2:4]))
-	length := int(binary.BigEndian.Uint32(data[4:8]))
-
-	return status, length, nil
-}
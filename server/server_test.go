@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/exam-gaurd/protocol"
+)
+
+// streamScreen joins as id and sends a keyframe and frames dirty frames of
+// a changing screen. It returns the screen a decoder of its own rebuilt
+// from the same frames.
+func streamScreen(t *testing.T, addr net.Addr, id string, full bool, frames int) []byte {
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	defer conn.Close()
+	// The server's messages are not needed, but must not fill the socket
+	go io.Copy(io.Discard, conn)
+
+	if err := protocol.WritePacket(conn, protocol.PacketName, protocol.JoinPayload(id, "Student "+id)); err != nil {
+		t.Error(err)
+		return nil
+	}
+
+	const w, h = 640, 360 // Over a thumbnail's width
+	own := newStudentDecoder(nil)
+	own.SetFullResolution(full)
+	scr := newScreen(w, h, true)
+
+	send := func(data []byte) bool {
+		if _, _, err := own.Decode(data); err != nil {
+			t.Error(err)
+			return false
+		}
+		if err := protocol.WritePacket(conn, protocol.PacketPicture, data); err != nil {
+			t.Error(err)
+			return false
+		}
+		return true
+	}
+
+	if !send(keyFrame(t, scr.img, 2, protocol.CodecPNG)) {
+		return nil
+	}
+	for i := 1; i <= frames; i++ {
+		r := image.Rect(0, 0, 100, 60).Add(image.Pt(i*37%(w-100), i*23%(h-60)))
+		scr.draw(r, i)
+		if !send(dirtyFrame(t, scr.img, []image.Rectangle{r}, protocol.CodecPNG)) {
+			return nil
+		}
+	}
+	return own.screen.Copy().Pix
+}
+
+// TestManyStudents streams several clients into one server while a UI
+// goroutine reads their screens, images and thumbnails the way layout
+// does. Run it with -race: the decoders write the screens the UI reads.
+func TestManyStudents(t *testing.T) {
+	const students, frames = 6, 20
+
+	s := NewServer()
+	ds := NewDashboardState(nil)
+	s.studentUtil = ds
+	s.isRunning.Store(true)
+	defer s.Stop()
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// One handler per student, counted before any client dials
+	var handlers sync.WaitGroup
+	handlers.Add(students)
+	go func() {
+		for i := 0; i < students; i++ {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				t.Error(err)
+				handlers.Add(i - students)
+				return
+			}
+			go func() {
+				defer handlers.Done()
+				s.handleStudent(conn)
+			}()
+		}
+	}()
+
+	// The first student is open in the viewer
+	ids := make([]string, students)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("s%d", i)
+	}
+	s.SetViewed(ids[0], true)
+
+	var done atomic.Bool
+	ui := make(chan struct{})
+	go func() {
+		defer close(ui)
+		violated := false
+		for !done.Load() {
+			for _, student := range ds.studentManager.GetSorted() {
+				ds.imgCache.GetImageOp(student)
+				ds.thumbs.Get(student.Id)
+				for _, v := range student.Violations {
+					if v.Screenshot != nil {
+						v.Screenshot.Bounds()
+					}
+				}
+				if !violated && student.Id == ids[0] && student.Screen != nil {
+					ds.AddViolation(student.Id, ViolationReport{})
+					violated = true
+				}
+			}
+		}
+	}()
+
+	want := make([][]byte, students)
+	var clients sync.WaitGroup
+	for i, id := range ids {
+		clients.Add(1)
+		go func(i int, id string) {
+			defer clients.Done()
+			want[i] = streamScreen(t, listener.Addr(), id, i == 0, frames)
+		}(i, id)
+	}
+	clients.Wait()
+	// Every frame sent has been decoded once the handlers see the clients leave
+	handlers.Wait()
+	done.Store(true)
+	<-ui
+
+	for i, id := range ids {
+		student := ds.studentManager.GetByID(id)
+		if student == nil || student.Screen == nil {
+			t.Errorf("%s: no screen", id)
+			continue
+		}
+		if got := student.Screen.Copy(); !bytes.Equal(got.Pix, want[i]) {
+			t.Errorf("%s: the screen differs from the frames sent", id)
+		}
+		if i == 0 {
+			if size := student.Screen.Size(); size.X != 640 {
+				t.Errorf("%s: viewed screen is %v, want full resolution", id, size)
+			}
+		}
+	}
+}
@@ -5,16 +5,99 @@ import (
 
 	"image"
 
+	"gioui.org/op/paint"
 	"gioui.org/widget"
 )
 
+const (
+	MAX_VIOLATIONS       = 50               // Violations kept per student
+	MAX_EVENTS           = 100              // Desktop events kept per student
+	SCREENSHOT_TIMEOUT   = 10 * time.Second // Wait for a full-resolution keyframe after a violation
+	MAX_SCREENSHOT_BYTES = 32 * 1024 * 1024 // Screenshots kept per student; the oldest are dropped first
+)
+
+// StudentEvent is a desktop event as received by the server.
+type StudentEvent struct {
+	EventReport
+	Received time.Time
+}
+
+// Violation is a reported policy breach with the screenshot that followed
+// it. Screenshot stays nil if no full-resolution keyframe arrived within
+// SCREENSHOT_TIMEOUT, and is dropped again once newer screenshots take up
+// MAX_SCREENSHOT_BYTES.
+type Violation struct {
+	ViolationReport
+	// Seq identifies the violation among the student's violations.
+	Seq        int
+	Received   time.Time
+	Screenshot image.Image
+	// ScreenshotOp is set together with Screenshot.
+	ScreenshotOp paint.ImageOp
+	Clickable    *widget.Clickable
+	// shot is set once a screenshot was attached, so a dropped one is
+	// never replaced by a later screen.
+	shot bool
+}
+
+// Student is owned by StudentManager and only modified under its lock.
+// The UI works on copies from Snapshot.
 type Student struct {
-	Id        string
-	Name      string
-	Image     image.Image
-	ImagePtr  uintptr
+	Id   string
+	Name string
+	// Screen is the student's decoded screen, nil before the first frame.
+	// It is shared with the decoder, so it is only read through Screen.Read.
+	Screen    *Screen
 	Timestamp time.Time
 	Clickable *widget.Clickable
+
+	// Machine is the latest environment report from the client.
+	Machine *MachineReport
+	// PrevMachine is set when the student has reported from a different machine before.
+	PrevMachine *MachineReport
+
+	// Foreground is the student's focused window.
+	Foreground WindowInfo
+	// Processes is the latest reported process list.
+	Processes []ProcessInfo
+	// BlockedProcesses lists running process names that match the blocklist.
+	BlockedProcesses []string
+
+	// Violations are policy breaches reported by the client, oldest first.
+	Violations []*Violation
+	// needsScreenshot is set from a violation until the next full-resolution
+	// keyframe arrives, or screenshotDeadline passes.
+	needsScreenshot    bool
+	screenshotDeadline time.Time
+	lastSeq            int
+
+	// Events are desktop events reported by the client, oldest first.
+	Events []StudentEvent
+
+	// IdleSince is when the student's last input happened; zero while active.
+	IdleSince time.Time
+
+	// BlankSince is when the capture turned a single colour, zero while it
+	// shows content; BlankColor is that colour as #rrggbb.
+	BlankSince time.Time
+	BlankColor string
+
+	// Cursor is the student's mouse pointer, nil until reported. It is
+	// immutable, so snapshots share it.
+	Cursor *StudentCursor
+
+	// Capture is the capture target the client last reported, with the
+	// reason in Error if it rejected the one asked for. An empty Mode is
+	// the whole display.
+	Capture CaptureTarget
+
+	// CaptureStatus is the client's capture backend and its health, empty
+	// until reported.
+	CaptureStatus CaptureStatus
+
+	// Throttle is how far the client slowed itself down to stay within its
+	// CPU and memory budget; level 0 until reported.
+	Throttle ThrottleReport
 }
 
 func NewStudent(id, name string) *Student {
@@ -26,11 +109,138 @@ func NewStudent(id, name string) *Student {
 	}
 }
 
-func (s *Student) UpdateImage(img image.Image) {
-	s.Image = img
-	s.ImagePtr = uintptr(0)
-	if img != nil {
-		s.ImagePtr = uintptr(img.Bounds().Min.X)<<32 | uintptr(img.Bounds().Min.Y)
+// Snapshot returns a copy of the student that stays consistent while the
+// network goroutines keep updating the original.
+func (s *Student) Snapshot() *Student {
+	snap := *s
+	snap.Violations = make([]*Violation, len(s.Violations))
+	for i, v := range s.Violations {
+		copied := *v
+		snap.Violations[i] = &copied
 	}
+	snap.Events = append([]StudentEvent(nil), s.Events...)
+	return &snap
+}
+
+// UpdateImage records a decoded frame. fullKey marks a full-resolution
+// keyframe; dirty frames and thumbnails never become screenshots.
+func (s *Student) UpdateImage(screen *Screen, fullKey bool) {
+	s.Screen = screen
 	s.Timestamp = time.Now()
+
+	if !s.needsScreenshot {
+		return
+	}
+	switch {
+	case s.Timestamp.After(s.screenshotDeadline):
+		s.needsScreenshot = false
+	case fullKey && screen != nil:
+		if img := screen.Copy(); img != nil {
+			s.attachScreenshot(img)
+		}
+	}
+}
+
+// UpdateIdle applies an idle report. The start time is derived locally from
+// the reported idle duration so client clock skew does not matter.
+func (s *Student) UpdateIdle(report IdleReport) {
+	if report.Idle {
+		s.IdleSince = time.Now().Add(-time.Duration(report.IdleMs) * time.Millisecond)
+	} else {
+		s.IdleSince = time.Time{}
+	}
+}
+
+// UpdateBlank applies a blank capture report, timed locally like UpdateIdle.
+func (s *Student) UpdateBlank(report BlankReport) {
+	if report.Blank {
+		s.BlankSince = time.Now().Add(-time.Duration(report.BlankMs) * time.Millisecond)
+		s.BlankColor = report.Color
+	} else {
+		s.BlankSince = time.Time{}
+		s.BlankColor = ""
+	}
+}
+
+// UpdateCursor replaces the pointer with the reported one.
+func (s *Student) UpdateCursor(report CursorReport) {
+	s.Cursor = newStudentCursor(s.Cursor, report)
+}
+
+// AddViolation records a violation; its screenshot is taken from the next
+// full-resolution keyframe.
+func (s *Student) AddViolation(report ViolationReport) {
+	s.lastSeq++
+	s.Violations = append(s.Violations, &Violation{
+		ViolationReport: report,
+		Seq:             s.lastSeq,
+		Received:        time.Now(),
+		Clickable:       new(widget.Clickable),
+	})
+	if len(s.Violations) > MAX_VIOLATIONS {
+		s.Violations = s.Violations[1:]
+	}
+	s.needsScreenshot = true
+	s.screenshotDeadline = time.Now().Add(SCREENSHOT_TIMEOUT)
+}
+
+// AddEvent records a desktop event, dropping the oldest beyond MAX_EVENTS.
+func (s *Student) AddEvent(report EventReport) {
+	s.Events = append(s.Events, StudentEvent{EventReport: report, Received: time.Now()})
+	if len(s.Events) > MAX_EVENTS {
+		s.Events = s.Events[1:]
+	}
+}
+
+// waitingForScreenshot reports whether a screenshot taken at now still
+// shows what the violation was about.
+func (v *Violation) waitingForScreenshot(now time.Time) bool {
+	return !v.shot && now.Before(v.Received.Add(SCREENSHOT_TIMEOUT))
+}
+
+// attachScreenshot attaches img, a copy of the screen that is never
+// modified, to every violation still waiting for one. Violations whose
+// wait timed out stay without.
+func (s *Student) attachScreenshot(img image.Image) {
+	now := time.Now()
+	op := paint.NewImageOp(img)
+	for _, v := range s.Violations {
+		if v.waitingForScreenshot(now) {
+			v.Screenshot = img
+			v.ScreenshotOp = op
+			v.shot = true
+		}
+	}
+	s.needsScreenshot = false
+	s.trimScreenshots()
+}
+
+// trimScreenshots drops the oldest screenshots until the rest fit
+// MAX_SCREENSHOT_BYTES. The newest is kept even if it alone doesn't, and
+// violations sharing a screenshot count it once.
+func (s *Student) trimScreenshots() {
+	kept := make(map[image.Image]bool)
+	total := 0
+	for i := len(s.Violations) - 1; i >= 0; i-- {
+		v := s.Violations[i]
+		if v.Screenshot == nil || kept[v.Screenshot] {
+			continue
+		}
+		if size := imageBytes(v.Screenshot); total == 0 || total+size <= MAX_SCREENSHOT_BYTES {
+			kept[v.Screenshot] = true
+			total += size
+			continue
+		}
+		v.Screenshot = nil
+		v.ScreenshotOp = paint.ImageOp{}
+	}
+}
+
+// imageBytes returns the memory an image's pixels take.
+func imageBytes(img image.Image) int {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return len(rgba.Pix)
+	}
+	size := img.Bounds().Size()
+	return size.X * size.Y * 4
 }
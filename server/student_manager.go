@@ -1,7 +1,6 @@
 package main
 
 import (
-	"image"
 	"sort"
 	"strings"
 	"sync"
@@ -16,6 +15,13 @@ type StudentManager struct {
 	needsResort    bool
 	lastSortTime   time.Time
 	mu             sync.Mutex
+
+	// machines keeps every distinct machine a student has reported from.
+	// It outlives Remove so reconnects from another machine are still caught.
+	machines map[string][]MachineReport
+
+	// blocklist holds lower-cased process names flagged on student cards.
+	blocklist []string
 }
 
 func NewStudentManager() *StudentManager {
@@ -25,6 +31,7 @@ func NewStudentManager() *StudentManager {
 		sortField:      "name",
 		sortAsc:        true,
 		needsResort:    true,
+		machines:       make(map[string][]MachineReport),
 	}
 }
 
@@ -53,7 +60,7 @@ func (sm *StudentManager) Exists(id string) bool {
 	return ok
 }
 
-func (sm *StudentManager) UpdateImage(id string, img image.Image) {
+func (sm *StudentManager) UpdateImage(id string, screen *Screen, fullKey bool) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -61,7 +68,7 @@ func (sm *StudentManager) UpdateImage(id string, img image.Image) {
 	if !ok {
 		return
 	}
-	student.UpdateImage(img)
+	student.UpdateImage(screen, fullKey)
 }
 
 func (sm *StudentManager) UpdateName(id, name string) {
@@ -78,6 +85,175 @@ func (sm *StudentManager) UpdateName(id, name string) {
 	}
 }
 
+func (sm *StudentManager) UpdateMachine(id string, report MachineReport) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	history := sm.machines[id]
+	if len(history) == 0 || !history[len(history)-1].SameMachine(report) {
+		history = append(history, report)
+	} else {
+		history[len(history)-1] = report
+	}
+	sm.machines[id] = history
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.Machine = &report
+	student.PrevMachine = nil
+	if len(history) > 1 {
+		prev := history[len(history)-2]
+		student.PrevMachine = &prev
+	}
+}
+
+func (sm *StudentManager) UpdateActivity(id string, report ActivityReport) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.Foreground = report.Foreground
+	student.Processes = report.Processes
+	student.BlockedProcesses = sm.matchBlocklist(report.Processes)
+}
+
+func (sm *StudentManager) AddViolation(id string, report ViolationReport) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.AddViolation(report)
+}
+
+func (sm *StudentManager) UpdateIdle(id string, report IdleReport) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.UpdateIdle(report)
+}
+
+func (sm *StudentManager) UpdateBlank(id string, report BlankReport) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.UpdateBlank(report)
+}
+
+func (sm *StudentManager) AddEvent(id string, report EventReport) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.AddEvent(report)
+}
+
+func (sm *StudentManager) UpdateCursor(id string, report CursorReport) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.UpdateCursor(report)
+}
+
+func (sm *StudentManager) UpdateCapture(id string, target CaptureTarget) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.Capture = target
+}
+
+func (sm *StudentManager) UpdateThrottle(id string, report ThrottleReport) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.Throttle = report
+}
+
+func (sm *StudentManager) UpdateCaptureStatus(id string, status CaptureStatus) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	student, ok := sm.students[id]
+	if !ok {
+		return
+	}
+	student.CaptureStatus = status
+}
+
+// SetBlocklist replaces the process blocklist and re-flags every student.
+func (sm *StudentManager) SetBlocklist(names []string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.blocklist = sm.blocklist[:0]
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			sm.blocklist = append(sm.blocklist, name)
+		}
+	}
+
+	for _, student := range sm.students {
+		student.BlockedProcesses = sm.matchBlocklist(student.Processes)
+	}
+}
+
+// matchBlocklist returns the distinct process names containing a blocked name.
+// Must be called with sm.mu held.
+func (sm *StudentManager) matchBlocklist(procs []ProcessInfo) []string {
+	if len(sm.blocklist) == 0 {
+		return nil
+	}
+
+	var matched []string
+	seen := make(map[string]bool)
+	for _, p := range procs {
+		name := strings.ToLower(p.Name)
+		if seen[name] {
+			continue
+		}
+		for _, blocked := range sm.blocklist {
+			if strings.Contains(name, blocked) {
+				matched = append(matched, p.Name)
+				seen[name] = true
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// GetSorted returns snapshots of all students in display order.
 func (sm *StudentManager) GetSorted() []*Student {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -105,7 +281,11 @@ func (sm *StudentManager) GetSorted() []*Student {
 		sm.lastSortTime = time.Now()
 	}
 
-	return sm.sortedStudents
+	snapshots := make([]*Student, len(sm.sortedStudents))
+	for i, student := range sm.sortedStudents {
+		snapshots[i] = student.Snapshot()
+	}
+	return snapshots
 }
 
 func (sm *StudentManager) SetSortField(field string) {
@@ -125,10 +305,16 @@ func (sm *StudentManager) ToggleSortDirection() {
 }
 
 func (sm *StudentManager) GetSortField() string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
 	return sm.sortField
 }
 
 func (sm *StudentManager) IsSortAscending() bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
 	return sm.sortAsc
 }
 
@@ -138,6 +324,7 @@ func (sm *StudentManager) Clear() {
 
 	sm.students = make(map[string]*Student)
 	sm.sortedStudents = make([]*Student, 0)
+	sm.machines = make(map[string][]MachineReport)
 	sm.needsResort = true
 }
 
@@ -148,9 +335,14 @@ func (sm *StudentManager) Count() int {
 	return len(sm.students)
 }
 
+// GetByID returns a snapshot of the student, or nil.
 func (sm *StudentManager) GetByID(id string) *Student {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	return sm.students[id]
+	student, ok := sm.students[id]
+	if !ok {
+		return nil
+	}
+	return student.Snapshot()
 }
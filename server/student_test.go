@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+// stillScreen returns a published w×h screen.
+func stillScreen(w, h int) *Screen {
+	return &Screen{bufs: [2]*image.RGBA{image.NewRGBA(image.Rect(0, 0, w, h))}}
+}
+
+func TestViolationScreenshots(t *testing.T) {
+	s := NewStudent("s1", "Ada")
+	s.AddViolation(ViolationReport{Rule: "title", Detail: "chat"})
+	s.AddViolation(ViolationReport{Rule: "process", Detail: "discord"})
+	// The first timed out waiting for a keyframe long ago
+	stale := s.Violations[0]
+	stale.Received = time.Now().Add(-2 * SCREENSHOT_TIMEOUT)
+
+	screen := stillScreen(64, 48)
+	s.UpdateImage(screen, false)
+	if s.Violations[1].Screenshot != nil {
+		t.Fatal("a dirty frame became a screenshot")
+	}
+
+	s.UpdateImage(screen, true)
+	if stale.Screenshot != nil {
+		t.Error("a violation past its deadline got a later screenshot")
+	}
+	if s.Violations[1].Screenshot == nil {
+		t.Error("a waiting violation got no screenshot")
+	}
+
+	// The next keyframe is nobody's evidence
+	shot := s.Violations[1].Screenshot
+	s.UpdateImage(stillScreen(64, 48), true)
+	if s.Violations[1].Screenshot != shot || stale.Screenshot != nil {
+		t.Error("a keyframe without a new violation replaced or added screenshots")
+	}
+}
+
+func TestScreenshotMemoryCap(t *testing.T) {
+	const w, h = 1920, 1080
+	perShot := w * h * 4
+	s := NewStudent("s1", "Ada")
+	for i := 0; i < MAX_SCREENSHOT_BYTES/perShot+3; i++ {
+		s.AddViolation(ViolationReport{Rule: "title", Detail: "chat"})
+		s.AddViolation(ViolationReport{Rule: "title", Detail: "notes"})
+		s.UpdateImage(stillScreen(w, h), true)
+	}
+
+	total := 0
+	seen := make(map[image.Image]bool)
+	for _, v := range s.Violations {
+		if v.Screenshot != nil && !seen[v.Screenshot] {
+			seen[v.Screenshot] = true
+			total += imageBytes(v.Screenshot)
+		}
+	}
+	if total > MAX_SCREENSHOT_BYTES {
+		t.Errorf("screenshots take %d bytes, want at most %d", total, MAX_SCREENSHOT_BYTES)
+	}
+	if s.Violations[0].Screenshot != nil {
+		t.Error("the oldest screenshot was kept over the newest")
+	}
+	last := s.Violations[len(s.Violations)-2:]
+	if last[0].Screenshot == nil || last[0].Screenshot != last[1].Screenshot {
+		t.Error("the newest violations lost their shared screenshot")
+	}
+
+	// A screenshot over the cap on its own is still kept
+	s.AddViolation(ViolationReport{Rule: "title", Detail: "chat"})
+	s.UpdateImage(stillScreen(4096, 4096), true)
+	if s.Violations[len(s.Violations)-1].Screenshot == nil {
+		t.Error("an oversized newest screenshot was dropped")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"gioui.org/op/paint"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Thumbnail pacing. Each student's card is refreshed at most once per
+// THUMB_INTERVAL however fast frames arrive.
+const (
+	THUMB_INTERVAL = 500 * time.Millisecond
+	THUMB_TICK     = 100 * time.Millisecond
+)
+
+// Thumbnail is a card-sized copy of a student's screen, ready to draw.
+type Thumbnail struct {
+	Op   paint.ImageOp
+	Size image.Point
+}
+
+type thumbEntry struct {
+	pending  *Screen // Screen with a frame not yet turned into a thumbnail
+	made     time.Time
+	ready    Thumbnail
+	hasThumb bool
+}
+
+// thumbnailer turns student screens into card thumbnails on a background
+// goroutine, so layout only picks up finished images and never scales or
+// uploads a full screen itself.
+type thumbnailer struct {
+	mu      sync.Mutex
+	entries map[string]*thumbEntry
+}
+
+func newThumbnailer() *thumbnailer {
+	t := &thumbnailer{entries: make(map[string]*thumbEntry)}
+	go t.run()
+	return t
+}
+
+// Submit marks a student's screen as having a new frame. Frames submitted
+// faster than THUMB_INTERVAL replace each other; only the newest is scaled.
+func (t *thumbnailer) Submit(id string, screen *Screen) {
+	if screen == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[id]
+	if !ok {
+		entry = &thumbEntry{}
+		t.entries[id] = entry
+	}
+	entry.pending = screen
+}
+
+// Get returns the latest finished thumbnail for a student.
+func (t *thumbnailer) Get(id string) (Thumbnail, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[id]
+	if !ok || !entry.hasThumb {
+		return Thumbnail{}, false
+	}
+	return entry.ready, true
+}
+
+func (t *thumbnailer) Remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, id)
+}
+
+func (t *thumbnailer) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = make(map[string]*thumbEntry)
+}
+
+func (t *thumbnailer) run() {
+	ticker := time.NewTicker(THUMB_TICK)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for id, screen := range t.due() {
+			var thumb Thumbnail
+			ok := screen.Read(func(img *image.RGBA, _ uint64) {
+				thumb = makeThumbnail(img)
+			})
+			if !ok {
+				continue
+			}
+
+			t.mu.Lock()
+			// The student may have left while the thumbnail was scaled
+			if entry, ok := t.entries[id]; ok {
+				entry.ready = thumb
+				entry.hasThumb = true
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// due takes the pending screens of students whose last thumbnail is older
+// than THUMB_INTERVAL.
+func (t *thumbnailer) due() map[string]*Screen {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var due map[string]*Screen
+	for id, entry := range t.entries {
+		if entry.pending == nil || now.Sub(entry.made) < THUMB_INTERVAL {
+			continue
+		}
+		if due == nil {
+			due = make(map[string]*Screen)
+		}
+		due[id] = entry.pending
+		entry.pending = nil
+		entry.made = now
+	}
+	return due
+}
+
+// makeThumbnail copies img at no more than THUMB_WIDTH wide. The copy is
+// never modified afterwards, so the UI can upload it at any time, while img
+// goes back to the decoder.
+func makeThumbnail(img image.Image) Thumbnail {
+	bounds := img.Bounds()
+	size := thumbSize(bounds.Dx(), bounds.Dy())
+
+	thumb := image.NewRGBA(image.Rectangle{Max: size})
+	if size == bounds.Size() {
+		xdraw.Copy(thumb, image.Point{}, img, bounds, xdraw.Src, nil)
+	} else {
+		xdraw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, bounds, xdraw.Src, nil)
+	}
+
+	return Thumbnail{Op: paint.NewImageOp(thumb), Size: size}
+}
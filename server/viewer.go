@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"image"
 	"image/color"
 
 	"gioui.org/layout"
@@ -19,12 +21,17 @@ var (
 	textMuted        = color.NRGBA{R: 100, G: 116, B: 139, A: 255} // Slate-500
 )
 
+// maxViewerListItems caps the entries shown in the viewer side panel.
+const maxViewerListItems = 15
+
 func LayoutViewer(
 	gtx layout.Context,
 	th *material.Theme,
 	student *Student,
 	imgCache *ImageCacheManager,
 	btnClose *widget.Clickable,
+	capture *CaptureControls,
+	shown *int,
 ) layout.Dimensions {
 	paint.FillShape(gtx.Ops, overlayColor, clip.Rect{Max: gtx.Constraints.Max}.Op())
 
@@ -63,44 +70,211 @@ func LayoutViewer(
 					)
 				})
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutMachineInfo(gtx, th, student)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutCaptureControls(gtx, th, student, capture)
+			}),
 			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
-				if student.Image == nil {
-					return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-						label := material.H6(th, "No image available")
-						label.Color = textMuted
-						return label.Layout(gtx)
-					})
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layoutViewerImage(gtx, th, student, imgCache, *shown)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layoutSidePanel(gtx, th, student, shown)
+					}),
+				)
+			}),
+		)
+	})
+}
+
+func layoutMachineInfo(gtx layout.Context, th *material.Theme, student *Student) layout.Dimensions {
+	if student.Machine == nil {
+		return layout.Dimensions{}
+	}
+
+	return layout.Inset{Bottom: unit.Dp(16)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				label := material.Body2(th, student.Machine.Summary())
+				label.Color = textMuted
+				label.MaxLines = 1
+				return label.Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if student.PrevMachine == nil {
+					return layout.Dimensions{}
 				}
+				return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					label := material.Body2(th, "⚠  Machine changed from "+student.PrevMachine.Summary())
+					label.Color = dangerColor
+					label.MaxLines = 1
+					return label.Layout(gtx)
+				})
+			}),
+		)
+	})
+}
+
+// findViolation returns the student's violation with the given Seq, or nil.
+func findViolation(student *Student, seq int) *Violation {
+	for _, v := range student.Violations {
+		if v.Seq == seq {
+			return v
+		}
+	}
+	return nil
+}
 
-				imgOp := imgCache.GetImageOp(student)
-				imgSize := student.Image.Bounds().Size()
+func layoutViewerImage(gtx layout.Context, th *material.Theme, student *Student, imgCache *ImageCacheManager, shownSeq int) layout.Dimensions {
+	var imgOp paint.ImageOp
+	var imgSize image.Point
 
-				availableWidth := gtx.Constraints.Max.X
-				availableHeight := gtx.Constraints.Max.Y
+	// The pointer is only drawn over the live screen
+	cursor := student.Cursor
+	if shown := findViolation(student, shownSeq); shown != nil && shown.Screenshot != nil {
+		cursor = nil
+		imgOp = shown.ScreenshotOp
+		imgSize = shown.Screenshot.Bounds().Size()
+	} else if op, size, ok := imgCache.GetImageOp(student); ok {
+		imgOp, imgSize = op, size
+	} else {
+		return layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			label := material.H6(th, "No image available")
+			label.Color = textMuted
+			return label.Layout(gtx)
+		})
+	}
 
-				scaleX := float32(availableWidth) / float32(imgSize.X)
-				scaleY := float32(availableHeight) / float32(imgSize.Y)
-				scale := scaleX
-				if scaleY < scaleX {
-					scale = scaleY
-				}
+	availableWidth := gtx.Constraints.Max.X
+	availableHeight := gtx.Constraints.Max.Y
 
-				scaledWidth := int(float32(imgSize.X) * scale)
-				scaledHeight := int(float32(imgSize.Y) * scale)
+	scaleX := float32(availableWidth) / float32(imgSize.X)
+	scaleY := float32(availableHeight) / float32(imgSize.Y)
+	scale := scaleX
+	if scaleY < scaleX {
+		scale = scaleY
+	}
 
-				offsetX := (availableWidth - scaledWidth) / 2
-				offsetY := (availableHeight - scaledHeight) / 2
+	scaledWidth := int(float32(imgSize.X) * scale)
+	scaledHeight := int(float32(imgSize.Y) * scale)
 
-				return layout.Inset{
-					Left: unit.Dp(float32(offsetX) / gtx.Metric.PxPerDp),
-					Top:  unit.Dp(float32(offsetY) / gtx.Metric.PxPerDp),
-				}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-					return widget.Image{
-						Src:   imgOp,
-						Scale: scale,
-					}.Layout(gtx)
-				})
+	offsetX := (availableWidth - scaledWidth) / 2
+	offsetY := (availableHeight - scaledHeight) / 2
+
+	return layout.Inset{
+		Left: unit.Dp(float32(offsetX) / gtx.Metric.PxPerDp),
+		Top:  unit.Dp(float32(offsetY) / gtx.Metric.PxPerDp),
+	}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		dims := widget.Image{
+			Src:   imgOp,
+			Scale: scale,
+		}.Layout(gtx)
+		layoutCursor(gtx, cursor, dims.Size)
+		return dims
+	})
+}
+
+// layoutSidePanel shows the student's violations and desktop events next to the image.
+func layoutSidePanel(gtx layout.Context, th *material.Theme, student *Student, shown *int) layout.Dimensions {
+	if len(student.Violations) == 0 && len(student.Events) == 0 {
+		return layout.Dimensions{}
+	}
+
+	width := gtx.Dp(unit.Dp(280))
+	gtx.Constraints.Min.X = width
+	gtx.Constraints.Max.X = width
+
+	return layout.Inset{Left: unit.Dp(16)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutViolations(gtx, th, student, shown)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layoutEvents(gtx, th, student)
 			}),
 		)
 	})
 }
+
+// layoutViolations lists the student's violations, newest first. Clicking an
+// entry shows the screenshot taken right after it; clicking again returns to live.
+// shown holds the Seq of the violation on screen, 0 for the live image.
+func layoutViolations(gtx layout.Context, th *material.Theme, student *Student, shown *int) layout.Dimensions {
+	if len(student.Violations) == 0 {
+		return layout.Dimensions{}
+	}
+
+	for _, v := range student.Violations {
+		if v.Clickable.Clicked(gtx) {
+			if *shown == v.Seq {
+				*shown = 0
+			} else {
+				*shown = v.Seq
+			}
+		}
+	}
+
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			label := material.Body1(th, fmt.Sprintf("Violations (%d)", len(student.Violations)))
+			label.Color = dangerColor
+			return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, label.Layout)
+		}),
+	}
+
+	for i := len(student.Violations) - 1; i >= 0 && len(children) <= maxViewerListItems; i-- {
+		v := student.Violations[i]
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return material.Clickable(gtx, v.Clickable, func(gtx layout.Context) layout.Dimensions {
+				return layout.UniformInset(unit.Dp(4)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					text := v.Received.Format("15:04:05") + "  " + v.Description()
+					label := material.Body2(th, text)
+					label.Color = textDark
+					if *shown == v.Seq {
+						label.Color = dangerColor
+					}
+					label.MaxLines = 2
+					label.TextSize = unit.Sp(12)
+					return label.Layout(gtx)
+				})
+			})
+		}))
+	}
+
+	return layout.Inset{Bottom: unit.Dp(16)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+	})
+}
+
+// layoutEvents lists the student's desktop events, newest first.
+func layoutEvents(gtx layout.Context, th *material.Theme, student *Student) layout.Dimensions {
+	if len(student.Events) == 0 {
+		return layout.Dimensions{}
+	}
+
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			label := material.Body1(th, fmt.Sprintf("Events (%d)", len(student.Events)))
+			label.Color = textDark
+			return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, label.Layout)
+		}),
+	}
+
+	for i := len(student.Events) - 1; i >= 0 && len(children) <= maxViewerListItems; i-- {
+		e := student.Events[i]
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.UniformInset(unit.Dp(4)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				label := material.Body2(th, e.Received.Format("15:04:05")+"  "+e.Description())
+				label.Color = textMuted
+				label.MaxLines = 1
+				label.TextSize = unit.Sp(12)
+				return label.Layout(gtx)
+			})
+		}))
+	}
+
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}